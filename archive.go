@@ -0,0 +1,91 @@
+package bltfs
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Archive recursively copies the local directory tree src into the store
+// directory dst, creating dst if necessary. Symbolic links are recorded as
+// links in the store; they are neither followed nor skipped. Other special
+// files (devices, sockets, etc.) are skipped.
+func (s *Store) Archive(src, dst string) error {
+	src = filepath.Clean(src)
+
+	if err := s.MkdirAll(dst); err != nil {
+		return err
+	}
+
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		// process possible error first
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		// the source root itself maps to dst
+		if rel == "." {
+			return nil
+		}
+
+		name := filepath.Join(dst, rel)
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if err := s.Mkdir(name); err != nil {
+				return errors.Wrapf(err, "failed to create directory '%s' in store", name)
+			}
+
+		case mode&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return errors.Wrapf(err, "failed to read link '%s'", path)
+			}
+
+			if err := s.Symlink(target, name); err != nil {
+				return errors.Wrapf(err, "failed to create link '%s' in store", name)
+			}
+
+		case mode.IsRegular():
+			if err := s.archiveFile(path, name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// archiveFile copies the local file at path to name in the store.
+func (s *Store) archiveFile(path, name string) error {
+	// open local file
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open file '%s'", path)
+	}
+	defer f.Close()
+
+	// create the remote file
+	rf, err := s.Create(name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create file '%s' in store", name)
+	}
+
+	// copy the contents efficiently (use the block size of the device)
+	if _, err := s.Copy(rf, f); err != nil {
+		return errors.Wrapf(err, "failed to copy file '%s'", path)
+	}
+
+	// close the remote file
+	if err := rf.Close(); err != nil {
+		return errors.Wrapf(err, "failed to close remote file '%s'", rf)
+	}
+
+	return nil
+}
@@ -59,3 +59,11 @@ type Interface interface {
 	// SetPartitions sets the active partition.
 	SetPartition(part uint32) error
 }
+
+// CapacityReporter is implemented by backends that can report the capacity of
+// their partitions.
+type CapacityReporter interface {
+	// Capacity returns the total and the remaining capacity of the partition
+	// in bytes.
+	Capacity(part uint32) (total, remaining uint64, err error)
+}
@@ -34,7 +34,10 @@ const (
 	DefaultBlockSize = 512 * 1024
 )
 
-var _ backend.Interface = &device{}
+var (
+	_ backend.Interface        = &device{}
+	_ backend.CapacityReporter = &device{}
+)
 
 type position struct {
 	blk  uint64
@@ -401,6 +404,33 @@ func (d *device) Locate(part uint32, block uint64) error {
 	return nil
 }
 
+// Capacity is part of the backend.CapacityReporter interface. The remaining
+// capacity is estimated from the number of blocks up to EOD, as if all records
+// were of the block size.
+func (d *device) Capacity(part uint32) (total, remaining uint64, err error) {
+	if !d.ready {
+		return 0, 0, bltfs.ErrNotReady
+	}
+
+	if part >= d.partitions {
+		return 0, 0, errors.Errorf("invalid partition %d", part)
+	}
+
+	// the cartridge capacity is configured in megabytes
+	total = d.capacity(int(part)) * 1024 * 1024
+
+	end := d.eod[part]
+	if end == EODMissing {
+		end = d.last[part]
+	}
+
+	if used := end * d.blkSize; used < total {
+		remaining = total - used
+	}
+
+	return total, remaining, nil
+}
+
 func (d *device) capacity(part int) uint64 {
 	switch part {
 	case 0:
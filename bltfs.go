@@ -2,10 +2,17 @@ package bltfs
 
 import (
 	"context"
-	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
 
 	"hpt.space/bltfs/backend"
+	"hpt.space/bltfs/engine"
+	"hpt.space/bltfs/engine/memory"
 	"hpt.space/bltfs/ltfs"
 )
 
@@ -30,8 +37,13 @@ var (
 
 // Store is a bLTFS store.
 type Store struct {
-	idx *index
-	rw  *synchronizedWriter
+	idx    *index
+	idxdir string
+	rw     *synchronizedWriter
+
+	// the meta of the cached binary index; nil if the binary index is not
+	// cached
+	cache *indexMeta
 
 	mu struct {
 		sync.Mutex
@@ -39,10 +51,38 @@ type Store struct {
 	}
 
 	ltfs struct {
+		label *ltfs.LabelLTFS
+		pmap  ltfs.PartitionMap
+
 		curr *ltfs.Index
 		prev *ltfs.Index
 	}
 
+	// the store was opened at a past generation and cannot be modified
+	readonly bool
+
+	// the volume lock state as recorded in the index
+	lock struct {
+		sync.Mutex
+		state string
+	}
+
+	// the changes since the store was mounted
+	journal journal
+
+	// the next file UID to hand out, unless the binary index has allocated
+	// further (see allocUID)
+	uids struct {
+		sync.Mutex
+		next uint64
+	}
+
+	placement struct {
+		sync.Mutex
+		allowUpdate bool
+		pol         ltfs.DataPlacementPolicy
+	}
+
 	sopts storeOptions
 }
 
@@ -62,71 +102,221 @@ func OpenContext(ctx context.Context, backend backend.Interface, opts ...StoreOp
 
 	s.sopts.blkSize = 512 * 1024
 	s.sopts.pol = DefaultRecoveryPolicy
+	s.sopts.version = ltfs.Version
 
 	for _, opt := range opts {
 		opt(&s.sopts)
 	}
 
+	if _, err := ltfs.ParseVersion(s.sopts.version); err != nil {
+		return nil, errors.Wrap(err, "invalid index version")
+	}
+
+	if s.sopts.cacheDir != "" && s.sopts.engine != BoltEngine {
+		return nil, errors.New("the index cache requires the bolt engine")
+	}
+
 	// initialize
 	if err := backend.Load(); err != nil {
 		return nil, err
 	}
 
-	// seek to EOD on data partition
-	if err := s.mu.backend.Locate(1, TapeBlockMax); err != nil {
-		return nil, err
+	// read the label to learn the partition layout of the volume
+	label, err := s.ReadLTFSLabel()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read LTFS label")
 	}
 
-	// set active partition
-	if err := s.mu.backend.SetPartition(1); err != nil {
+	pmap, err := label.PartitionMap()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid LTFS label")
+	}
+
+	s.ltfs.label = label
+	s.ltfs.pmap = pmap
+
+	// read the latest index and build the binary index from it
+	if err := s.mount(); err != nil {
+		return nil, errors.Wrap(err, "failed to mount volume")
+	}
+
+	// file data is written in records of the device block size
+	s.idx.blkSize = backend.BlockSize()
+
+	if err := s.seekEOD(); err != nil {
 		return nil, err
 	}
 
+	// the logs written from now on go back to the end of the data partition
+	eod, err := backend.ReadPosition()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read position")
+	}
+
+	s.journal.reset(eod)
+
 	return s, nil
 }
 
-// Close closes the bLTFS store.
-func (s *Store) Close() error {
-	return nil
+// seekEOD positions the device at EOD on the data partition, where new data
+// is appended.
+func (s *Store) seekEOD() error {
+	if err := s.mu.backend.Locate(ltfs.DataPartition, TapeBlockMax); err != nil {
+		return err
+	}
+
+	// set active partition
+	return s.mu.backend.SetPartition(ltfs.DataPartition)
 }
 
-/*
-// Recover recovers a store.
-func (s *Store) Recover() error {
-	// seek to EOD on data partition
-	if err := s.mu.backend.Locate(1, TapeBlockMax); err != nil {
-		return errors.Wrap(err, "failed to seek to EOD")
+// writable returns an error if the store cannot be modified.
+func (s *Store) writable() error {
+	if s.readonly {
+		return syscall.EROFS
 	}
 
-	// Recovery
-	//
-	// We expect that no LTFS index has been written, so we space backward one
-	// file mark at a time.
-	if err := s.mu.backend.SpaceFMB(1); err != nil {
+	switch s.volumeLockState() {
+	case ltfs.VolumeLocked:
+		return ErrVolumeLocked
+	case ltfs.VolumePermLocked:
+		return ErrVolumePermLocked
+	}
+
+	return nil
+}
+
+// mount reads the latest LTFS index from the index partition (or the
+// generation selected by the store options) and builds the binary index from
+// it, unless the binary index is cached (see WithIndexCache).
+func (s *Store) mount() error {
+	part := ltfs.IndexPartition
+
+	if s.sopts.generation != 0 || !s.sopts.at.IsZero() {
+		g, err := s.selectGeneration()
+		if err != nil {
+			return errors.Wrap(err, "failed to select generation")
+		}
+
+		if err := s.mu.backend.Locate(g.Partition, g.Block); err != nil {
+			return errors.Wrap(err, "failed to locate index")
+		}
+
+		part = g.Partition
+		s.readonly = true
+	} else if err := s.locateLTFSIndex(); err != nil {
 		return err
 	}
 
-	for {
-		buf, err := ioutil.ReadAll(s.mu.backend)
+	block, err := s.mu.backend.ReadPosition()
+	if err != nil {
+		return errors.Wrap(err, "failed to read position")
+	}
+
+	// only the latest generation is cached
+	cached := s.sopts.cacheDir != "" && !s.readonly
+
+	// the highest file UID allocated by a discarded cache
+	var lastUID uint64
+
+	if cached {
+		var hit bool
+		hit, lastUID, err = s.mountCache(part, block)
 		if err != nil {
-			return err
+			return errors.Wrap(err, "failed to mount cached index")
 		}
 
-		var pblog pb.Log
-		if err := proto.Unmarshal(buf, &pblog); err != nil {
+		if hit {
+			s.setCurrent(s.cache.Index)
+			return nil
+		}
+	}
+
+	var (
+		db  engine.Interface
+		dir string
+	)
+
+	switch {
+	case cached:
+		dir = s.cacheDir()
+		db, err = openIndexDB(filepath.Join(dir, "index.db"), s.sopts.policy)
+	case s.sopts.engine == MemoryEngine:
+		db = memory.New()
+	default:
+		if dir, err = ioutil.TempDir(s.sopts.indexDir, "bltfs"); err != nil {
 			return err
 		}
 
-		switch pblog.Class {
-		case pb.Log_INC:
-			// seek to the previous incremental
-			if err := s.mu.backend.Locate(1, pblog.Prev); err != nil {
-				return err
-			}
+		db, err = openIndexDB(filepath.Join(dir, "index.db"), s.sopts.policy)
+	}
+
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	// the index is streamed from the device into the binary index
+	binIdx, idx, err := NewIndexFromReader(s.newRecordReader(), s.ltfs.pmap, db, WithIndexNamePolicy(s.sopts.policy))
+	if err == nil {
+		_, err = ltfs.ParseVersion(idx.Version)
+	}
+
+	if err == nil {
+		err = binIdx.reserveUID(lastUID)
+	}
+
+	if err != nil {
+		db.Close()
+		os.RemoveAll(dir)
+		return errors.Wrap(err, "failed to build binary index")
+	}
 
-		case pb.Log_DIFF:
+	s.idx = binIdx
 
+	if cached {
+		s.cache = s.newCacheMeta(idx, part, block)
+	} else {
+		s.idxdir = dir
+	}
+
+	s.setCurrent(idx)
+
+	return nil
+}
+
+// setCurrent makes idx (without its directory tree) the current LTFS index.
+func (s *Store) setCurrent(idx *ltfs.Index) {
+	s.ltfs.curr = idx
+
+	s.lock.state = idx.VolumeLockState
+
+	s.placement.allowUpdate = idx.AllowPolicyUpdate
+	s.placement.pol = copyPolicy(idx.DataPlacementPolicy)
+}
+
+// Close closes the bLTFS store.
+func (s *Store) Close() error {
+	if s.idx == nil {
+		return nil
+	}
+
+	if err := s.rw.flush(); err != nil {
+		s.idx.Close()
+		return errors.Wrap(err, "failed to write batched data")
+	}
+
+	if s.cache != nil {
+		if err := s.closeCache(); err != nil {
+			s.idx.Close()
+			return errors.Wrap(err, "failed to write index cache")
 		}
+
+		return s.idx.Close()
 	}
+
+	if err := s.idx.Close(); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(s.idxdir)
 }
-*/
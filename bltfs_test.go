@@ -1,6 +1,7 @@
 package bltfs_test
 
 import (
+	"encoding/xml"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -9,14 +10,15 @@ import (
 	"testing"
 	"time"
 
-	"github.com/boltdb/bolt"
 	pb "github.com/golang/protobuf/proto"
 	"github.com/kr/pretty"
-	"github.com/pkg/errors"
 
 	"hpt.space/bltfs"
+	filedebug "hpt.space/bltfs/backend/file"
+	"hpt.space/bltfs/engine/boltdb"
 	"hpt.space/bltfs/ltfs"
 	"hpt.space/bltfs/proto"
+	"hpt.space/bltfs/util/testutil"
 )
 
 const (
@@ -43,24 +45,161 @@ func cleanup(path string) {
 	}
 }
 
-func TestLargeBinaryIndex(t *testing.T) {
-	db, err := bolt.Open("idx.db", 0600, nil)
+func makeTestDirectory(uid int, name string) *ltfs.Directory {
+	return &ltfs.Directory{
+		XMLName:      xml.Name{Space: "", Local: "directory"},
+		FileUID:      uid,
+		Name:         name,
+		CreationTime: testutil.TestTime,
+		ChangeTime:   testutil.TestTime,
+		ModifyTime:   testutil.TestTime,
+		AccessTime:   testutil.TestTime,
+		BackupTime:   testutil.TestTime,
+		Contents:     &ltfs.Contents{},
+	}
+}
+
+func makeTestFile(uid int, name string, length int, block int) *ltfs.File {
+	return &ltfs.File{
+		XMLName:      xml.Name{Space: "", Local: "file"},
+		FileUID:      uid,
+		Name:         name,
+		Length:       length,
+		CreationTime: testutil.TestTime,
+		ChangeTime:   testutil.TestTime,
+		ModifyTime:   testutil.TestTime,
+		AccessTime:   testutil.TestTime,
+		BackupTime:   testutil.TestTime,
+		ExtentInfo: []*ltfs.Extent{
+			&ltfs.Extent{
+				Partition:  "b",
+				StartBlock: block,
+				ByteCount:  length,
+			},
+		},
+	}
+}
+
+// makeTestIndex returns a small LTFS index with the following layout.
+//
+//	/testfile.txt
+//	/dir/
+//	/dir/file
+func makeTestIndex() *ltfs.Index {
+	root := makeTestDirectory(1, "testvol")
+	dir := makeTestDirectory(3, "dir")
+
+	root.Contents.Files = []*ltfs.File{makeTestFile(2, "testfile.txt", 5, 4)}
+	root.Contents.Directories = []*ltfs.Directory{dir}
+	dir.Contents.Files = []*ltfs.File{makeTestFile(4, "file", 10, 5)}
+
+	return &ltfs.Index{
+		XMLName: xml.Name{Space: "", Local: "ltfsindex"},
+		IndexPreface: ltfs.IndexPreface{
+			Version:        ltfs.Version,
+			Creator:        ltfs.Creator,
+			VolumeUUID:     testutil.TestUUID,
+			Generation:     1,
+			UpdateTime:     testutil.TestTime,
+			Partition:      "a",
+			StartBlock:     3,
+			HighestFileUID: 4,
+		},
+		Root: root,
+	}
+}
+
+// makeTestTape creates a file backed tape with an LTFS label and the given
+// index recorded on both partitions. It returns the tape directory.
+func makeTestTape(t *testing.T, idx *ltfs.Index) string {
+	dir := setupCleanTape()
+
+	dev, err := filedebug.Open(dir)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer db.Close()
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucket([]byte("index"))
+	if err := dev.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	label := ltfs.LabelLTFS{
+		XMLName:        xml.Name{Space: "", Local: "ltfslabel"},
+		Version:        ltfs.Version,
+		Creator:        ltfs.Creator,
+		FormatTime:     testutil.TestTime,
+		VolumeUUID:     idx.VolumeUUID,
+		IndexPartition: "a",
+		DataPartition:  "b",
+		BlockSize:      512 * 1024,
+	}
+
+	for _, part := range []uint32{ltfs.IndexPartition, ltfs.DataPartition} {
+		if err := dev.Locate(part, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		label.Partition, _ = ltfs.DefaultPartitionMap.ID(part)
+
+		labelbuf, err := xml.Marshal(label)
 		if err != nil {
-			return errors.Wrap(err, "failed to create bucket")
+			t.Fatal(err)
 		}
-		return nil
-	})
 
+		idxbuf, err := xml.Marshal(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, rec := range [][]byte{[]byte("VOL1"), labelbuf, nil, idxbuf, nil} {
+			if rec == nil {
+				err = dev.WriteFilemark(1)
+			} else {
+				_, err = dev.Write(rec)
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := dev.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+// openTestStore opens a store on a test tape holding the given index.
+func openTestStore(t *testing.T, idx *ltfs.Index, opts ...bltfs.StoreOption) (*bltfs.Store, string) {
+	dir := makeTestTape(t, idx)
+
+	dev, err := filedebug.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := bltfs.Open(dev, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store, dir
+}
+
+func TestLargeBinaryIndex(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "bltfstest")
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer cleanup(tmp)
+
+	db, err := boltdb.Open(filepath.Join(tmp, "idx.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
 
 	begin := time.Now()
 	idx, err := ltfs.LoadIndexFromFile("./fixtures/large.schema")
@@ -69,7 +208,7 @@ func TestLargeBinaryIndex(t *testing.T) {
 	}
 	fmt.Printf("loading and parsing XML index from disk took: %v\n", time.Since(begin))
 
-	binIdx, err := bltfs.NewIndex(idx, db)
+	binIdx, err := bltfs.NewIndex(idx, ltfs.DefaultPartitionMap, db)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -102,7 +241,7 @@ func TestLargeBinaryIndex(t *testing.T) {
 	var root proto.Entry
 
 	begin = time.Now()
-	if err := proto.MarshalDirectoryRecursive(idx.Root, &root); err != nil {
+	if err := proto.MarshalDirectoryRecursive(idx.Root, &root, ltfs.DefaultPartitionMap); err != nil {
 		t.Fatal(err)
 	}
 	fmt.Printf("full protobuf tree building from ltfs.Index took: %v\n", time.Since(begin))
@@ -0,0 +1,242 @@
+package bltfs
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"hpt.space/bltfs/engine"
+	"hpt.space/bltfs/engine/boltdb"
+	"hpt.space/bltfs/ltfs"
+)
+
+// indexCacheFormat is the version of the index cache layout. Caches of other
+// versions are rebuilt.
+const indexCacheFormat = 2
+
+// indexMeta describes the LTFS index a cached binary index was built from.
+// It is stored as index.meta next to the index database and is only present
+// while the database matches that index; it is removed while the store is
+// mounted and written back on Close if the index was not modified.
+type indexMeta struct {
+	XMLName xml.Name `xml:"bltfsindexmeta"`
+	Format  int      `xml:"format"`
+
+	VolumeUUID uuid.UUID  `xml:"volumeuuid"`
+	Generation int        `xml:"generation"`
+	UpdateTime time.Time  `xml:"updatetime"`
+	Partition  uint32     `xml:"partition"`
+	Block      uint64     `xml:"block"`
+	NamePolicy NamePolicy `xml:"namepolicy"`
+
+	// the number of entries in the database, as counted in its statistics
+	Entries int `xml:"entries"`
+
+	// the LTFS index without its directory tree (see ltfs.IndexDecoder)
+	Index *ltfs.Index `xml:"ltfsindex"`
+}
+
+// cacheDir returns the directory caching the binary index of the volume.
+func (s *Store) cacheDir() string {
+	return filepath.Join(s.sopts.cacheDir, s.ltfs.label.VolumeUUID.String())
+}
+
+// mountCache mounts the binary index from the cache if it was built from the
+// index at the given location, which the device must be positioned at. It
+// returns false if the cache is missing, stale or corrupt, in which case the
+// cache directory is emptied and the device is positioned at the index again.
+// The highest file UID allocated by the discarded cache is returned with it,
+// so file UIDs handed out before a crash are not reused by the rebuilt index.
+func (s *Store) mountCache(part uint32, block uint64) (bool, uint64, error) {
+	dir := s.cacheDir()
+
+	preface, err := ltfs.DecodePreface(s.newRecordReader())
+	if err != nil {
+		return false, 0, errors.Wrap(err, "failed to read index")
+	}
+
+	// the index was read partially; reposition for a rebuild
+	miss := func() (bool, uint64, error) {
+		lastUID := cachedLastUID(filepath.Join(dir, "index.db"))
+
+		if err := os.RemoveAll(dir); err != nil {
+			return false, 0, err
+		}
+
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return false, 0, err
+		}
+
+		if err := s.mu.backend.Locate(part, block); err != nil {
+			return false, 0, errors.Wrap(err, "failed to locate index")
+		}
+
+		return false, lastUID, nil
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "index.meta"))
+	if err != nil {
+		return miss()
+	}
+
+	var meta indexMeta
+	if err := xml.Unmarshal(buf, &meta); err != nil || meta.Index == nil {
+		return miss()
+	}
+
+	// the index partition is rewritten with every generation, so the
+	// location alone does not identify the index
+	if meta.Format != indexCacheFormat ||
+		meta.VolumeUUID != preface.VolumeUUID ||
+		meta.Generation != preface.Generation ||
+		!meta.UpdateTime.Equal(time.Time(preface.UpdateTime)) ||
+		meta.Partition != part || meta.Block != block ||
+		meta.NamePolicy != s.sopts.policy {
+		return miss()
+	}
+
+	// the meta is removed until the store is closed, so a crash or a
+	// modification of the index leaves the cache stale
+	if err := os.Remove(filepath.Join(dir, "index.meta")); err != nil {
+		return false, 0, err
+	}
+
+	db, err := openIndexDB(filepath.Join(dir, "index.db"), s.sopts.policy)
+	if err != nil {
+		return miss()
+	}
+
+	if err := checkCachedIndex(db, s.sopts.policy, meta.Entries); err != nil {
+		db.Close()
+		return miss()
+	}
+
+	binIdx := &index{
+		db:     db,
+		pmap:   s.ltfs.pmap,
+		policy: s.sopts.policy,
+	}
+
+	binIdx.setPreface(&meta.Index.IndexPreface)
+	if err := binIdx.loadUID(); err != nil {
+		db.Close()
+		return miss()
+	}
+
+	s.idx = binIdx
+	s.cache = &meta
+
+	return true, 0, nil
+}
+
+// cachedLastUID returns the highest file UID allocated in the cached binary
+// index database at path, or 0 if there is none.
+func cachedLastUID(path string) uint64 {
+	if _, err := os.Stat(path); err != nil {
+		return 0
+	}
+
+	db, err := boltdb.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer db.Close()
+
+	var uid uint64
+	db.View(func(tx engine.Tx) error {
+		if b := tx.Bucket(metaBucket); b != nil {
+			if v := b.Get(lastUIDKey); len(v) == 8 {
+				uid = binary.BigEndian.Uint64(v)
+			}
+		}
+
+		return nil
+	})
+
+	return uid
+}
+
+// checkCachedIndex verifies that the database holds a binary index with the
+// given number of entries, as counted in its statistics (see indexStats).
+func checkCachedIndex(db engine.Interface, policy NamePolicy, entries int) error {
+	return db.View(func(tx engine.Tx) error {
+		t, err := newIndexTx(tx, policy)
+		if err != nil {
+			return err
+		}
+
+		if _, err := t.root(); err != nil {
+			return err
+		}
+
+		st, err := t.stats()
+		if err != nil {
+			return err
+		}
+
+		if n := st.entries(); n != uint64(entries) {
+			return errors.Errorf("expected %d entries, found %d", entries, n)
+		}
+
+		return nil
+	})
+}
+
+// newCacheMeta returns the meta of a binary index built from idx at the
+// given location.
+func (s *Store) newCacheMeta(idx *ltfs.Index, part uint32, block uint64) *indexMeta {
+	return &indexMeta{
+		Format:     indexCacheFormat,
+		VolumeUUID: idx.VolumeUUID,
+		Generation: idx.Generation,
+		UpdateTime: time.Time(idx.UpdateTime),
+		Partition:  part,
+		Block:      block,
+		NamePolicy: s.sopts.policy,
+		Index:      idx,
+	}
+}
+
+// closeCache writes the meta of the cached binary index, unless the index was
+// modified and no longer matches the LTFS index on the volume. It must be
+// called before the database is closed.
+func (s *Store) closeCache() error {
+	if atomic.LoadInt32(&s.idx.modified) != 0 {
+		return nil
+	}
+
+	err := s.idx.view(func(t *indexTx) error {
+		st, err := t.stats()
+		if err != nil {
+			return err
+		}
+
+		s.cache.Entries = int(st.entries())
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	buf, err := xml.Marshal(s.cache)
+	if err != nil {
+		return err
+	}
+
+	// write the meta atomically
+	tmp := filepath.Join(s.cacheDir(), "index.meta.tmp")
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(s.cacheDir(), "index.meta"))
+}
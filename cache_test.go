@@ -0,0 +1,195 @@
+package bltfs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	pb "github.com/golang/protobuf/proto"
+
+	"hpt.space/bltfs"
+	"hpt.space/bltfs/proto"
+	"hpt.space/bltfs/util/testutil"
+)
+
+// openCachedStore opens a store on the tape using the index cache in
+// cacheDir.
+func openCachedStore(t *testing.T, tape, cacheDir string) *bltfs.Store {
+	store, err := bltfs.Open(openTestDevice(t, tape), bltfs.WithIndexCache(cacheDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+// tamperCache changes the length of /testfile.txt in the cached binary index,
+// so a store mounted from the cache can be told apart from a rebuilt one.
+func tamperCache(t *testing.T, cacheDir string, length uint64) {
+	db, err := bolt.Open(filepath.Join(cacheDir, testutil.TestUUID.String(), "index.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the inode of /testfile.txt (file UID 2)
+	key := []byte{0, 0, 0, 0, 0, 0, 0, 2}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte("inodes"))
+
+		var e proto.Entry
+		if err := pb.Unmarshal(bkt.Get(key), &e); err != nil {
+			return err
+		}
+
+		e.GetFile().Length = length
+
+		buf, err := pb.Marshal(&e)
+		if err != nil {
+			return err
+		}
+
+		return bkt.Put(key, buf)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testFileSize(t *testing.T, store *bltfs.Store) int64 {
+	fi, err := store.Stat("/testfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fi.Size()
+}
+
+func TestIndexCache(t *testing.T) {
+	tape := makeTestTape(t, makeTestIndex())
+	defer cleanup(tape)
+
+	cacheDir, err := ioutil.TempDir("", "bltfscache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(cacheDir)
+
+	store := openCachedStore(t, tape, cacheDir)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := filepath.Join(cacheDir, testutil.TestUUID.String(), "index.meta")
+	if _, err := os.Stat(meta); err != nil {
+		t.Fatalf("expected index meta: %v", err)
+	}
+
+	// the remount uses the cache
+	tamperCache(t, cacheDir, 42)
+
+	store = openCachedStore(t, tape, cacheDir)
+	if size := testFileSize(t, store); size != 42 {
+		t.Errorf("expected the cached index to be used, got size %d", size)
+	}
+
+	if _, err := os.Stat(meta); !os.IsNotExist(err) {
+		t.Errorf("expected index meta to be removed while mounted, got %v", err)
+	}
+
+	// a modified index is not cached
+	if err := store.Mkdir("/dir2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store = openCachedStore(t, tape, cacheDir)
+	defer store.Close()
+
+	if size := testFileSize(t, store); size != 5 {
+		t.Errorf("expected the index to be rebuilt, got size %d", size)
+	}
+
+	if _, err := store.Stat("/dir2"); !os.IsNotExist(err) {
+		t.Errorf("expected /dir2 to not exist, got %v", err)
+	}
+}
+
+func TestIndexCacheStale(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "bltfscache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(cacheDir)
+
+	tape := makeTestTape(t, makeTestIndex())
+	defer cleanup(tape)
+
+	store := openCachedStore(t, tape, cacheDir)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tamperCache(t, cacheDir, 42)
+
+	// a later generation of the same volume
+	idx := makeTestIndex()
+	idx.Generation = 2
+
+	tape2 := makeTestTape(t, idx)
+	defer cleanup(tape2)
+
+	store = openCachedStore(t, tape2, cacheDir)
+	if size := testFileSize(t, store); size != 5 {
+		t.Errorf("expected a stale cache to be rebuilt, got size %d", size)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a corrupt database
+	db := filepath.Join(cacheDir, testutil.TestUUID.String(), "index.db")
+	if err := ioutil.WriteFile(db, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store = openCachedStore(t, tape2, cacheDir)
+	defer store.Close()
+
+	if size := testFileSize(t, store); size != 5 {
+		t.Errorf("expected a corrupt cache to be rebuilt, got size %d", size)
+	}
+}
+
+// stores opened at a past generation leave the cache alone
+func TestIndexCachePastGeneration(t *testing.T) {
+	dir := makeGenerationTape(t)
+	defer cleanup(dir)
+
+	cacheDir, err := ioutil.TempDir("", "bltfscache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(cacheDir)
+
+	store, err := bltfs.Open(openTestDevice(t, dir), bltfs.WithIndexCache(cacheDir), bltfs.WithGeneration(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries, _ := ioutil.ReadDir(cacheDir); len(entries) != 0 {
+		t.Errorf("expected an empty cache, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	pb "github.com/golang/protobuf/proto"
+
+	"hpt.space/bltfs"
+	filedebug "hpt.space/bltfs/backend/file"
+	"hpt.space/bltfs/ltfs"
+)
+
+// diff implements the diff subcommand. It returns the exit status.
+func diff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	indexes := fs.Bool("indexes", false, "compare two LTFS index files instead of two generations")
+	asLog := fs.Bool("log", false, "print the changes as a differential log")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: bltfs diff [-log] <tape directory> <generation> <generation>\n")
+		fmt.Fprintf(os.Stderr, "       bltfs diff [-log] -indexes <index file> <index file>\n")
+		fs.PrintDefaults()
+	}
+
+	fs.Parse(args)
+
+	var (
+		changes []*bltfs.Change
+		err     error
+	)
+
+	switch {
+	case *indexes && fs.NArg() == 2:
+		changes, err = diffIndexFiles(fs.Arg(0), fs.Arg(1))
+
+	case !*indexes && fs.NArg() == 3:
+		a, aerr := strconv.Atoi(fs.Arg(1))
+		b, berr := strconv.Atoi(fs.Arg(2))
+		if aerr != nil || berr != nil {
+			fs.Usage()
+			return 2
+		}
+
+		changes, err = diffGenerations(fs.Arg(0), a, b)
+
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	if err != nil {
+		perrorf("failed to compare indexes: %v", err)
+		return 1
+	}
+
+	if *asLog {
+		fmt.Print(pb.MarshalTextString(bltfs.DiffLog(changes)))
+		return 0
+	}
+
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+
+	return 0
+}
+
+func diffGenerations(dir string, a, b int) ([]*bltfs.Change, error) {
+	backend, err := filedebug.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := bltfs.Open(backend)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	return store.DiffGenerations(a, b)
+}
+
+func diffIndexFiles(a, b string) ([]*bltfs.Change, error) {
+	var idx [2]ltfs.Index
+
+	for i, name := range []string{a, b} {
+		buf, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := xml.Unmarshal(buf, &idx[i]); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+
+	return bltfs.Diff(&idx[0], &idx[1], ltfs.DefaultPartitionMap)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"hpt.space/bltfs"
+	filedebug "hpt.space/bltfs/backend/file"
+)
+
+// fsck implements the fsck subcommand. It returns the exit status.
+func fsck(args []string) int {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	rollback := fs.Bool("rollback", false, "roll back to the last consistent index generation")
+	recoverIndex := fs.Bool("recover", false, "write a recovery index collecting orphaned data in "+bltfs.LostAndFound)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: bltfs fsck [-rollback | -recover] <tape directory>\n")
+		fs.PrintDefaults()
+	}
+
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *rollback && *recoverIndex {
+		fs.Usage()
+		return 2
+	}
+
+	backend, err := filedebug.Open(fs.Arg(0))
+	if err != nil {
+		perrorf("failed to open filedebug backend: %v", err)
+		return 1
+	}
+
+	report, err := bltfs.Check(backend)
+	if err != nil {
+		perrorf("failed to check volume: %v", err)
+		return 1
+	}
+
+	for _, g := range report.Generations {
+		status := "consistent"
+		if !g.Consistent {
+			status = "INCONSISTENT"
+		}
+
+		pinfof("generation %d at %d:%d, updated %s, %d files (%s)",
+			g.Number, g.Partition, g.Block, g.UpdateTime.Format("2006-01-02 15:04:05"), g.Files, status)
+	}
+
+	for _, p := range report.Problems {
+		perror(p)
+	}
+
+	if report.Consistent() {
+		pinfo("volume is consistent")
+		return 0
+	}
+
+	if !*rollback && !*recoverIndex {
+		return 1
+	}
+
+	mode := bltfs.Rollback
+	if *recoverIndex {
+		mode = bltfs.Recover
+	}
+
+	g, err := bltfs.Repair(backend, report, mode)
+	if err != nil {
+		perrorf("failed to repair volume: %v", err)
+		return 1
+	}
+
+	pinfof("wrote generation %d at %d:%d", g.Number, g.Partition, g.Block)
+
+	return 0
+}
@@ -20,10 +20,8 @@ import (
 	"bytes"
 	"fmt"
 	"os"
-	"path/filepath"
 	"time"
 
-	"github.com/pkg/errors"
 	"hpt.space/bltfs"
 	filedebug "hpt.space/bltfs/backend/file"
 	"hpt.space/bltfs/util/fsutil"
@@ -92,6 +90,18 @@ func reporter(report *bltfs.Report) {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fsck" {
+		os.Exit(fsck(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(diff(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validate(os.Args[2:]))
+	}
+
 	pinfo("bltfs test starting")
 
 	// setup fixtures
@@ -135,52 +145,10 @@ func main() {
 	// store a directory to the bltfs store
 	src := "./fixtures/files"
 
-	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-		// process possible error first
-		if err != nil {
-			return err
-		}
-
-		if info.IsDir() {
-			if err := store.Mkdir(path); err != nil {
-				return errors.Wrapf(err, "failed to create directory '%s' in sotre", path)
-			}
-
-			return nil
-		}
-
-		// open local file
-		f, err := os.Open(path)
-		if err != nil {
-			return errors.Wrapf(err, "failed to open file '%s'", path)
-		}
-		defer f.Close()
-
-		// create the remote file
-		basename := filepath.Base(path)
-		rf, err := store.Create(basename)
-		if err != nil {
-			return errors.Wrapf(err, "failed to create file '%s' in store", path)
-		}
-
-		// copy the contents efficiently (use the block size of the device)
-		written, err := store.Copy(rf, f)
-		if err != nil {
-			return errors.Wrapf(err, "failed to copy file '%s'", path)
-		}
-
-		pinfof("copied %d bytes from %s", written, path)
-
-		// close the remote file
-		if err := rf.Close(); err != nil {
-			return errors.Wrapf(err, "failed to close remote file '%s'", rf)
-		}
-
-		return nil
-	})
+	err = store.Archive(src, "/")
 
 	if err != nil {
-		perrorf("failed to walk directory '%s': %v", src, err)
+		perrorf("failed to archive directory '%s': %v", src, err)
 		os.Exit(1)
 	}
 
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"hpt.space/bltfs/ltfs"
+)
+
+// validate implements the validate subcommand. It returns the exit status.
+func validate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: bltfs validate <index file>...\n")
+		fs.PrintDefaults()
+	}
+
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			perrorf("failed to open index: %v", err)
+			status = 1
+			continue
+		}
+
+		vs, err := ltfs.ValidateStream(f)
+		f.Close()
+
+		if err != nil {
+			perrorf("%s: failed to decode index: %v", name, err)
+			status = 1
+			continue
+		}
+
+		for _, v := range vs {
+			perrorf("%s: %s", name, v)
+		}
+
+		if len(vs) > 0 {
+			status = 1
+			continue
+		}
+
+		pinfof("%s: index is valid", name)
+	}
+
+	return status
+}
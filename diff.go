@@ -0,0 +1,333 @@
+package bltfs
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	pb "github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+
+	"hpt.space/bltfs/ltfs"
+	"hpt.space/bltfs/proto"
+)
+
+// A Change is a difference between two LTFS indexes. Entries are matched by
+// file UID, so an entry that changed path is reported as moved rather than as
+// removed and added.
+type Change struct {
+	// Op is ADD for entries only in the new index, RM for entries only in
+	// the old index and CH for entries in both.
+	Op proto.Entry_Op
+
+	// Path is the path of the entry in the new index, or in the old index
+	// if it was removed. OldPath is the path in the old index if the entry
+	// was moved.
+	Path    string
+	OldPath string
+
+	// Old and New are the entry in the old and new index. Old is nil for
+	// added entries and New is nil for removed entries.
+	Old, New *proto.Entry
+
+	// Fields lists the metadata that changed, for example "length" or
+	// "modifytime". Renaming an entry also changes its "name".
+	Fields []string
+}
+
+// Moved returns true if the entry changed path.
+func (c *Change) Moved() bool {
+	return c.OldPath != ""
+}
+
+func (c *Change) String() string {
+	switch c.Op {
+	case proto.Entry_ADD:
+		return "+ " + c.Path
+	case proto.Entry_RM:
+		return "- " + c.Path
+	}
+
+	s := "M " + c.Path
+	if c.Moved() {
+		s = "R " + c.OldPath + " -> " + c.Path
+	}
+
+	if len(c.Fields) > 0 {
+		s += " (" + strings.Join(c.Fields, ", ") + ")"
+	}
+
+	return s
+}
+
+// Diff returns the changes from the index from to the index to, sorted by
+// path. Extents are compared on the physical partitions of pmap.
+func Diff(from, to *ltfs.Index, pmap ltfs.PartitionMap) ([]*Change, error) {
+	a, err := diffEntries(from, pmap)
+	if err != nil {
+		return nil, errors.Wrap(err, "old index")
+	}
+
+	b, err := diffEntries(to, pmap)
+	if err != nil {
+		return nil, errors.Wrap(err, "new index")
+	}
+
+	var changes []*Change
+
+	for uid, n := range b {
+		o, ok := a[uid]
+		if !ok {
+			changes = append(changes, &Change{Op: proto.Entry_ADD, Path: n.path, New: n.entry})
+			continue
+		}
+
+		c := &Change{
+			Op:     proto.Entry_CH,
+			Path:   n.path,
+			Old:    o.entry,
+			New:    n.entry,
+			Fields: diffFields(o.entry, n.entry),
+		}
+
+		if o.path != n.path {
+			c.OldPath = o.path
+		}
+
+		if c.Moved() || len(c.Fields) > 0 {
+			changes = append(changes, c)
+		}
+	}
+
+	for uid, o := range a {
+		if _, ok := b[uid]; !ok {
+			changes = append(changes, &Change{Op: proto.Entry_RM, Path: o.path, Old: o.entry})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Path != changes[j].Path {
+			return changes[i].Path < changes[j].Path
+		}
+
+		// a removed entry goes before an entry added in its place
+		return changes[i].Op == proto.Entry_RM
+	})
+
+	return changes, nil
+}
+
+// DiffGenerations returns the changes from generation a to generation b of
+// the volume (see Diff).
+func (s *Store) DiffGenerations(a, b int) ([]*Change, error) {
+	s.rw.mu.Lock()
+	defer s.rw.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gens, err := s.generations()
+	if err != nil {
+		return nil, err
+	}
+
+	var from, to *Generation
+	for _, g := range gens {
+		if g.Number == a {
+			from = g
+		}
+
+		if g.Number == b {
+			to = g
+		}
+	}
+
+	if from == nil {
+		return nil, fmt.Errorf("no generation %d", a)
+	}
+
+	if to == nil {
+		return nil, fmt.Errorf("no generation %d", b)
+	}
+
+	// only the two generations compared are read in full
+	fromIdx, _, err := s.readLTFSIndexAt(from.Partition, from.Block)
+	if err != nil {
+		return nil, err
+	}
+
+	toIdx, _, err := s.readLTFSIndexAt(to.Partition, to.Block)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.seekEOD(); err != nil {
+		return nil, err
+	}
+
+	return Diff(fromIdx, toIdx, s.ltfs.pmap)
+}
+
+// DiffLog returns the changes as a differential log. The entries of the log
+// carry the full path of the entry in the new index (or the old index for
+// removed entries) as their name; moved entries are identified by their id.
+func DiffLog(changes []*Change) *proto.Log {
+	log := &proto.Log{
+		Class:   proto.Log_DIFF,
+		Entries: make([]*proto.Entry, 0, len(changes)),
+		Extents: make([]*proto.Extent, 0),
+	}
+
+	for _, c := range changes {
+		e := c.New
+		if c.Op == proto.Entry_RM {
+			e = c.Old
+		}
+
+		e = pb.Clone(e).(*proto.Entry)
+		e.Name = c.Path
+		e.Operation = c.Op
+
+		log.Entries = append(log.Entries, e)
+	}
+
+	return log
+}
+
+type diffEntry struct {
+	path  string
+	entry *proto.Entry
+}
+
+// diffEntries returns the entries of idx by file UID.
+func diffEntries(idx *ltfs.Index, pmap ltfs.PartitionMap) (map[int]*diffEntry, error) {
+	entries := make(map[int]*diffEntry)
+
+	add := func(uid int, p string, e *proto.Entry) error {
+		if _, ok := entries[uid]; ok {
+			return errors.Errorf("duplicate file UID %d at '%s'", uid, p)
+		}
+
+		entries[uid] = &diffEntry{path: p, entry: e}
+
+		return nil
+	}
+
+	var walk func(p string, d *ltfs.Directory) error
+	walk = func(p string, d *ltfs.Directory) error {
+		var e proto.Entry
+		if err := proto.MarshalDirectory(d, &e); err != nil {
+			return errors.Wrapf(err, "failed to marshal directory '%s'", p)
+		}
+
+		if err := add(d.FileUID, p, &e); err != nil {
+			return err
+		}
+
+		if d.Contents == nil {
+			return nil
+		}
+
+		for _, f := range d.Contents.Files {
+			fp := path.Join(p, f.Name)
+
+			var e proto.Entry
+			if err := proto.MarshalFile(f, &e, pmap); err != nil {
+				return errors.Wrapf(err, "failed to marshal file '%s'", fp)
+			}
+
+			if err := add(f.FileUID, fp, &e); err != nil {
+				return err
+			}
+		}
+
+		for _, dir := range d.Contents.Directories {
+			if err := walk(path.Join(p, dir.Name), dir); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := walk("/", idx.Root); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// diffFields returns the names of the metadata that differs between a and b.
+func diffFields(a, b *proto.Entry) []string {
+	var fields []string
+
+	diff := func(name string, changed bool) {
+		if changed {
+			fields = append(fields, name)
+		}
+	}
+
+	diff("type", entryType(a) != entryType(b))
+	diff("name", a.Name != b.Name)
+	diff("readonly", a.Readonly != b.Readonly)
+	diff("creationtime", a.CreateTime != b.CreateTime)
+	diff("changetime", a.ChangeTime != b.ChangeTime)
+	diff("modifytime", a.ModifyTime != b.ModifyTime)
+	diff("accesstime", a.AccessTime != b.AccessTime)
+	diff("backuptime", a.BackupTime != b.BackupTime)
+	diff("xattrs", !equalXattrs(a.Xattrs, b.Xattrs))
+
+	if fa, fb := a.GetFile(), b.GetFile(); fa != nil && fb != nil {
+		diff("length", fa.Length != fb.Length)
+		diff("extents", !equalExtents(fa.Extents, fb.Extents))
+	}
+
+	if sa, sb := a.GetSymlink(), b.GetSymlink(); sa != nil && sb != nil {
+		diff("target", sa.Target != sb.Target)
+	}
+
+	return fields
+}
+
+func entryType(e *proto.Entry) string {
+	switch e.Elem.(type) {
+	case *proto.Entry_File:
+		return "file"
+	case *proto.Entry_Dir:
+		return "directory"
+	case *proto.Entry_Symlink:
+		return "symlink"
+	}
+
+	return ""
+}
+
+func equalXattrs(a, b []*proto.Xattr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Key != b[i].Key || !bytes.Equal(a[i].Value, b[i].Value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func equalExtents(a, b []*proto.Extent) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !pb.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,86 @@
+package bltfs_test
+
+import (
+	"reflect"
+	"testing"
+
+	"hpt.space/bltfs"
+	"hpt.space/bltfs/ltfs"
+	"hpt.space/bltfs/proto"
+)
+
+func TestDiff(t *testing.T) {
+	from := makeTestIndex()
+	to := makeTestIndex()
+
+	// move /dir/file to /file and grow it, remove /testfile.txt and add
+	// /dir/new
+	dir := to.Root.Contents.Directories[0]
+	moved := dir.Contents.Files[0]
+	moved.Length = 20
+	moved.ExtentInfo[0].ByteCount = 20
+
+	to.Root.Contents.Files = []*ltfs.File{moved}
+	dir.Contents.Files = []*ltfs.File{makeTestFile(5, "new", 5, 6)}
+
+	changes, err := bltfs.Diff(from, to, ltfs.DefaultPartitionMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+
+	expected := []string{
+		"+ /dir/new",
+		"R /dir/file -> /file (length, extents)",
+		"- /testfile.txt",
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected changes %q, got %q", expected, got)
+	}
+
+	log := bltfs.DiffLog(changes)
+	if log.Class != proto.Log_DIFF || len(log.Entries) != 3 {
+		t.Fatalf("unexpected log %v", log)
+	}
+
+	ops := []proto.Entry_Op{proto.Entry_ADD, proto.Entry_CH, proto.Entry_RM}
+	for i, e := range log.Entries {
+		if e.Operation != ops[i] || e.Name != changes[i].Path {
+			t.Errorf("unexpected log entry %v", e)
+		}
+	}
+
+	// the log does not alter the changes
+	if changes[1].New.Name != "file" {
+		t.Errorf("unexpected entry name %q", changes[1].New.Name)
+	}
+}
+
+func TestDiffGenerations(t *testing.T) {
+	dir := makeGenerationTape(t)
+	defer cleanup(dir)
+
+	store, err := bltfs.Open(openTestDevice(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	changes, err := store.DiffGenerations(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changes) != 1 || changes[0].Op != proto.Entry_ADD || changes[0].Path != "/file1" {
+		t.Errorf("unexpected changes %v", changes)
+	}
+
+	if _, err := store.DiffGenerations(1, 3); err == nil {
+		t.Error("expected error for missing generation")
+	}
+}
@@ -0,0 +1,102 @@
+// Package boltdb implements a binary index storage engine backed by a bolt
+// database.
+package boltdb
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+
+	"hpt.space/bltfs/engine"
+)
+
+// Engine is a storage engine backed by a bolt database.
+type Engine struct {
+	db *bolt.DB
+}
+
+var _ engine.Interface = (*Engine)(nil)
+
+// Open opens (or creates) the bolt database at path.
+func Open(path string) (*Engine, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open index database")
+	}
+
+	return &Engine{db: db}, nil
+}
+
+// New returns an engine using the open bolt database db.
+func New(db *bolt.DB) *Engine {
+	return &Engine{db: db}
+}
+
+func (e *Engine) View(fn func(tx engine.Tx) error) error {
+	return e.db.View(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx})
+	})
+}
+
+func (e *Engine) Update(fn func(tx engine.Tx) error) error {
+	return e.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx})
+	})
+}
+
+func (e *Engine) Close() error {
+	return e.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t *boltTx) Bucket(name []byte) engine.Bucket {
+	if b := t.tx.Bucket(name); b != nil {
+		return &boltBucket{b}
+	}
+
+	if !t.tx.Writable() {
+		return nil
+	}
+
+	b, err := t.tx.CreateBucket(name)
+	if err != nil {
+		return nil
+	}
+
+	return &boltBucket{b}
+}
+
+func (t *boltTx) HasBucket(name []byte) bool {
+	return t.tx.Bucket(name) != nil
+}
+
+func (t *boltTx) DeleteBucket(name []byte) error {
+	if err := t.tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+
+	return nil
+}
+
+type boltBucket struct {
+	*bolt.Bucket
+}
+
+func (b *boltBucket) Cursor() engine.Cursor {
+	return b.Bucket.Cursor()
+}
+
+func (b *boltBucket) Len() int {
+	return b.Stats().KeyN
+}
+
+// Pack sets the fill percentage of the bucket to 100%. This ensures that
+// bolt doesn't split pages before the page is full, which is good for keys
+// inserted in order when we mostly expect reads on the index.
+func (b *boltBucket) Pack() {
+	b.FillPercent = 1
+}
@@ -0,0 +1,71 @@
+// Package engine defines the storage engines of the binary index. An engine
+// stores named buckets of sorted key/value pairs and accesses them in
+// transactions, much like bolt.
+package engine
+
+// Interface is the interface that binary index storage engines must
+// implement.
+type Interface interface {
+	// View runs fn in a read-only transaction.
+	View(fn func(tx Tx) error) error
+
+	// Update runs fn in a read-write transaction. If fn returns an error,
+	// the transaction is rolled back and the error is returned.
+	Update(fn func(tx Tx) error) error
+
+	Close() error
+}
+
+// Tx is a transaction.
+type Tx interface {
+	// Bucket returns the bucket with the given name. In a read-write
+	// transaction the bucket is created if it does not exist; in a
+	// read-only transaction Bucket returns nil instead.
+	Bucket(name []byte) Bucket
+
+	// HasBucket reports whether the bucket with the given name exists.
+	HasBucket(name []byte) bool
+
+	// DeleteBucket deletes the bucket with the given name, if it exists.
+	DeleteBucket(name []byte) error
+}
+
+// Bucket is a collection of key/value pairs sorted by key. Keys and values
+// returned by a bucket are only valid for the life of the transaction and
+// must not be modified.
+type Bucket interface {
+	// Get returns the value of the key, or nil if the key does not exist.
+	Get(key []byte) []byte
+
+	// Put sets the value of the key.
+	Put(key, value []byte) error
+
+	// Delete removes the key. Deleting a missing key is not an error.
+	Delete(key []byte) error
+
+	// Cursor returns a cursor over the keys of the bucket. The bucket
+	// must not be modified while the cursor is in use.
+	Cursor() Cursor
+
+	// Len returns the number of keys in the bucket.
+	Len() int
+}
+
+// Cursor iterates over the keys of a bucket in order. The methods return a
+// nil key when the cursor moves past either end of the bucket.
+type Cursor interface {
+	First() (key, value []byte)
+	Last() (key, value []byte)
+
+	// Seek moves the cursor to the first key at or after seek.
+	Seek(seek []byte) (key, value []byte)
+
+	Next() (key, value []byte)
+	Prev() (key, value []byte)
+}
+
+// Packer is implemented by buckets that can pack keys densely when they are
+// inserted in key order, as when a binary index is built.
+type Packer interface {
+	Pack()
+}
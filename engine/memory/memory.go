@@ -0,0 +1,284 @@
+// Package memory implements a binary index storage engine that keeps all
+// buckets in memory. It is meant for tests and short-lived mounts; its
+// contents are lost when it is closed.
+package memory
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"hpt.space/bltfs/engine"
+)
+
+// ErrClosed is returned when using a closed engine.
+var ErrClosed = errors.New("engine is closed")
+
+// Engine is an in-memory storage engine. Read-only transactions run
+// concurrently; read-write transactions are serialized.
+type Engine struct {
+	mu      sync.RWMutex
+	buckets map[string]*bucket
+	closed  bool
+}
+
+var _ engine.Interface = (*Engine)(nil)
+
+// New returns an empty in-memory engine.
+func New() *Engine {
+	return &Engine{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (e *Engine) View(fn func(tx engine.Tx) error) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		return ErrClosed
+	}
+
+	return fn(&tx{e: e})
+}
+
+func (e *Engine) Update(fn func(tx engine.Tx) error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return ErrClosed
+	}
+
+	t := &tx{e: e, writable: true}
+
+	if err := fn(t); err != nil {
+		t.rollback()
+		return err
+	}
+
+	return nil
+}
+
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closed = true
+	e.buckets = nil
+
+	return nil
+}
+
+type tx struct {
+	e        *Engine
+	writable bool
+
+	// the changes of the transaction, undone in reverse order on rollback
+	undo []func()
+}
+
+func (t *tx) rollback() {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+}
+
+func (t *tx) Bucket(name []byte) engine.Bucket {
+	b, ok := t.e.buckets[string(name)]
+	if !ok {
+		if !t.writable {
+			return nil
+		}
+
+		b = &bucket{}
+		t.e.buckets[string(name)] = b
+
+		t.undo = append(t.undo, func() {
+			delete(t.e.buckets, string(name))
+		})
+	}
+
+	return &txBucket{b: b, tx: t}
+}
+
+func (t *tx) HasBucket(name []byte) bool {
+	_, ok := t.e.buckets[string(name)]
+	return ok
+}
+
+func (t *tx) DeleteBucket(name []byte) error {
+	if !t.writable {
+		return errors.New("transaction is read-only")
+	}
+
+	b, ok := t.e.buckets[string(name)]
+	if !ok {
+		return nil
+	}
+
+	delete(t.e.buckets, string(name))
+
+	t.undo = append(t.undo, func() {
+		t.e.buckets[string(name)] = b
+	})
+
+	return nil
+}
+
+// bucket holds its pairs sorted by key.
+type bucket struct {
+	keys   [][]byte
+	values [][]byte
+}
+
+// search returns the index of the first key at or after key.
+func (b *bucket) search(key []byte) int {
+	return sort.Search(len(b.keys), func(i int) bool {
+		return bytes.Compare(b.keys[i], key) >= 0
+	})
+}
+
+func (b *bucket) set(i int, key, value []byte) {
+	if i < len(b.keys) && bytes.Equal(b.keys[i], key) {
+		b.values[i] = value
+		return
+	}
+
+	b.keys = append(b.keys, nil)
+	b.values = append(b.values, nil)
+
+	copy(b.keys[i+1:], b.keys[i:])
+	copy(b.values[i+1:], b.values[i:])
+
+	b.keys[i], b.values[i] = key, value
+}
+
+func (b *bucket) remove(i int) {
+	b.keys = append(b.keys[:i], b.keys[i+1:]...)
+	b.values = append(b.values[:i], b.values[i+1:]...)
+}
+
+// txBucket is a bucket accessed in a transaction.
+type txBucket struct {
+	b  *bucket
+	tx *tx
+}
+
+func (tb *txBucket) Get(key []byte) []byte {
+	b := tb.b
+
+	if i := b.search(key); i < len(b.keys) && bytes.Equal(b.keys[i], key) {
+		return b.values[i]
+	}
+
+	return nil
+}
+
+func (tb *txBucket) Put(key, value []byte) error {
+	if !tb.tx.writable {
+		return errors.New("transaction is read-only")
+	}
+
+	if len(key) == 0 {
+		return errors.New("key required")
+	}
+
+	b := tb.b
+	key = append([]byte(nil), key...)
+
+	// the empty value is not nil, as Get returns nil for missing keys
+	value = append([]byte{}, value...)
+
+	i := b.search(key)
+	if i < len(b.keys) && bytes.Equal(b.keys[i], key) {
+		old := b.values[i]
+		tb.tx.undo = append(tb.tx.undo, func() {
+			b.set(b.search(key), key, old)
+		})
+	} else {
+		tb.tx.undo = append(tb.tx.undo, func() {
+			b.remove(b.search(key))
+		})
+	}
+
+	b.set(i, key, value)
+
+	return nil
+}
+
+func (tb *txBucket) Delete(key []byte) error {
+	if !tb.tx.writable {
+		return errors.New("transaction is read-only")
+	}
+
+	b := tb.b
+
+	i := b.search(key)
+	if i == len(b.keys) || !bytes.Equal(b.keys[i], key) {
+		return nil
+	}
+
+	k, v := b.keys[i], b.values[i]
+	tb.tx.undo = append(tb.tx.undo, func() {
+		b.set(b.search(k), k, v)
+	})
+
+	b.remove(i)
+
+	return nil
+}
+
+func (tb *txBucket) Cursor() engine.Cursor {
+	return &cursor{b: tb.b}
+}
+
+func (tb *txBucket) Len() int {
+	return len(tb.b.keys)
+}
+
+type cursor struct {
+	b *bucket
+	i int
+}
+
+func (c *cursor) at() ([]byte, []byte) {
+	if c.i < 0 || c.i >= len(c.b.keys) {
+		return nil, nil
+	}
+
+	return c.b.keys[c.i], c.b.values[c.i]
+}
+
+func (c *cursor) First() ([]byte, []byte) {
+	c.i = 0
+	return c.at()
+}
+
+func (c *cursor) Last() ([]byte, []byte) {
+	c.i = len(c.b.keys) - 1
+	return c.at()
+}
+
+func (c *cursor) Seek(seek []byte) ([]byte, []byte) {
+	c.i = c.b.search(seek)
+	return c.at()
+}
+
+func (c *cursor) Next() ([]byte, []byte) {
+	if c.i < len(c.b.keys) {
+		c.i++
+	}
+
+	return c.at()
+}
+
+func (c *cursor) Prev() ([]byte, []byte) {
+	if c.i >= 0 {
+		c.i--
+	}
+
+	return c.at()
+}
@@ -0,0 +1,120 @@
+package memory
+
+import (
+	"errors"
+	"testing"
+
+	"hpt.space/bltfs/engine"
+)
+
+func TestCursor(t *testing.T) {
+	e := New()
+
+	err := e.Update(func(tx engine.Tx) error {
+		b := tx.Bucket([]byte("b"))
+		for _, k := range []string{"c", "a", "e"} {
+			if err := b.Put([]byte(k), []byte(k+k)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.View(func(tx engine.Tx) error {
+		b := tx.Bucket([]byte("b"))
+
+		if n := b.Len(); n != 3 {
+			t.Errorf("expected 3 keys, got %d", n)
+		}
+
+		if v := b.Get([]byte("c")); string(v) != "cc" {
+			t.Errorf("expected cc, got %q", v)
+		}
+
+		c := b.Cursor()
+
+		var keys string
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			keys += string(k)
+		}
+
+		if keys != "ace" {
+			t.Errorf("expected ace, got %s", keys)
+		}
+
+		if k, _ := c.Seek([]byte("b")); string(k) != "c" {
+			t.Errorf("expected c, got %s", k)
+		}
+
+		if k, _ := c.Prev(); string(k) != "a" {
+			t.Errorf("expected a, got %s", k)
+		}
+
+		if k, _ := c.Seek([]byte("f")); k != nil {
+			t.Errorf("expected end of bucket, got %s", k)
+		}
+
+		if k, _ := c.Last(); string(k) != "e" {
+			t.Errorf("expected e, got %s", k)
+		}
+
+		if tx.Bucket([]byte("missing")) != nil {
+			t.Error("expected no bucket in a read-only transaction")
+		}
+
+		return nil
+	})
+}
+
+func TestRollback(t *testing.T) {
+	e := New()
+
+	e.Update(func(tx engine.Tx) error {
+		return tx.Bucket([]byte("b")).Put([]byte("a"), []byte("1"))
+	})
+
+	fail := errors.New("fail")
+
+	err := e.Update(func(tx engine.Tx) error {
+		b := tx.Bucket([]byte("b"))
+		b.Put([]byte("a"), []byte("2"))
+		b.Put([]byte("b"), []byte("2"))
+		b.Delete([]byte("a"))
+
+		tx.Bucket([]byte("new"))
+
+		return fail
+	})
+
+	if err != fail {
+		t.Fatalf("expected %v, got %v", fail, err)
+	}
+
+	e.View(func(tx engine.Tx) error {
+		if tx.HasBucket([]byte("new")) {
+			t.Error("bucket created in rolled back transaction")
+		}
+
+		b := tx.Bucket([]byte("b"))
+		if n := b.Len(); n != 1 {
+			t.Errorf("expected 1 key, got %d", n)
+		}
+
+		if v := b.Get([]byte("a")); string(v) != "1" {
+			t.Errorf("expected 1, got %q", v)
+		}
+
+		return nil
+	})
+
+	e.Close()
+
+	if err := e.View(func(engine.Tx) error { return nil }); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
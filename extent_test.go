@@ -0,0 +1,180 @@
+package bltfs_test
+
+import (
+	"bytes"
+	"testing"
+
+	"hpt.space/bltfs"
+	"hpt.space/bltfs/backend/file"
+	"hpt.space/bltfs/proto"
+)
+
+func testExtents(t *testing.T, store *bltfs.Store, name string) []*proto.Extent {
+	fi, err := store.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fi.Sys().(*proto.Entry).GetFile().Extents
+}
+
+func expectContent(t *testing.T, store *bltfs.Store, name string, want []byte) {
+	var buf bytes.Buffer
+	if _, err := store.Retrieve(name, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("%s: unexpected content %q", name, buf.Bytes())
+	}
+}
+
+func TestInterleavedExtents(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	a, err := store.Create("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := store.Create("/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, w := range []struct {
+		f    *bltfs.File
+		data string
+	}{
+		{a, "aaaa"}, {b, "bbbb"}, {a, "cc"}, {a, "dd"},
+	} {
+		if _, err := w.f.Write([]byte(w.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the writes share a record; the adjacent writes of /a are merged
+	ea := testExtents(t, store, "/a")
+	if len(ea) != 2 {
+		t.Fatalf("expected 2 extents, got %v", ea)
+	}
+
+	blk := ea[0].Block
+
+	if ea[0].Boffset != 0 || ea[0].Length != 4 || ea[0].Offset != 0 {
+		t.Errorf("unexpected extent %v", ea[0])
+	}
+
+	if ea[1].Block != blk || ea[1].Boffset != 8 || ea[1].Length != 4 || ea[1].Offset != 4 {
+		t.Errorf("unexpected extent %v", ea[1])
+	}
+
+	eb := testExtents(t, store, "/b")
+	if len(eb) != 1 || eb[0].Block != blk || eb[0].Boffset != 4 || eb[0].Length != 4 {
+		t.Errorf("unexpected extents %v", eb)
+	}
+
+	expectContent(t, store, "/a", []byte("aaaaccdd"))
+	expectContent(t, store, "/b", []byte("bbbb"))
+
+	if fi, err := store.Stat("/a"); err != nil || fi.Size() != 8 {
+		t.Errorf("expected size 8, got %v (%v)", fi.Size(), err)
+	}
+}
+
+func TestCoalesceExtents(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	data := bytes.Repeat([]byte("0123456789"), file.DefaultBlockSize/10+10)
+
+	f, err := store.Create("/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the writes cross a block boundary
+	for _, p := range [][]byte{data[:file.DefaultBlockSize-5], data[file.DefaultBlockSize-5:]} {
+		if _, err := f.Write(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	es := testExtents(t, store, "/big")
+	if len(es) != 1 || es[0].Length != uint64(len(data)) {
+		t.Errorf("expected a single extent, got %v", es)
+	}
+
+	expectContent(t, store, "/big", data)
+
+	// the next write starts in a new record, as the last one was short
+	writeTestFile(t, store, "/next", []byte("next"))
+
+	if es := testExtents(t, store, "/next"); len(es) != 1 || es[0].Block != 2+testExtents(t, store, "/big")[0].Block || es[0].Boffset != 0 {
+		t.Errorf("unexpected extents %v", es)
+	}
+}
+
+func TestNoBatchExtents(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	f, err := store.Create("/nobatch", bltfs.WithNoBatch())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"xx", "yy"} {
+		if _, err := f.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// each write is a short record of its own
+	es := testExtents(t, store, "/nobatch")
+	if len(es) != 2 || es[1].Block != es[0].Block+1 || es[1].Boffset != 0 || es[1].Offset != 2 {
+		t.Errorf("unexpected extents %v", es)
+	}
+
+	expectContent(t, store, "/nobatch", []byte("xxyy"))
+}
+
+func TestOverwriteExtents(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	writeTestFile(t, store, "/dir/file", []byte("new"))
+
+	// the 10 bytes of the old content are no longer referenced
+	if n := store.UnreferencedBytes(); n != 10 {
+		t.Errorf("expected 10 unreferenced bytes, got %d", n)
+	}
+
+	expectContent(t, store, "/dir/file", []byte("new"))
+
+	log := store.Journal()
+	if len(log.Entries) != 1 || log.Entries[0].Operation != proto.Entry_CH || log.Entries[0].Name != "/dir/file" {
+		t.Errorf("unexpected journal %v", log.Entries)
+	}
+}
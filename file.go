@@ -1,9 +1,15 @@
 package bltfs
 
 import (
+	"bytes"
 	"os"
+	"path/filepath"
+	"syscall"
 	"time"
 
+	pb "github.com/golang/protobuf/proto"
+
+	"hpt.space/bltfs/ltfs"
 	"hpt.space/bltfs/proto"
 )
 
@@ -11,13 +17,16 @@ type entryStat struct {
 	e *proto.Entry
 }
 
-// Size is part of the os.FileInfo interface
+// Size is part of the os.FileInfo interface; directories have size 0.
 func (es *entryStat) Size() int64 {
-	if x, ok := es.e.Elem.(*proto.Entry_File); ok {
+	switch x := es.e.Elem.(type) {
+	case *proto.Entry_File:
 		return int64(x.File.Length)
+	case *proto.Entry_Symlink:
+		return int64(len(x.Symlink.Target))
 	}
 
-	panic("Size() not implemented for directories")
+	return 0
 }
 
 // IsDir is part of the os.FileInfo interface
@@ -27,7 +36,18 @@ func (es *entryStat) IsDir() bool {
 	return ok
 }
 
-func (es *entryStat) Mode() os.FileMode  { return os.ModePerm }
+// Mode is part of the os.FileInfo interface
+func (es *entryStat) Mode() os.FileMode {
+	switch es.e.Elem.(type) {
+	case *proto.Entry_Dir:
+		return os.ModeDir | os.ModePerm
+	case *proto.Entry_Symlink:
+		return os.ModeSymlink | os.ModePerm
+	}
+
+	return os.ModePerm
+}
+
 func (es *entryStat) ModTime() time.Time { return time.Unix(0, es.e.ModifyTime) }
 func (es *entryStat) Sys() interface{}   { return es.e }
 func (es *entryStat) Name() string       { return es.e.Name }
@@ -47,10 +67,24 @@ func WithNoBatch() FileOption {
 type File struct {
 	id uint64
 
+	s    *Store
 	rw   *synchronizedWriter
 	idx  *index
 	path string
 
+	// the entry is read-only
+	readonly bool
+
+	// the file was written and its content is replaced on Close; offset is
+	// the number of bytes written to the device
+	written bool
+	offset  uint64
+
+	// the data of a file that matches the data placement policy is held
+	// back until the file is closed or outgrows the policy
+	pol   ltfs.DataPlacementPolicy
+	small *bytes.Buffer
+
 	fopts fileOptions
 }
 
@@ -58,15 +92,72 @@ func (f *File) String() string {
 	return f.path
 }
 
+// Create opens the file at path for writing, creating it with a new file UID
+// if it does not exist. If there is an error, it will be of type
+// *os.PathError.
 func (s *Store) Create(path string, opts ...FileOption) (*File, error) {
+	if err := s.writable(); err != nil {
+		return nil, &os.PathError{Op: "create", Path: path, Err: err}
+	}
+
+	e, err := s.idx.stat(cleanPath(path))
+	switch {
+	case err == nil && e.Readonly:
+		return nil, &os.PathError{Op: "create", Path: path, Err: syscall.EPERM}
+	case err == nil && e.GetDir() != nil:
+		return nil, &os.PathError{Op: "create", Path: path, Err: syscall.EISDIR}
+	case os.IsNotExist(err):
+		now := time.Now().UnixNano()
+
+		entry := &proto.Entry{
+			Name:       filepath.Base(cleanPath(path)),
+			CreateTime: now,
+			ChangeTime: now,
+			ModifyTime: now,
+			AccessTime: now,
+			BackupTime: now,
+
+			Elem: &proto.Entry_File{
+				File: &proto.File{},
+			},
+		}
+
+		if entry.Id, err = s.allocUID(); err != nil {
+			return nil, &os.PathError{Op: "create", Path: path, Err: err}
+		}
+
+		if err := s.idx.create(cleanPath(path), entry); err != nil {
+			return nil, &os.PathError{Op: "create", Path: path, Err: err}
+		}
+
+		s.journal.record(proto.Entry_ADD, cleanPath(path), entry)
+	case err != nil:
+		return nil, &os.PathError{Op: "create", Path: path, Err: err}
+	}
+
 	return s.Open(path, opts...)
 }
 
+// Open opens the file at path. If there is an error, it will be of type
+// *os.PathError.
 func (s *Store) Open(path string, opts ...FileOption) (*File, error) {
+	e, err := s.idx.stat(cleanPath(path))
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
 	f := &File{
-		rw:   s.rw,
-		idx:  s.idx,
-		path: path,
+		id:       e.Id,
+		s:        s,
+		rw:       s.rw,
+		idx:      s.idx,
+		path:     path,
+		readonly: e.Readonly,
+	}
+
+	if pol := s.DataPlacementPolicy(); s.writable() == nil && !f.readonly && pol.Match(filepath.Base(path), 0) {
+		f.pol = pol
+		f.small = &bytes.Buffer{}
 	}
 
 	for _, opt := range opts {
@@ -80,12 +171,142 @@ func (f *File) Name() string {
 	return f.path
 }
 
+// Close closes the file. The data of a file that matches the data placement
+// policy is written to the index partition. If the file was written, its
+// entry is updated with the extents of the written data, which replace the
+// previous content of the file.
 func (f *File) Close() error {
+	if f.small != nil && f.small.Len() > 0 {
+		buf := f.small
+		f.small = nil
+
+		block, err := f.s.writeIndexPartition(buf.Bytes())
+		if err != nil {
+			return &os.PathError{Op: "close", Path: f.path, Err: err}
+		}
+
+		f.logExtent(&proto.Extent{
+			Partition: ltfs.IndexPartition,
+			Block:     block,
+			Length:    uint64(buf.Len()),
+			Offset:    f.offset,
+		})
+	}
+
+	if !f.written {
+		return nil
+	}
+
+	f.written = false
+
+	// the data of a closed file is on the device
+	if err := f.rw.flush(); err != nil {
+		return &os.PathError{Op: "close", Path: f.path, Err: err}
+	}
+
+	extents := f.idx.takeExtents(f)
+
+	var old *proto.Entry
+	err := f.idx.update(f.path, func(e *proto.Entry) error {
+		file := e.GetFile()
+		if file == nil || e.Id != f.id {
+			// the file was removed (or replaced) while open
+			return os.ErrNotExist
+		}
+
+		old = pb.Clone(e).(*proto.Entry)
+
+		now := time.Now().UnixNano()
+		e.ModifyTime = now
+		e.ChangeTime = now
+
+		file.Length = f.offset
+		file.Extents = extents
+
+		return nil
+	})
+
+	if err != nil {
+		return &os.PathError{Op: "close", Path: f.path, Err: err}
+	}
+
+	if err := f.s.release(old); err != nil {
+		return &os.PathError{Op: "close", Path: f.path, Err: err}
+	}
+
+	entry, err := f.idx.stat(cleanPath(f.path))
+	if err != nil {
+		return &os.PathError{Op: "close", Path: f.path, Err: err}
+	}
+
+	f.s.journal.record(proto.Entry_CH, cleanPath(f.path), entry)
+
+	if err := f.s.writeLog(); err != nil {
+		return &os.PathError{Op: "close", Path: f.path, Err: err}
+	}
+
 	return nil
 }
 
 func (f *File) Write(p []byte) (n int, err error) {
-	return f.rw.Write(p)
+	if err := f.s.writable(); err != nil {
+		return 0, &os.PathError{Op: "write", Path: f.path, Err: err}
+	}
+
+	if f.readonly {
+		return 0, &os.PathError{Op: "write", Path: f.path, Err: syscall.EPERM}
+	}
+
+	f.written = true
+
+	if f.small != nil {
+		if f.small.Len()+len(p) <= f.pol.Size {
+			return f.small.Write(p)
+		}
+
+		// the file outgrew the policy and goes to the data partition
+		buf := f.small
+		f.small = nil
+
+		if buf.Len() > 0 {
+			if _, err := f.writeData(buf.Bytes()); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return f.writeData(p)
+}
+
+// writeData appends p to the file on the data partition.
+func (f *File) writeData(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	e, err := f.rw.write(p, f.fopts.noBatch)
+	if err != nil {
+		return 0, &os.PathError{Op: "write", Path: f.path, Err: err}
+	}
+
+	e.Offset = f.offset
+	f.logExtent(e)
+
+	if err := f.s.writeLog(); err != nil {
+		return len(p), &os.PathError{Op: "write", Path: f.path, Err: err}
+	}
+
+	return len(p), nil
+}
+
+// logExtent adds the extent e, written at the end of the file, to the file
+// and the journal.
+func (f *File) logExtent(e *proto.Extent) {
+	f.offset += e.Length
+
+	f.idx.addExtent(f, e)
+	f.s.journal.extent(f.id, e)
+	f.s.journal.wrote(e.Length)
 }
 
 func (f *File) Read(p []byte) (n int, err error) {
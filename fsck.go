@@ -0,0 +1,758 @@
+package bltfs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"hpt.space/bltfs/backend"
+	"hpt.space/bltfs/ltfs"
+	"hpt.space/bltfs/proto"
+	"hpt.space/bltfs/util/xmlutil"
+)
+
+// LostAndFound is the name of the directory (in the root of the volume) that
+// Repair places recovered data in.
+const LostAndFound = "_ltfs_lostandfound"
+
+// ProblemKind classifies the problems found by Check.
+type ProblemKind int
+
+const (
+	// ProblemLabel is an unreadable label or labels that disagree between
+	// the partitions.
+	ProblemLabel ProblemKind = iota
+
+	// ProblemIndex is an unreadable index or a broken generation chain.
+	ProblemIndex
+
+	// ProblemExtent is an extent that does not point at records within EOD.
+	ProblemExtent
+
+	// ProblemOverlap is an extent overlapping another extent or an index.
+	ProblemOverlap
+
+	// ProblemOrphanedData is data after the last index that is not
+	// referenced by any index.
+	ProblemOrphanedData
+)
+
+var problemKinds = [...]string{
+	ProblemLabel:        "label",
+	ProblemIndex:        "index",
+	ProblemExtent:       "extent",
+	ProblemOverlap:      "overlap",
+	ProblemOrphanedData: "orphaned data",
+}
+
+func (k ProblemKind) String() string {
+	return problemKinds[k]
+}
+
+// Problem is an inconsistency found by Check.
+type Problem struct {
+	Kind ProblemKind
+
+	// Generation is the index generation the problem was found in, or zero
+	// if the problem is not specific to a generation.
+	Generation int
+
+	// Path is the path of the affected file, if any.
+	Path string
+
+	Msg string
+}
+
+func (p Problem) String() string {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "%s", p.Kind)
+
+	if p.Generation != 0 {
+		fmt.Fprintf(&buf, " (generation %d)", p.Generation)
+	}
+
+	if p.Path != "" {
+		fmt.Fprintf(&buf, " %s", p.Path)
+	}
+
+	fmt.Fprintf(&buf, ": %s", p.Msg)
+
+	return buf.String()
+}
+
+// BlockRange is the range of blocks [Start, End) on a partition.
+type BlockRange struct {
+	Partition  uint32
+	Start, End uint64
+}
+
+// CheckReport is the result of checking a volume.
+type CheckReport struct {
+	Label *ltfs.LabelLTFS
+
+	// EOD is the end of data position of each partition.
+	EOD [2]uint64
+
+	// Generations holds the index generations reachable from the newest
+	// index, newest first.
+	Generations []*Generation
+
+	// Orphaned holds the records after the last index on either partition
+	// that are not referenced by the newest index.
+	Orphaned []BlockRange
+
+	Problems []Problem
+
+	pmap ltfs.PartitionMap
+
+	// the lengths of the records of each orphaned range
+	orphanRecords [][]uint64
+
+	// the newest index on the index and data partitions
+	heads [2]*Generation
+}
+
+// Consistent reports whether no problems were found.
+func (r *CheckReport) Consistent() bool {
+	return len(r.Problems) == 0
+}
+
+// LastConsistent returns the newest consistent generation or nil if there is
+// none.
+func (r *CheckReport) LastConsistent() *Generation {
+	for _, g := range r.Generations {
+		if g.Consistent {
+			return g
+		}
+	}
+
+	return nil
+}
+
+func (r *CheckReport) addProblem(kind ProblemKind, gen int, path string, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, Problem{
+		Kind:       kind,
+		Generation: gen,
+		Path:       path,
+		Msg:        fmt.Sprintf(format, args...),
+	})
+}
+
+type checker struct {
+	// used for device I/O only
+	s *Store
+
+	report  *CheckReport
+	blkSize uint64
+
+	// cache of the record lengths at positions (zero if there is no record)
+	records map[BlockRange]uint64
+}
+
+// Check checks the consistency of the volume in dev. Every index generation
+// reachable through the PreviousGeneration chain of the newest index is
+// read and its extents are verified to start and end on records within EOD
+// and not to overlap extents of other files or indexes. Records after the
+// last index on either partition that are not referenced by the newest index
+// are reported as orphaned.
+//
+// An error is returned only if the volume cannot be checked at all;
+// inconsistencies are reported in CheckReport.Problems.
+func Check(dev backend.Interface) (*CheckReport, error) {
+	c := &checker{
+		s:       &Store{},
+		report:  &CheckReport{},
+		records: make(map[BlockRange]uint64),
+	}
+
+	c.s.mu.backend = dev
+
+	if err := dev.Load(); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkLabels(); err != nil {
+		return nil, err
+	}
+
+	for _, part := range []uint32{ltfs.IndexPartition, ltfs.DataPartition} {
+		if err := dev.Locate(part, TapeBlockMax); err != nil {
+			return nil, errors.Wrap(err, "failed to seek to EOD")
+		}
+
+		eod, err := dev.ReadPosition()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read position")
+		}
+
+		c.report.EOD[part] = eod
+	}
+
+	c.walkGenerations()
+
+	for _, g := range c.report.Generations {
+		c.checkExtents(g)
+	}
+
+	c.findOrphans()
+
+	// a generation is consistent if no problems were found in it
+	bad := make(map[int]bool)
+	for _, p := range c.report.Problems {
+		bad[p.Generation] = true
+	}
+
+	for _, g := range c.report.Generations {
+		g.Consistent = !bad[g.Number]
+	}
+
+	return c.report, nil
+}
+
+// checkLabels reads the labels on both partitions and compares them.
+func (c *checker) checkLabels() error {
+	r := c.report
+
+	label, err := c.s.readLTFSLabel(ltfs.IndexPartition)
+	if err != nil {
+		return errors.Wrap(err, "failed to read LTFS label")
+	}
+
+	pmap, err := label.PartitionMap()
+	if err != nil {
+		return errors.Wrap(err, "invalid LTFS label")
+	}
+
+	r.Label = label
+	r.pmap = pmap
+	c.blkSize = uint64(label.BlockSize)
+
+	if c.blkSize == 0 {
+		return errors.New("invalid LTFS label: zero block size")
+	}
+
+	for _, part := range []uint32{ltfs.IndexPartition, ltfs.DataPartition} {
+		if !c.isVOL1(part) {
+			r.addProblem(ProblemLabel, 0, "", "missing VOL1 label on partition %d", part)
+		}
+	}
+
+	if id, _ := pmap.ID(ltfs.IndexPartition); label.Partition != id {
+		r.addProblem(ProblemLabel, 0, "", "label on index partition claims partition %q", label.Partition)
+	}
+
+	dpLabel, err := c.s.readLTFSLabel(ltfs.DataPartition)
+	if err != nil {
+		r.addProblem(ProblemLabel, 0, "", "failed to read label on data partition: %v", err)
+		return nil
+	}
+
+	if id, _ := pmap.ID(ltfs.DataPartition); dpLabel.Partition != id {
+		r.addProblem(ProblemLabel, 0, "", "label on data partition claims partition %q", dpLabel.Partition)
+	}
+
+	for _, field := range labelDiff(label, dpLabel) {
+		r.addProblem(ProblemLabel, 0, "", "labels disagree on %s", field)
+	}
+
+	return nil
+}
+
+// labelDiff returns the names of the fields (other than the location) that
+// differ between the labels.
+func labelDiff(a, b *ltfs.LabelLTFS) []string {
+	var diff []string
+
+	fields := []struct {
+		name  string
+		equal bool
+	}{
+		{"version", a.Version == b.Version},
+		{"creator", a.Creator == b.Creator},
+		{"formattime", time.Time(a.FormatTime).Equal(time.Time(b.FormatTime))},
+		{"volumeuuid", a.VolumeUUID == b.VolumeUUID},
+		{"partitions", a.IndexPartition == b.IndexPartition && a.DataPartition == b.DataPartition},
+		{"blocksize", a.BlockSize == b.BlockSize},
+		{"compression", a.Compression == b.Compression},
+	}
+
+	for _, f := range fields {
+		if !f.equal {
+			diff = append(diff, f.name)
+		}
+	}
+
+	return diff
+}
+
+func (c *checker) isVOL1(part uint32) bool {
+	dev := c.s.mu.backend
+
+	if err := dev.Locate(part, 0); err != nil {
+		return false
+	}
+
+	buf := make([]byte, dev.BlockSize())
+
+	n, err := dev.Read(buf)
+	if err != nil {
+		return false
+	}
+
+	return bytes.HasPrefix(buf[:n], []byte("VOL1"))
+}
+
+// isRecord reports whether the given block holds a record (as opposed to a
+// filemark or nothing at all).
+func (c *checker) isRecord(part uint32, block uint64) bool {
+	return c.recordLength(part, block) > 0
+}
+
+// recordLength returns the length of the record at the given block, or zero
+// if there is no record.
+func (c *checker) recordLength(part uint32, block uint64) uint64 {
+	pos := BlockRange{Partition: part, Start: block, End: block + 1}
+	if n, cached := c.records[pos]; cached {
+		return n
+	}
+
+	dev := c.s.mu.backend
+	buf := make([]byte, dev.BlockSize())
+
+	var n uint64
+	if err := dev.Locate(part, block); err == nil {
+		if m, err := dev.Read(buf); err == nil {
+			n = uint64(m)
+		}
+	}
+
+	c.records[pos] = n
+
+	return n
+}
+
+// span is a byte range on a partition occupied by an extent or an index.
+type span struct {
+	part       uint32
+	start, end uint64
+	uid        int
+	path       string
+}
+
+// visitFiles streams the index of the generation again, calling fn for each
+// file. The device must not be used by fn.
+func (c *checker) visitFiles(g *Generation, fn func(f *ltfs.File, path string)) error {
+	dec := &ltfs.IndexDecoder{
+		File: func(path string, f *ltfs.File) error {
+			fn(f, path)
+			return nil
+		},
+	}
+
+	_, _, err := c.s.decodeLTFSIndexAt(g.Partition, g.Block, dec)
+
+	return err
+}
+
+// checkExtents verifies the extents of all files in the generation.
+func (c *checker) checkExtents(g *Generation) {
+	r := c.report
+
+	var spans []span
+
+	// the first and last block of each extent must be records; they are
+	// checked once the index has been read
+	type bounds struct {
+		part        uint32
+		first, last uint64
+		path        string
+	}
+
+	var pending []bounds
+
+	// the indexes themselves occupy space
+	for _, other := range r.Generations {
+		spans = append(spans, span{
+			part:  other.Partition,
+			start: other.Block * c.blkSize,
+			end:   other.end * c.blkSize,
+			uid:   -1,
+			path:  fmt.Sprintf("<index generation %d>", other.Number),
+		})
+	}
+
+	err := c.visitFiles(g, func(f *ltfs.File, path string) {
+		for _, ex := range f.ExtentInfo {
+			part, err := r.pmap.Number(ex.Partition)
+			if err != nil {
+				r.addProblem(ProblemExtent, g.Number, path, "%v", err)
+				continue
+			}
+
+			if ex.StartBlock < 0 || ex.ByteOffset < 0 || ex.ByteCount <= 0 || uint64(ex.ByteOffset) >= c.blkSize {
+				r.addProblem(ProblemExtent, g.Number, path, "invalid extent %d:%d+%d (%d bytes)", part, ex.StartBlock, ex.ByteOffset, ex.ByteCount)
+				continue
+			}
+
+			start := uint64(ex.StartBlock)*c.blkSize + uint64(ex.ByteOffset)
+			end := start + uint64(ex.ByteCount)
+
+			first, last := uint64(ex.StartBlock), (end-1)/c.blkSize
+
+			if first <= labelBlock+1 {
+				r.addProblem(ProblemExtent, g.Number, path, "extent at %d:%d overlaps the label", part, first)
+				continue
+			}
+
+			if last >= r.EOD[part] {
+				r.addProblem(ProblemExtent, g.Number, path, "extent %d:%d-%d extends beyond EOD (%d)", part, first, last, r.EOD[part])
+				continue
+			}
+
+			pending = append(pending, bounds{part, first, last, path})
+			spans = append(spans, span{part, start, end, f.FileUID, path})
+		}
+	})
+
+	if err != nil {
+		r.addProblem(ProblemIndex, g.Number, "", "failed to read index: %v", err)
+		return
+	}
+
+	for _, b := range pending {
+		for _, blk := range []uint64{b.first, b.last} {
+			if !c.isRecord(b.part, blk) {
+				r.addProblem(ProblemExtent, g.Number, b.path, "extent block %d:%d is not a record", b.part, blk)
+				break
+			}
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool {
+		if spans[i].part != spans[j].part {
+			return spans[i].part < spans[j].part
+		}
+
+		return spans[i].start < spans[j].start
+	})
+
+	// compare each span to the span reaching furthest among its predecessors
+	for i, furthest := 1, 0; i < len(spans); i++ {
+		prev, s := spans[furthest], spans[i]
+
+		if s.part == prev.part && s.start < prev.end && s.uid != prev.uid && (s.uid != -1 || prev.uid != -1) {
+			path := s.path
+			if s.uid == -1 {
+				path = prev.path
+			}
+
+			r.addProblem(ProblemOverlap, g.Number, path, "%s overlaps %s on partition %d", s.path, prev.path, s.part)
+		}
+
+		if s.part != prev.part || s.end > prev.end {
+			furthest = i
+		}
+	}
+}
+
+// findOrphans finds the records after the last index on each partition that
+// are not referenced by the newest generation. Data is placed on the index
+// partition after its last index until the next index is written (see
+// Store.writeIndexPartition).
+func (c *checker) findOrphans() {
+	r := c.report
+
+	if len(r.Generations) == 0 {
+		return
+	}
+
+	var referenced [2]map[uint64]bool
+	for part := range referenced {
+		referenced[part] = make(map[uint64]bool)
+	}
+
+	err := c.visitFiles(r.Generations[0], func(f *ltfs.File, _ string) {
+		for _, ex := range f.ExtentInfo {
+			part, err := r.pmap.Number(ex.Partition)
+			if err != nil || ex.ByteCount <= 0 {
+				continue
+			}
+
+			start := uint64(ex.StartBlock)*c.blkSize + uint64(ex.ByteOffset)
+			for blk := uint64(ex.StartBlock); blk <= (start+uint64(ex.ByteCount)-1)/c.blkSize; blk++ {
+				referenced[part][blk] = true
+			}
+		}
+	})
+
+	// checkExtents reports an index that cannot be read
+	if err != nil {
+		return
+	}
+
+	for _, part := range []uint32{ltfs.DataPartition, ltfs.IndexPartition} {
+		head := r.heads[part]
+		if head == nil {
+			continue
+		}
+
+		var orphan *BlockRange
+		for blk := head.end; blk < r.EOD[part]; blk++ {
+			n := c.recordLength(part, blk)
+			if referenced[part][blk] || n == 0 {
+				orphan = nil
+				continue
+			}
+
+			if orphan == nil {
+				r.Orphaned = append(r.Orphaned, BlockRange{Partition: part, Start: blk})
+				r.orphanRecords = append(r.orphanRecords, nil)
+				orphan = &r.Orphaned[len(r.Orphaned)-1]
+			}
+
+			orphan.End = blk + 1
+
+			last := len(r.orphanRecords) - 1
+			r.orphanRecords[last] = append(r.orphanRecords[last], n)
+		}
+	}
+
+	for _, o := range r.Orphaned {
+		r.addProblem(ProblemOrphanedData, 0, "", "blocks %d-%d on partition %d are not referenced", o.Start, o.End-1, o.Partition)
+	}
+}
+
+// RepairMode selects how Repair restores a consistent volume.
+type RepairMode int
+
+const (
+	// Rollback makes the last consistent generation the current one.
+	Rollback RepairMode = iota
+
+	// Recover writes a recovery index based on the last consistent
+	// generation in which orphaned data is made available as files in the
+	// LostAndFound directory.
+	Recover
+)
+
+// Repair writes a new index generation to the volume in dev based on the last
+// consistent generation of the report (as returned by Check). The index is
+// written to the end of the data partition and replaces the last index on the
+// index partition, unless data was placed after it. It is streamed from a
+// binary index built from the last consistent generation (see
+// NewIndexFromReader). It returns the new generation.
+func Repair(dev backend.Interface, report *CheckReport, mode RepairMode) (*Generation, error) {
+	base := report.LastConsistent()
+	if base == nil {
+		return nil, errors.New("no consistent index generation to repair from")
+	}
+
+	s := &Store{}
+	s.mu.backend = dev
+
+	if err := dev.Locate(base.Partition, base.Block); err != nil {
+		return nil, errors.Wrap(err, "failed to locate index")
+	}
+
+	dir, err := ioutil.TempDir("", "bltfs")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := openIndexDB(filepath.Join(dir, "index.db"), CaseSensitive)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// read a fresh copy of the index
+	binIdx, idx, err := NewIndexFromReader(s.newRecordReader(), report.pmap, db)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read index")
+	}
+
+	switch idx.VolumeLockState {
+	case ltfs.VolumeLocked:
+		return nil, ErrVolumeLocked
+	case ltfs.VolumePermLocked:
+		return nil, ErrVolumePermLocked
+	}
+
+	preface := idx.IndexPreface
+
+	// never reuse generation numbers or file UIDs
+	for _, g := range report.Generations {
+		if g.Number >= preface.Generation {
+			preface.Generation = g.Number + 1
+		}
+
+		if err := binIdx.reserveUID(uint64(g.Index.HighestFileUID)); err != nil {
+			return nil, err
+		}
+	}
+
+	if mode == Recover && len(report.Orphaned) > 0 {
+		if err := addLostAndFound(binIdx, report); err != nil {
+			return nil, errors.Wrap(err, "failed to add orphaned data")
+		}
+	}
+
+	preface.Creator = ltfs.Creator
+	preface.UpdateTime = xmlutil.TimeNow()
+	preface.HighestFileUID = int(atomic.LoadUint64(&binIdx.lastUID))
+	preface.PreviousGeneration = ltfs.PreviousGeneration{}
+
+	if dp := report.heads[ltfs.DataPartition]; dp != nil {
+		preface.PreviousGeneration = ltfs.PreviousGeneration{
+			Partition:  report.pmap.Data,
+			StartBlock: int(dp.Block),
+		}
+	}
+
+	// the data partition copy goes after the last data
+	dp, dpEnd, dropped, err := s.writeTapeIndex(binIdx, ltfs.DataPartition, report.EOD[ltfs.DataPartition], preface)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write index to data partition")
+	}
+
+	// the generation repaired cannot be written in its own format version,
+	// so the index partition is left alone
+	if len(dropped) > 0 {
+		return nil, errors.Errorf("format version %s of generation %d cannot hold %v", preface.Version, dp.Generation, dropped)
+	}
+
+	report.EOD[ltfs.DataPartition] = dpEnd
+
+	g := &Generation{
+		Number:     dp.Generation,
+		UpdateTime: time.Time(dp.UpdateTime),
+		Partition:  ltfs.DataPartition,
+		Block:      uint64(dp.StartBlock),
+		Consistent: true,
+		Index:      &ltfs.Index{XMLName: idx.XMLName, IndexPreface: dp, Root: idx.Root},
+		end:        dpEnd,
+	}
+
+	err = binIdx.view(func(t *indexTx) error {
+		st, err := t.stats()
+		g.Files = int(st.Files + st.Symlinks)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the index partition copy replaces the last index there, unless data
+	// was placed after it, and points back to the data partition copy
+	start, err := s.indexPartitionStart()
+	if err != nil {
+		return nil, err
+	}
+
+	preface.PreviousGeneration = ltfs.PreviousGeneration{
+		Partition:  report.pmap.Data,
+		StartBlock: dp.StartBlock,
+	}
+
+	_, ipEnd, _, err := s.writeTapeIndex(binIdx, ltfs.IndexPartition, start, preface)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write index to index partition")
+	}
+
+	report.EOD[ltfs.IndexPartition] = ipEnd
+
+	return g, nil
+}
+
+// addLostAndFound adds a file for each orphaned block range to the
+// LostAndFound directory of idx. Since the length of the data is unknown,
+// each file covers its blocks entirely.
+func addLostAndFound(idx *index, report *CheckReport) error {
+	now := time.Now().UnixNano()
+	dir := "/" + LostAndFound
+
+	if _, err := idx.stat(dir); err == os.ErrNotExist {
+		err := idx.create(dir, &proto.Entry{
+			Name:       LostAndFound,
+			CreateTime: now,
+			ChangeTime: now,
+			ModifyTime: now,
+			AccessTime: now,
+			BackupTime: now,
+
+			Elem: &proto.Entry_Dir{
+				Dir: &proto.Directory{},
+			},
+		})
+
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	blkSize := uint64(report.Label.BlockSize)
+
+	for i, o := range report.Orphaned {
+		name := fmt.Sprintf("blocks_%d-%d", o.Start, o.End-1)
+		if o.Partition == ltfs.IndexPartition {
+			name = "indexpartition_" + name
+		}
+
+		// the bytes of an extent are contiguous, so a short record ends
+		// it
+		var (
+			extents []*proto.Extent
+			length  uint64
+			short   = true
+		)
+
+		for j, n := range report.orphanRecords[i] {
+			if short {
+				extents = append(extents, &proto.Extent{
+					Partition: o.Partition,
+					Block:     o.Start + uint64(j),
+					Offset:    length,
+				})
+			}
+
+			extents[len(extents)-1].Length += n
+			length += n
+			short = n < blkSize
+		}
+
+		err := idx.create(dir+"/"+name, &proto.Entry{
+			Name:       name,
+			CreateTime: now,
+			ChangeTime: now,
+			ModifyTime: now,
+			AccessTime: now,
+			BackupTime: now,
+
+			Elem: &proto.Entry_File{
+				File: &proto.File{
+					Length:  length,
+					Extents: extents,
+				},
+			},
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
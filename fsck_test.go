@@ -0,0 +1,340 @@
+package bltfs_test
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"hpt.space/bltfs"
+	"hpt.space/bltfs/backend"
+	filedebug "hpt.space/bltfs/backend/file"
+	"hpt.space/bltfs/ltfs"
+	"hpt.space/bltfs/util/testutil"
+)
+
+const fsckBlockSize = 512 * 1024
+
+// makeFsckTape creates a tape holding two index generations. Generation 1 is
+// empty; generation 2 holds /file1 recorded in blocks 5 and 6 of the data
+// partition. The layout is
+//
+//	index partition: VOL1, label, FM, index 2, FM
+//	data partition:  VOL1, label, FM, index 1, FM, data, data, FM, index 2, FM
+//
+// mutate may modify the generations and the data partition label before they
+// are written.
+func makeFsckTape(t *testing.T, mutate func(gen1, gen2 *ltfs.Index, dpLabel *ltfs.LabelLTFS)) string {
+	gen1 := makeTestIndex()
+	gen1.Generation = 1
+	gen1.Partition = "b"
+	gen1.StartBlock = 3
+	gen1.HighestFileUID = 1
+	gen1.Root.Contents = &ltfs.Contents{}
+
+	gen2 := makeTestIndex()
+	gen2.Generation = 2
+	gen2.Partition = "b"
+	gen2.StartBlock = 8
+	gen2.PreviousGeneration = ltfs.PreviousGeneration{Partition: "b", StartBlock: 3}
+	gen2.HighestFileUID = 2
+	gen2.Root.Contents = &ltfs.Contents{
+		Files: []*ltfs.File{makeTestFile(2, "file1", fsckBlockSize+10, 5)},
+	}
+
+	label := ltfs.LabelLTFS{
+		XMLName:        xml.Name{Space: "", Local: "ltfslabel"},
+		Version:        ltfs.Version,
+		Creator:        ltfs.Creator,
+		FormatTime:     testutil.TestTime,
+		VolumeUUID:     gen1.VolumeUUID,
+		Partition:      "a",
+		IndexPartition: "a",
+		DataPartition:  "b",
+		BlockSize:      fsckBlockSize,
+	}
+
+	dpLabel := label
+	dpLabel.Partition = "b"
+
+	if mutate != nil {
+		mutate(gen1, gen2, &dpLabel)
+	}
+
+	// the index partition copy of generation 2
+	ipGen2 := *gen2
+	ipGen2.Partition = "a"
+	ipGen2.StartBlock = 3
+	ipGen2.PreviousGeneration = ltfs.PreviousGeneration{Partition: "b", StartBlock: 8}
+
+	marshal := func(v interface{}) []byte {
+		buf, err := xml.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return buf
+	}
+
+	dir := setupCleanTape()
+
+	writeTestRecords(t, dir, ltfs.IndexPartition, [][]byte{
+		[]byte("VOL1"), marshal(label), nil, marshal(&ipGen2), nil,
+	})
+
+	writeTestRecords(t, dir, ltfs.DataPartition, [][]byte{
+		[]byte("VOL1"), marshal(dpLabel), nil, marshal(gen1), nil,
+		[]byte("data"), []byte("more data"), nil, marshal(gen2), nil,
+	})
+
+	return dir
+}
+
+// writeTestRecords writes the records (a nil record is a filemark) at the end
+// of the partition of the tape in dir.
+func writeTestRecords(t *testing.T, dir string, part uint32, recs [][]byte) {
+	dev := openTestDevice(t, dir)
+
+	if err := dev.Locate(part, bltfs.TapeBlockMax); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rec := range recs {
+		var err error
+		if rec == nil {
+			err = dev.WriteFilemark(1)
+		} else {
+			_, err = dev.Write(rec)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := dev.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func openTestDevice(t *testing.T, dir string) backend.Interface {
+	dev, err := filedebug.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dev.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	return dev
+}
+
+func checkTestTape(t *testing.T, dir string) *bltfs.CheckReport {
+	report, err := bltfs.Check(openTestDevice(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return report
+}
+
+func generationNumbers(report *bltfs.CheckReport) []int {
+	var gens []int
+	for _, g := range report.Generations {
+		gens = append(gens, g.Number)
+	}
+
+	return gens
+}
+
+func expectProblem(t *testing.T, report *bltfs.CheckReport, kind bltfs.ProblemKind, gen int) {
+	for _, p := range report.Problems {
+		if p.Kind == kind && p.Generation == gen {
+			return
+		}
+	}
+
+	t.Errorf("expected %s problem in generation %d, got %v", kind, gen, report.Problems)
+}
+
+func TestCheckConsistent(t *testing.T) {
+	dir := makeFsckTape(t, nil)
+	defer cleanup(dir)
+
+	report := checkTestTape(t, dir)
+
+	if !report.Consistent() {
+		t.Fatalf("unexpected problems: %v", report.Problems)
+	}
+
+	if gens := generationNumbers(report); len(gens) != 2 || gens[0] != 2 || gens[1] != 1 {
+		t.Fatalf("unexpected generations %v", gens)
+	}
+
+	g := report.Generations[0]
+	if g.Partition != ltfs.DataPartition || g.Block != 8 || g.Files != 1 || !g.Consistent {
+		t.Errorf("unexpected generation %+v", g)
+	}
+}
+
+func TestCheckOrphanedData(t *testing.T) {
+	dir := makeFsckTape(t, nil)
+	defer cleanup(dir)
+
+	// data written after the last index
+	writeTestRecords(t, dir, ltfs.DataPartition, [][]byte{[]byte("lost"), []byte("found")})
+
+	report := checkTestTape(t, dir)
+
+	if len(report.Orphaned) != 1 || report.Orphaned[0] != (bltfs.BlockRange{Partition: ltfs.DataPartition, Start: 10, End: 12}) {
+		t.Fatalf("unexpected orphaned data %v", report.Orphaned)
+	}
+
+	expectProblem(t, report, bltfs.ProblemOrphanedData, 0)
+
+	dev := openTestDevice(t, dir)
+
+	g, err := bltfs.Repair(dev, report, bltfs.Recover)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Number != 3 || g.Files != 2 {
+		t.Errorf("unexpected generation %+v", g)
+	}
+
+	report = checkTestTape(t, dir)
+	if !report.Consistent() {
+		t.Fatalf("unexpected problems after repair: %v", report.Problems)
+	}
+
+	// the recovery index is the current one
+	store, err := bltfs.Open(openTestDevice(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// the file holds the records as they were written
+	expectContent(t, store, "/"+bltfs.LostAndFound+"/blocks_10-11", []byte("lostfound"))
+}
+
+func TestCheckOrphanedIndexPartitionData(t *testing.T) {
+	dir := makeFsckTape(t, nil)
+	defer cleanup(dir)
+
+	// data placed on the index partition after the last index
+	writeTestRecords(t, dir, ltfs.IndexPartition, [][]byte{[]byte("placed")})
+
+	report := checkTestTape(t, dir)
+
+	if len(report.Orphaned) != 1 || report.Orphaned[0] != (bltfs.BlockRange{Partition: ltfs.IndexPartition, Start: 5, End: 6}) {
+		t.Fatalf("unexpected orphaned data %v", report.Orphaned)
+	}
+
+	if _, err := bltfs.Repair(openTestDevice(t, dir), report, bltfs.Recover); err != nil {
+		t.Fatal(err)
+	}
+
+	// the index partition copy is written after the data
+	if rec := readTestRecord(t, dir, ltfs.IndexPartition, 5); string(rec) != "placed" {
+		t.Errorf("expected placed data to be kept, got %q", rec)
+	}
+
+	report = checkTestTape(t, dir)
+	if !report.Consistent() {
+		t.Fatalf("unexpected problems after repair: %v", report.Problems)
+	}
+
+	store, err := bltfs.Open(openTestDevice(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if v, err := store.Getxattr("/"+bltfs.LostAndFound+"/indexpartition_blocks_5-5", "ltfs.partition"); err != nil || string(v) != "a" {
+		t.Errorf("expected recovered file on the index partition, got %q (%v)", v, err)
+	}
+}
+
+func TestCheckBadExtent(t *testing.T) {
+	dir := makeFsckTape(t, func(gen1, gen2 *ltfs.Index, _ *ltfs.LabelLTFS) {
+		gen2.Root.Contents.Files[0].ExtentInfo[0].StartBlock = 50
+	})
+	defer cleanup(dir)
+
+	report := checkTestTape(t, dir)
+
+	expectProblem(t, report, bltfs.ProblemExtent, 2)
+
+	if g := report.LastConsistent(); g == nil || g.Number != 1 {
+		t.Fatalf("unexpected last consistent generation %v", g)
+	}
+
+	if _, err := bltfs.Repair(openTestDevice(t, dir), report, bltfs.Rollback); err != nil {
+		t.Fatal(err)
+	}
+
+	report = checkTestTape(t, dir)
+
+	if gens := generationNumbers(report); len(gens) != 3 || gens[0] != 3 {
+		t.Fatalf("unexpected generations %v", gens)
+	}
+
+	if g := report.Generations[0]; !g.Consistent || g.Files != 0 {
+		t.Errorf("unexpected generation %+v", g)
+	}
+}
+
+func TestCheckOverlap(t *testing.T) {
+	dir := makeFsckTape(t, func(gen1, gen2 *ltfs.Index, _ *ltfs.LabelLTFS) {
+		gen2.Root.Contents.Files = append(gen2.Root.Contents.Files,
+			// shares block 6 with file1
+			makeTestFile(3, "file2", 10, 6),
+			// points at the index of generation 1
+			makeTestFile(4, "file3", 10, 3),
+		)
+		gen2.HighestFileUID = 4
+	})
+	defer cleanup(dir)
+
+	report := checkTestTape(t, dir)
+
+	var overlaps int
+	for _, p := range report.Problems {
+		if p.Kind == bltfs.ProblemOverlap {
+			overlaps++
+		}
+	}
+
+	if overlaps != 2 {
+		t.Errorf("expected 2 overlaps, got %v", report.Problems)
+	}
+}
+
+func TestCheckLabels(t *testing.T) {
+	dir := makeFsckTape(t, func(_, _ *ltfs.Index, dpLabel *ltfs.LabelLTFS) {
+		dpLabel.VolumeUUID = uuid.New()
+		dpLabel.BlockSize = 1024
+	})
+	defer cleanup(dir)
+
+	report := checkTestTape(t, dir)
+
+	var labels int
+	for _, p := range report.Problems {
+		if p.Kind == bltfs.ProblemLabel {
+			labels++
+		}
+	}
+
+	if labels != 2 {
+		t.Errorf("expected 2 label problems, got %v", report.Problems)
+	}
+
+	// the indexes are fine
+	if g := report.LastConsistent(); g == nil || g.Number != 2 {
+		t.Errorf("unexpected last consistent generation %v", g)
+	}
+}
@@ -0,0 +1,275 @@
+package bltfs
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"hpt.space/bltfs/ltfs"
+)
+
+// Generation describes an index generation recorded on a volume.
+type Generation struct {
+	Number     int
+	UpdateTime time.Time
+
+	// Location of the index.
+	Partition uint32
+	Block     uint64
+
+	// Files is the number of files (including symbolic links) in the
+	// generation.
+	Files int
+
+	// Consistent is false if Check found problems in the generation. It is
+	// only set by Check.
+	Consistent bool
+
+	// Index is the index of the generation without its directory tree
+	// (the root directory holds its own metadata only). It is nil for
+	// generations returned by Store.Generations.
+	Index *ltfs.Index
+
+	// the block following the index
+	end uint64
+}
+
+// lastIndex finds the last index recorded on the partition by spacing
+// backwards over filemarks from EOD.
+func (c *checker) lastIndex(part uint32) *Generation {
+	dev := c.s.mu.backend
+
+	// the first file that may hold an index follows the label
+	first := uint64(labelBlock + 2)
+
+	for n := uint64(1); ; n++ {
+		if err := dev.Locate(part, TapeBlockMax); err != nil {
+			return nil
+		}
+
+		if err := dev.SpaceFMB(n); err != nil {
+			return nil
+		}
+
+		start, err := dev.ReadPosition()
+		if err != nil || start < first {
+			return nil
+		}
+
+		if !c.looksLikeIndex(part, start) {
+			continue
+		}
+
+		g, err := c.readGeneration(part, start)
+		if err != nil {
+			continue
+		}
+
+		return g
+	}
+}
+
+// looksLikeIndex reports whether the first record at the given position
+// starts an LTFS index. It avoids reading entire data files while looking for
+// indexes.
+func (c *checker) looksLikeIndex(part uint32, block uint64) bool {
+	dev := c.s.mu.backend
+	buf := make([]byte, dev.BlockSize())
+
+	if err := dev.Locate(part, block); err != nil {
+		return false
+	}
+
+	n, err := dev.Read(buf)
+	if err != nil || n == 0 {
+		return false
+	}
+
+	if n > 512 {
+		n = 512
+	}
+
+	return bytes.Contains(buf[:n], []byte("<ltfsindex"))
+}
+
+// readGeneration reads the index starting at the given block. The index is
+// streamed to count its files; its directory tree is not kept.
+func (c *checker) readGeneration(part uint32, start uint64) (*Generation, error) {
+	var files int
+
+	dec := &ltfs.IndexDecoder{
+		File: func(string, *ltfs.File) error {
+			files++
+			return nil
+		},
+	}
+
+	idx, end, err := c.s.decodeLTFSIndexAt(part, start, dec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Generation{
+		Number:     idx.Generation,
+		UpdateTime: time.Time(idx.UpdateTime),
+		Partition:  part,
+		Block:      start,
+		Files:      files,
+		Index:      idx,
+		end:        end,
+	}, nil
+}
+
+// walkGenerations finds the newest index and follows the PreviousGeneration
+// chain from it.
+func (c *checker) walkGenerations() {
+	r := c.report
+
+	for _, part := range []uint32{ltfs.IndexPartition, ltfs.DataPartition} {
+		r.heads[part] = c.lastIndex(part)
+		if r.heads[part] == nil {
+			r.addProblem(ProblemIndex, 0, "", "no index found on partition %d", part)
+		}
+	}
+
+	ip, dp := r.heads[ltfs.IndexPartition], r.heads[ltfs.DataPartition]
+
+	// the chain is followed from the data partition if the index partition
+	// holds the same generation
+	head := dp
+	switch {
+	case dp == nil:
+		head = ip
+	case ip != nil && ip.Number > dp.Number:
+		head = ip
+		r.addProblem(ProblemIndex, 0, "", "data partition is missing generation %d", ip.Number)
+	case ip != nil && ip.Number < dp.Number:
+		r.addProblem(ProblemIndex, 0, "", "index partition holds generation %d, data partition generation %d", ip.Number, dp.Number)
+	}
+
+	visited := make(map[BlockRange]bool)
+
+	for g := head; g != nil; {
+		idx := g.Index
+
+		visited[BlockRange{g.Partition, g.Block, g.Block + 1}] = true
+
+		// the index partition copy of a generation may point to the data
+		// partition copy of the same generation
+		if n := len(r.Generations); n == 0 || r.Generations[n-1].Number != g.Number {
+			r.Generations = append(r.Generations, g)
+		}
+
+		if idx.VolumeUUID != r.Label.VolumeUUID {
+			r.addProblem(ProblemIndex, g.Number, "", "index belongs to volume %s", idx.VolumeUUID)
+		}
+
+		if part, err := r.pmap.Number(idx.Partition); err != nil || part != g.Partition || uint64(idx.StartBlock) != g.Block {
+			r.addProblem(ProblemIndex, g.Number, "", "index recorded at %d:%d claims location %s:%d", g.Partition, g.Block, idx.Partition, idx.StartBlock)
+		}
+
+		prev := idx.PreviousGeneration
+		if prev.Partition == "" {
+			break
+		}
+
+		part, err := r.pmap.Number(prev.Partition)
+		if err != nil {
+			r.addProblem(ProblemIndex, g.Number, "", "invalid previous generation location: %v", err)
+			break
+		}
+
+		loc := BlockRange{part, uint64(prev.StartBlock), uint64(prev.StartBlock) + 1}
+		if visited[loc] {
+			r.addProblem(ProblemIndex, g.Number, "", "previous generation location %d:%d forms a loop", part, prev.StartBlock)
+			break
+		}
+
+		pg, err := c.readGeneration(part, uint64(prev.StartBlock))
+		if err != nil {
+			r.addProblem(ProblemIndex, g.Number, "", "failed to read previous generation at %d:%d: %v", part, prev.StartBlock, err)
+			break
+		}
+
+		if pg.Number > g.Number || pg.Number == g.Number && part == g.Partition {
+			r.addProblem(ProblemIndex, g.Number, "", "previous generation at %d:%d has generation number %d", part, prev.StartBlock, pg.Number)
+			break
+		}
+
+		g = pg
+	}
+}
+
+// Generations returns the index generations recorded on the volume, newest
+// first. The generations are found by following the PreviousGeneration chain
+// from the newest index; a broken chain ends the list.
+func (s *Store) Generations() ([]*Generation, error) {
+	s.rw.mu.Lock()
+	defer s.rw.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gens, err := s.generations()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.seekEOD(); err != nil {
+		return nil, err
+	}
+
+	for _, g := range gens {
+		g.Index = nil
+	}
+
+	return gens, nil
+}
+
+// generations walks the generation chain. The caller must hold the device.
+func (s *Store) generations() ([]*Generation, error) {
+	c := &checker{
+		s: s,
+		report: &CheckReport{
+			Label: s.ltfs.label,
+			pmap:  s.ltfs.pmap,
+		},
+		records: make(map[BlockRange]uint64),
+	}
+
+	c.walkGenerations()
+
+	if len(c.report.Generations) == 0 {
+		return nil, errors.New("no index generations found")
+	}
+
+	return c.report.Generations, nil
+}
+
+// selectGeneration returns the generation chosen by the store options: the
+// generation with the given number or the newest generation updated at or
+// before the given time.
+func (s *Store) selectGeneration() (*Generation, error) {
+	gens, err := s.generations()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, g := range gens {
+		if s.sopts.generation != 0 && g.Number == s.sopts.generation {
+			return g, nil
+		}
+
+		if s.sopts.generation == 0 && !g.UpdateTime.After(s.sopts.at) {
+			return g, nil
+		}
+	}
+
+	if s.sopts.generation != 0 {
+		return nil, fmt.Errorf("no generation %d", s.sopts.generation)
+	}
+
+	return nil, fmt.Errorf("no generation at or before %s", s.sopts.at.Format(time.RFC3339))
+}
@@ -0,0 +1,111 @@
+package bltfs_test
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"hpt.space/bltfs"
+	"hpt.space/bltfs/ltfs"
+	"hpt.space/bltfs/util/testutil"
+	"hpt.space/bltfs/util/xmlutil"
+)
+
+// makeGenerationTape creates a tape with two generations (see makeFsckTape)
+// where generation 2 was written an hour after generation 1 and /file1 holds
+// the 13 bytes "datamore data".
+func makeGenerationTape(t *testing.T) string {
+	return makeFsckTape(t, func(gen1, gen2 *ltfs.Index, _ *ltfs.LabelLTFS) {
+		gen2.UpdateTime = xmlutil.Time(time.Time(gen1.UpdateTime).Add(time.Hour))
+		gen2.Root.Contents.Files[0] = makeTestFile(2, "file1", 13, 5)
+	})
+}
+
+func TestGenerations(t *testing.T) {
+	dir := makeGenerationTape(t)
+	defer cleanup(dir)
+
+	store, err := bltfs.Open(openTestDevice(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	gens, err := store.Generations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(gens) != 2 {
+		t.Fatalf("expected 2 generations, got %d", len(gens))
+	}
+
+	if g := gens[0]; g.Number != 2 || g.Partition != ltfs.DataPartition || g.Block != 8 || g.Files != 1 {
+		t.Errorf("unexpected generation %+v", g)
+	}
+
+	if g := gens[1]; g.Number != 1 || g.Block != 3 || g.Files != 0 || !g.UpdateTime.Equal(time.Time(testutil.TestTime)) {
+		t.Errorf("unexpected generation %+v", g)
+	}
+
+	// the store is still usable after walking the generations
+	if err := store.Mkdir("/dir2"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenGeneration(t *testing.T) {
+	dir := makeGenerationTape(t)
+	defer cleanup(dir)
+
+	store, err := bltfs.Open(openTestDevice(t, dir), bltfs.WithGeneration(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.Stat("/file1"); !os.IsNotExist(err) {
+		t.Errorf("expected /file1 to not exist in generation 1, got %v", err)
+	}
+
+	err = store.Mkdir("/dir2")
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != syscall.EROFS {
+		t.Errorf("expected EROFS, got %v", err)
+	}
+
+	if _, err := store.Create("/file2"); err == nil {
+		t.Error("expected create to fail on a read-only store")
+	}
+
+	if _, err := bltfs.Open(openTestDevice(t, dir), bltfs.WithGeneration(5)); err == nil {
+		t.Error("expected open at a missing generation to fail")
+	}
+}
+
+func TestOpenTimestamp(t *testing.T) {
+	dir := makeGenerationTape(t)
+	defer cleanup(dir)
+
+	at := time.Time(testutil.TestTime).Add(90 * time.Minute)
+
+	store, err := bltfs.Open(openTestDevice(t, dir), bltfs.WithTimestamp(at))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var buf bytes.Buffer
+	if _, err := store.Retrieve("/file1", &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != "datamore data" {
+		t.Errorf("unexpected content %q", buf.String())
+	}
+
+	if _, err := bltfs.Open(openTestDevice(t, dir), bltfs.WithTimestamp(time.Time(testutil.TestTime).Add(-time.Minute))); err == nil {
+		t.Error("expected open before the first generation to fail")
+	}
+}
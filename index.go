@@ -3,18 +3,24 @@ package bltfs
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/xml"
-	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/boltdb/bolt"
 	pb "github.com/golang/protobuf/proto"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 
+	"hpt.space/bltfs/engine"
+	"hpt.space/bltfs/engine/boltdb"
 	"hpt.space/bltfs/ltfs"
 	"hpt.space/bltfs/proto"
 	"hpt.space/bltfs/util/xmlutil"
@@ -22,186 +28,443 @@ import (
 
 // Index is the binary index computed from the LTFS index.
 type index struct {
-	db      *bolt.DB
+	db      engine.Interface
 	blkSize uint64
 
+	pmap ltfs.PartitionMap
+
+	// names are compared (and keyed) according to policy
+	policy NamePolicy
+
 	extents extents
 
 	root *proto.Entry
 
-	nextUID int
+	// the highest allocated file UID, mirroring the lastuid of the database
+	// (accessed atomically)
+	lastUID uint64
 
+	// the LTFS index the binary index was built from, or last written as
 	meta struct {
-		uuid    uuid.UUID
-		gen     int
-		prevgen int
+		uuid uuid.UUID
+		gen  int
+
+		// the location of the index and its back pointer
+		part  string
+		block int
+		prev  ltfs.PreviousGeneration
 	}
+
+	// set (atomically) once the index has been modified
+	modified int32
 }
 
-func NewIndex(idx *ltfs.Index, db *bolt.DB) (*index, error) {
-	binIdx := &index{
-		db: db,
+// openIndexDB opens (or creates) the bolt database backing the binary index
+// at path. Names are keyed under policy.
+func openIndexDB(path string, policy NamePolicy) (engine.Interface, error) {
+	db, err := boltdb.Open(path)
+	if err != nil {
+		return nil, err
 	}
 
-	type wrap struct {
-		path string
-		buf  []byte
+	if err := initIndexDB(db, policy); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	var ws []*wrap
+	return db, nil
+}
 
-	collector := make(chan *wrap)
-	done := make(chan struct{})
+// initIndexDB creates the buckets of the binary index and migrates a database
+// in the path keyed layout.
+func initIndexDB(db engine.Interface, policy NamePolicy) error {
+	return db.Update(func(tx engine.Tx) error {
+		for _, name := range indexBuckets {
+			if tx.Bucket(name) == nil {
+				return errors.Errorf("failed to create bucket %s", name)
+			}
+		}
 
-	go func() {
-		for w := range collector {
-			ws = append(ws, w)
+		if err := migrateIndexDB(tx, policy); err != nil {
+			return errors.Wrap(err, "failed to migrate index")
 		}
 
-		close(done)
-	}()
+		if err := reindexIndexDB(tx, policy); err != nil {
+			return errors.Wrap(err, "failed to rebuild secondary indexes")
+		}
+
+		t, err := newIndexTx(tx, policy)
+		if err != nil {
+			return err
+		}
+
+		// databases without a lastuid allocated up to their highest UID
+		if k, _ := t.inodes.Cursor().Last(); k != nil {
+			return t.reserveUID(binary.BigEndian.Uint64(k))
+		}
+
+		return nil
+	})
+}
 
-	var tmp proto.Entry
-	// get the protobuf representation of the ltfs.Directory
-	if err := proto.MarshalDirectory(idx.Root, &tmp); err != nil {
-		panic(err)
+func NewIndex(idx *ltfs.Index, pmap ltfs.PartitionMap, db engine.Interface, opts ...IndexOption) (*index, error) {
+	var iopts indexOptions
+	for _, opt := range opts {
+		opt(&iopts)
 	}
 
-	// marshal to bytes
-	buf, err := pb.Marshal(&tmp)
-	if err != nil {
-		panic(err)
+	binIdx := &index{
+		db:     db,
+		pmap:   pmap,
+		policy: iopts.policy,
 	}
 
-	collector <- &wrap{"/", buf}
+	binIdx.setPreface(&idx.IndexPreface)
 
-	for _, f := range idx.Root.Contents.Files {
-		// get the protobuf representation of the ltfs.Directory
-		if err := proto.MarshalFile(f, &tmp); err != nil {
-			panic(err)
-		}
+	if err := initIndexDB(db, binIdx.policy); err != nil {
+		return nil, err
+	}
 
+	type inode struct {
+		key   []byte
+		buf   []byte
+		entry *proto.Entry
+	}
+
+	type dirent struct {
+		key  []byte
+		uid  uint64
+		path string
+	}
+
+	var (
+		inodes  []inode
+		dirents []dirent
+	)
+
+	add := func(parent uint64, name, path string, entry *proto.Entry) error {
 		// marshal to bytes
-		buf, err := pb.Marshal(&tmp)
+		buf, err := pb.Marshal(entry)
 		if err != nil {
-			panic(err)
+			return errors.Wrapf(err, "failed to marshal entry '%s'", path)
 		}
 
-		collector <- &wrap{filepath.Join("/", f.Name), buf}
+		inodes = append(inodes, inode{uidKey(entry.Id), buf, entry})
+		dirents = append(dirents, dirent{direntKey(parent, binIdx.policy.key(name)), entry.Id, path})
+
+		return nil
 	}
 
-	// the visit function is called concurrently, so we take care not to fuck
-	// this up.
-	begin := time.Now()
-	idx.Root.VisitAllEntries(func(d *ltfs.Directory, subtree string) {
-		var pbentry proto.Entry
+	var walk func(parent uint64, name, path string, d *ltfs.Directory) error
+	walk = func(parent uint64, name, path string, d *ltfs.Directory) error {
+		var entry proto.Entry
 
 		// get the protobuf representation of the ltfs.Directory
-		if err := proto.MarshalDirectory(d, &pbentry); err != nil {
-			panic(err)
+		if err := proto.MarshalDirectory(d, &entry); err != nil {
+			return errors.Wrapf(err, "failed to marshal directory '%s'", path)
 		}
 
-		// compose path name (insert root, add subtree, the directory we are in and
-		// then directory we're inserting)
-		path := filepath.Join("/", subtree, d.Name)
-		path = path + "/"
+		if err := add(parent, name, path, &entry); err != nil {
+			return err
+		}
 
-		// marshal to bytes
-		buf, err := pb.Marshal(&pbentry)
-		if err != nil {
-			panic(err)
+		if d.Contents == nil {
+			return nil
 		}
 
-		collector <- &wrap{path, buf}
+		for _, f := range d.Contents.Files {
+			var entry proto.Entry
 
-		for _, file := range d.Contents.Files {
-			var pbentry proto.Entry
+			// compose path name (the directory we are in and then file name)
+			path := filepath.Join(path, f.Name)
 
 			// get the protobuf representation of the ltfs.File
-			if err := proto.MarshalFile(file, &pbentry); err != nil {
-				panic(err)
+			if err := proto.MarshalFile(f, &entry, pmap); err != nil {
+				return errors.Wrapf(err, "failed to marshal file '%s'", path)
 			}
 
-			// compose path name (insert root, add subtree and then file name)
-			path := filepath.Join("/", subtree, d.Name, file.Name)
-
-			// marshal to bytes
-			buf, err := pb.Marshal(&pbentry)
-			if err != nil {
-				panic(err)
+			if err := add(uint64(d.FileUID), f.Name, path, &entry); err != nil {
+				return err
 			}
+		}
 
-			collector <- &wrap{path, buf}
+		for _, sub := range d.Contents.Directories {
+			if err := walk(uint64(d.FileUID), sub.Name, filepath.Join(path, sub.Name), sub); err != nil {
+				return err
+			}
 		}
-	})
 
-	close(collector)
-	<-done
-	fmt.Printf("building protobufs from parsed LTFS index took: %v\n", time.Since(begin))
+		return nil
+	}
 
-	fmt.Printf("number of entries: %d\n", len(ws))
+	// the root directory is named by the empty name in directory 0
+	if err := walk(0, "", "/", idx.Root); err != nil {
+		return nil, err
+	}
 
-	// sort the index entries according to the filepath
-	begin = time.Now()
-	sort.Slice(ws, func(i, j int) bool {
-		return ws[i].path < ws[j].path
+	// sort the inodes and directory entries by key
+	sort.Slice(inodes, func(i, j int) bool {
+		return bytes.Compare(inodes[i].key, inodes[j].key) < 0
 	})
-	fmt.Printf("sorting entries took: %v\n", time.Since(begin))
+
+	sort.Slice(dirents, func(i, j int) bool {
+		return bytes.Compare(dirents[i].key, dirents[j].key) < 0
+	})
+
+	for i := 1; i < len(inodes); i++ {
+		if bytes.Equal(inodes[i-1].key, inodes[i].key) {
+			return nil, errors.Errorf("duplicate file UID %d", binary.BigEndian.Uint64(inodes[i].key))
+		}
+	}
+
+	// names that are equal under the name policy (or a file and a directory
+	// of the same name) collide
+	for i := 1; i < len(dirents); i++ {
+		if bytes.Equal(dirents[i-1].key, dirents[i].key) {
+			return nil, errors.Errorf("name collision at '%s'", dirents[i].path)
+		}
+	}
 
 	// insert into database
-	begin = time.Now()
-	err = db.Update(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket([]byte("index"))
-		if bkt == nil {
-			return errors.New("index bucket not found")
+	err := db.Update(func(tx engine.Tx) error {
+		t, err := newIndexTx(tx, binIdx.policy)
+		if err != nil {
+			return err
 		}
 
-		// We set the fill percentage to 100%. This ensures that Bolt doesn't split
-		// pages before the page is full. This is good when we only expect
-		// read-only on this index.
-		bkt.FillPercent = 1
+		// the inodes and dirents are inserted in key order
+		for _, b := range []engine.Bucket{t.inodes, t.dirents} {
+			if p, ok := b.(engine.Packer); ok {
+				p.Pack()
+			}
+		}
 
 		// insert
-		for _, w := range ws {
-			if err := bkt.Put([]byte(w.path), w.buf); err != nil {
+		for _, n := range inodes {
+			if err := t.inodes.Put(n.key, n.buf); err != nil {
 				return err
 			}
 		}
 
-		return nil
+		for _, d := range dirents {
+			if err := t.dirents.Put(d.key, uidKey(d.uid)); err != nil {
+				return err
+			}
+
+			if err := t.parents.Put(uidKey(d.uid), d.key[:8]); err != nil {
+				return err
+			}
+		}
+
+		for _, n := range inodes {
+			if err := t.index(n.entry); err != nil {
+				return err
+			}
+		}
+
+		if len(inodes) > 0 {
+			if err := t.reserveUID(binary.BigEndian.Uint64(inodes[len(inodes)-1].key)); err != nil {
+				return err
+			}
+		}
+
+		return t.reserveUID(uint64(idx.HighestFileUID))
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Printf("inserting to BoltDB took: %v\n", time.Since(begin))
+	if err := binIdx.loadUID(); err != nil {
+		return nil, err
+	}
 
 	return binIdx, nil
 }
 
+// indexBatchSize is the number of entries inserted per transaction when the
+// binary index is built from a stream.
+const indexBatchSize = 10000
+
+// NewIndexFromReader builds the binary index from the LTFS index read from r.
+// The LTFS index is decoded as a stream and inserted in batches, so memory use
+// does not grow with the size of the index. It returns the binary index and
+// the LTFS index without its directory tree (see ltfs.IndexDecoder).
+func NewIndexFromReader(r io.Reader, pmap ltfs.PartitionMap, db engine.Interface, opts ...IndexOption) (*index, *ltfs.Index, error) {
+	var iopts indexOptions
+	for _, opt := range opts {
+		opt(&iopts)
+	}
+
+	binIdx := &index{
+		db:     db,
+		pmap:   pmap,
+		policy: iopts.policy,
+	}
+
+	if err := initIndexDB(db, binIdx.policy); err != nil {
+		return nil, nil, err
+	}
+
+	type wrap struct {
+		parent uint64
+		name   string
+		path   string
+		entry  *proto.Entry
+	}
+
+	batch := make([]wrap, 0, indexBatchSize)
+
+	flush := func() error {
+		err := db.Update(func(tx engine.Tx) error {
+			t, err := newIndexTx(tx, binIdx.policy)
+			if err != nil {
+				return err
+			}
+
+			for _, w := range batch {
+				if t.inodes.Get(uidKey(w.entry.Id)) != nil {
+					return errors.Errorf("duplicate file UID %d at '%s'", w.entry.Id, w.path)
+				}
+
+				// names that are equal under the name policy (or a file
+				// and a directory of the same name) collide
+				if _, ok := t.child(w.parent, w.name); ok {
+					return errors.Errorf("name collision at '%s'", w.path)
+				}
+
+				if err := t.put(w.entry); err != nil {
+					return err
+				}
+
+				if err := t.link(w.parent, w.name, w.entry.Id); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		batch = batch[:0]
+
+		return err
+	}
+
+	add := func(parent uint64, name, path string, entry *proto.Entry) error {
+		batch = append(batch, wrap{parent, name, path, entry})
+		if len(batch) == indexBatchSize {
+			return flush()
+		}
+
+		return nil
+	}
+
+	// the directories on the path to the current entry
+	type dir struct {
+		path string
+		uid  uint64
+	}
+
+	var dirs []dir
+
+	parent := func(path string) (uint64, error) {
+		p := filepath.Dir(path)
+		for len(dirs) > 0 && dirs[len(dirs)-1].path != p {
+			dirs = dirs[:len(dirs)-1]
+		}
+
+		if len(dirs) == 0 {
+			return 0, errors.Errorf("entry '%s' has no parent directory", path)
+		}
+
+		return dirs[len(dirs)-1].uid, nil
+	}
+
+	root := true
+
+	dec := ltfs.IndexDecoder{
+		Directory: func(path string, d *ltfs.Directory) error {
+			var entry proto.Entry
+			if err := proto.MarshalDirectory(d, &entry); err != nil {
+				return errors.Wrapf(err, "failed to marshal directory '%s'", path)
+			}
+
+			// the root directory is named by the empty name in directory 0
+			var uid uint64
+			name := ""
+
+			if root {
+				root = false
+			} else {
+				var err error
+				if uid, err = parent(path); err != nil {
+					return err
+				}
+
+				name = d.Name
+			}
+
+			dirs = append(dirs, dir{path, entry.Id})
+
+			return add(uid, name, path, &entry)
+		},
+
+		File: func(path string, f *ltfs.File) error {
+			var entry proto.Entry
+			if err := proto.MarshalFile(f, &entry, pmap); err != nil {
+				return errors.Wrapf(err, "failed to marshal file '%s'", path)
+			}
+
+			uid, err := parent(path)
+			if err != nil {
+				return err
+			}
+
+			return add(uid, f.Name, path, &entry)
+		},
+	}
+
+	idx, err := dec.Decode(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := flush(); err != nil {
+		return nil, nil, err
+	}
+
+	binIdx.setPreface(&idx.IndexPreface)
+
+	if err := binIdx.reserveUID(uint64(idx.HighestFileUID)); err != nil {
+		return nil, nil, err
+	}
+
+	if err := binIdx.loadUID(); err != nil {
+		return nil, nil, err
+	}
+
+	return binIdx, idx, nil
+}
+
+// Close closes the database backing the index.
+func (idx *index) Close() error {
+	return idx.db.Close()
+}
+
 func (idx *index) MakeLTFSIndex() (*ltfs.Index, error) {
-	tree, err := idx.root.MakeTree()
+	root, err := idx.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := root.MakeTree(idx.pmap)
 	if err != nil {
 		return nil, err
 	}
 
 	ltfsIndex := ltfs.Index{
-		XMLName: xml.Name{Space: "", Local: "ltfsindex"},
-		IndexPreface: ltfs.IndexPreface{
-			Version:    ltfs.Version,
-			Creator:    ltfs.Creator,
-			VolumeUUID: idx.meta.uuid,
-			Generation: idx.meta.gen,
-			UpdateTime: xmlutil.TimeNow(),
-			Partition:  "a", // TODO(kbj): DO NOT HARD CODE THIS
-			StartBlock: 6,
-			PreviousGeneration: ltfs.PreviousGeneration{
-				Partition:  "b",
-				StartBlock: 20,
-			},
-			HighestFileUID: 4,
-		},
+		XMLName:      xml.Name{Space: "", Local: "ltfsindex"},
+		IndexPreface: idx.preface(),
 
 		Root: tree,
 	}
@@ -209,171 +472,335 @@ func (idx *index) MakeLTFSIndex() (*ltfs.Index, error) {
 	return &ltfsIndex, nil
 }
 
-// Insert inserts a *pb.Entry into the binary index.
-func (idx *index) Insert(path string, entry *proto.Entry) error {
-	buf, err := pb.Marshal(entry)
+// setPreface records the generation and location of the LTFS index the
+// binary index matches.
+func (idx *index) setPreface(p *ltfs.IndexPreface) {
+	idx.meta.uuid = p.VolumeUUID
+	idx.meta.gen = p.Generation
+	idx.meta.part = p.Partition
+	idx.meta.block = p.StartBlock
+	idx.meta.prev = p.PreviousGeneration
+}
+
+// preface returns the LTFS index preface describing the binary index. It
+// names the generation and location of the index the binary index was built
+// from or last written as (see Store.writeTapeIndex).
+func (idx *index) preface() ltfs.IndexPreface {
+	return ltfs.IndexPreface{
+		Version:            ltfs.Version,
+		Creator:            ltfs.Creator,
+		VolumeUUID:         idx.meta.uuid,
+		Generation:         idx.meta.gen,
+		UpdateTime:         xmlutil.TimeNow(),
+		Partition:          idx.meta.part,
+		StartBlock:         idx.meta.block,
+		PreviousGeneration: idx.meta.prev,
+		HighestFileUID:     int(atomic.LoadUint64(&idx.lastUID)),
+	}
+}
+
+// encodeLTFSIndex writes the binary index as an LTFS index with the given
+// preface to w. The directory tree is walked in a single read transaction and
+// streamed to w one entry at a time, so memory use does not grow with the
+// number of entries.
+func (idx *index) encodeLTFSIndex(w io.Writer, preface ltfs.IndexPreface) ([]ltfs.Feature, error) {
+	var dropped []ltfs.Feature
+
+	err := idx.view(func(t *indexTx) error {
+		root, err := t.root()
+		if err != nil {
+			return err
+		}
+
+		dropped, err = ltfs.EncodeIndex(w, preface, func(enc *ltfs.IndexEncoder) error {
+			enter := func(entry *proto.Entry) error {
+				if entry.GetDir() != nil {
+					d, err := entry.MakeTree(idx.pmap)
+					if err != nil {
+						return err
+					}
+
+					return enc.StartDirectory(d)
+				}
+
+				f, err := entry.MakeFile(idx.pmap)
+				if err != nil {
+					return errors.Wrapf(err, "invalid entry %d", entry.Id)
+				}
+
+				return enc.File(f)
+			}
+
+			leave := func(*proto.Entry) error {
+				return enc.EndDirectory()
+			}
+
+			return t.walk(root, enter, leave)
+		})
+
+		return err
+	})
+
 	if err != nil {
-		return errors.Wrap(err, "failed to marshal entry")
+		return nil, err
 	}
 
-	return idx.db.Update(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket([]byte("index"))
-		if bkt == nil {
-			return errors.New("index bucket not found")
+	return dropped, nil
+}
+
+// Insert inserts a *pb.Entry into the binary index at path, replacing any
+// entry at path.
+func (idx *index) Insert(path string, entry *proto.Entry) error {
+	path = cleanPath(path)
+
+	return idx.write(func(t *indexTx) error {
+		// the root directory is named by the empty name in directory 0
+		var parent uint64
+		name := ""
+
+		if path != "/" {
+			var err error
+			if parent, _, err = t.lookup(filepath.Dir(path)); err != nil {
+				return err
+			}
+
+			name = filepath.Base(path)
+		}
+
+		if uid, ok := t.child(parent, name); ok && uid != entry.Id {
+			if err := t.del(uid); err != nil {
+				return err
+			}
 		}
 
-		if err := bkt.Put([]byte(path), buf); err != nil {
-			return errors.Wrap(err, "failed to insert entry")
+		if err := t.put(entry); err != nil {
+			return err
+		}
+
+		return t.link(parent, name, entry.Id)
+	})
+}
+
+// write runs fn in a read-write transaction and marks the index modified.
+func (idx *index) write(fn func(t *indexTx) error) error {
+	atomic.StoreInt32(&idx.modified, 1)
+
+	return idx.commit(fn)
+}
+
+// commit runs fn in a read-write transaction.
+func (idx *index) commit(fn func(t *indexTx) error) error {
+	return idx.db.Update(func(tx engine.Tx) error {
+		t, err := newIndexTx(tx, idx.policy)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(t); err != nil {
+			return err
+		}
+
+		// read-write transactions are serialized, so the highest UID
+		// only grows
+		if t.uid != 0 {
+			atomic.StoreUint64(&idx.lastUID, t.uid)
 		}
 
 		return nil
 	})
 }
 
-// Stat returns the entry at path.
-func (idx *index) stat(path string) (*proto.Entry, error) {
-	var entry proto.Entry
+// reserveUID marks all file UIDs up to uid as allocated. This does not
+// modify the index.
+func (idx *index) reserveUID(uid uint64) error {
+	return idx.commit(func(t *indexTx) error {
+		return t.reserveUID(uid)
+	})
+}
+
+// loadUID loads the highest allocated file UID from the database.
+func (idx *index) loadUID() error {
+	return idx.view(func(t *indexTx) error {
+		atomic.StoreUint64(&idx.lastUID, t.lastUID())
+		return nil
+	})
+}
 
-	err := idx.db.View(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket([]byte("index"))
-		if bkt == nil {
-			return errors.New("index bucket not found")
+// create inserts a new entry at path. The parent directory of path must exist
+// and not be read-only, and path itself must not exist. If the entry has no
+// file UID, a new one is allocated.
+func (idx *index) create(path string, entry *proto.Entry) error {
+	path = cleanPath(path)
+
+	return idx.write(func(t *indexTx) error {
+		if path == "/" {
+			return os.ErrExist
+		}
+
+		uid, parent, err := t.lookup(filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+
+		if parent.GetDir() == nil {
+			return syscall.ENOTDIR
 		}
 
-		if v := bkt.Get([]byte(path)); v != nil {
-			if err := pb.Unmarshal(v, &entry); err != nil {
-				return errors.Wrap(err, "failed to unmarshal entry")
+		if parent.Readonly {
+			return syscall.EPERM
+		}
+
+		name := filepath.Base(path)
+		if _, ok := t.child(uid, name); ok {
+			return os.ErrExist
+		}
+
+		if entry.Id == 0 {
+			if entry.Id, err = t.allocUID(); err != nil {
+				return err
 			}
+		} else if t.inodes.Get(uidKey(entry.Id)) != nil {
+			return errors.Errorf("file UID %d is in use", entry.Id)
+		}
+
+		if err := t.put(entry); err != nil {
+			return err
 		}
 
-		return errors.New("path not found")
+		return t.link(uid, name, entry.Id)
+	})
+}
+
+// Stat returns the entry at path.
+func (idx *index) stat(path string) (*proto.Entry, error) {
+	var entry *proto.Entry
+
+	err := idx.view(func(t *indexTx) error {
+		var err error
+		_, entry, err = t.lookup(cleanPath(path))
+
+		return err
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &entry, nil
+	return entry, nil
 }
 
-func (idx *index) Marshal() (*proto.Entry, error) {
-	// TODO(kbj): this function is a bit hairy.
+// update looks up the entry at path and calls fn on it. If fn returns without
+// error, the (modified) entry is written back to the index.
+func (idx *index) update(path string, fn func(*proto.Entry) error) error {
+	return idx.write(func(t *indexTx) error {
+		uid, entry, err := t.lookup(cleanPath(path))
+		if err != nil {
+			return err
+		}
 
-	type chainT struct {
-		path []byte
-		dir  *proto.Directory
-		prev *chainT
-	}
+		if err := fn(entry); err != nil {
+			return err
+		}
 
-	base := &proto.Directory{
-		Entries: make([]*proto.Entry, 0),
-	}
+		// the entry stays in its inode
+		entry.Id = uid
 
-	path := []byte("/")
+		return t.put(entry)
+	})
+}
 
-	chain := &chainT{
-		path: path,
-		dir:  base,
-	}
+// Marshal returns the root directory with all entries below it.
+func (idx *index) Marshal() (*proto.Entry, error) {
+	var root *proto.Entry
 
-	err := idx.db.View(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket([]byte("index"))
-		if bkt == nil {
-			return errors.New("index bucket not found")
+	err := idx.view(func(t *indexTx) error {
+		uid, err := t.root()
+		if err != nil {
+			return err
 		}
 
-		// iterate over all pairs in the bucket (in-order)
-		err := bkt.ForEach(func(k, v []byte) error {
-			var entry proto.Entry
-			if err := pb.Unmarshal(v, &entry); err != nil {
-				return errors.Wrap(err, "failed to unmarshal buffer")
-			}
+		// the directories on the path to the current entry
+		var chain []*proto.Directory
 
-			// if we see a new prefix, move back up the chain
-			for !bytes.HasPrefix(k, chain.path) {
-				chain = chain.prev
+		enter := func(entry *proto.Entry) error {
+			if len(chain) == 0 {
+				root = entry
+			} else {
+				dir := chain[len(chain)-1]
+				dir.Entries = append(dir.Entries, entry)
 			}
 
-			if chain.dir.Entries == nil {
-				// initialize the entries
-				chain.dir.Entries = make([]*proto.Entry, 0)
+			if x, ok := entry.Elem.(*proto.Entry_Dir); ok {
+				x.Dir.Entries = make([]*proto.Entry, 0)
+				chain = append(chain, x.Dir)
 			}
 
-			// if the entry is a directory, add it to the chain
-			switch x := entry.Elem.(type) {
-			case *proto.Entry_Dir:
-				// add the directory entry before updating the chain
-				chain.dir.Entries = append(chain.dir.Entries, &entry)
-
-				// update the path chain
-				new := &chainT{
-					path: k,
-					dir:  x.Dir,
-					prev: chain,
-				}
+			return nil
+		}
 
-				chain = new
+		leave := func(*proto.Entry) error {
+			chain = chain[:len(chain)-1]
+			return nil
+		}
 
-			case *proto.Entry_File:
-				chain.dir.Entries = append(chain.dir.Entries, &entry)
-			}
+		return t.walk(uid, enter, leave)
+	})
 
-			return nil
-		})
+	if err != nil {
+		return nil, err
+	}
+
+	return root, nil
+}
 
+// Scan returns the entry at path and, if it is a directory, all entries below
+// it, depth first.
+func (idx *index) Scan(path string) ([]*proto.Entry, error) {
+	var entries []*proto.Entry
+
+	err := idx.view(func(t *indexTx) error {
+		uid, _, err := t.lookup(cleanPath(path))
 		if err != nil {
 			return err
 		}
 
-		return nil
+		return t.walk(uid, func(entry *proto.Entry) error {
+			entries = append(entries, entry)
+			return nil
+		}, nil)
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	return base.Entries[0], nil
-}
-
-func (idx *index) Scan(path string) ([]*proto.Entry, error) {
-	return idx.scan(path, true)
+	return entries, nil
 }
 
+// List returns the entries of the directory at path.
 func (idx *index) List(path string) ([]*proto.Entry, error) {
-	return idx.scan(path, false)
-}
-
-func (idx *index) scan(path string, resursive bool) ([]*proto.Entry, error) {
 	var entries []*proto.Entry
 
-	err := idx.db.View(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket([]byte("index"))
-		if bkt == nil {
-			return errors.New("index bucket not found")
+	err := idx.view(func(t *indexTx) error {
+		uid, dir, err := t.lookup(cleanPath(path))
+		if err != nil {
+			return err
 		}
 
-		c := bkt.Cursor()
+		if dir.GetDir() == nil {
+			return syscall.ENOTDIR
+		}
 
-		prefix := []byte(path)
-		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
-			if !resursive {
-				k = k[len(prefix):]
-				if bytes.IndexByte(k, '/') != -1 {
-					continue
-				}
+		return t.children(uid, func(child uint64) error {
+			entry, err := t.get(child)
+			if err != nil {
+				return errors.Wrapf(err, "missing entry %d", child)
 			}
 
-			var entry proto.Entry
+			entries = append(entries, entry)
 
-			// unmarshal the entry
-			if err := pb.Unmarshal(v, &entry); err != nil {
-				return errors.Wrap(err, "failed to unmarshal entry")
-			}
-
-			entries = append(entries, &entry)
-		}
-
-		return nil
+			return nil
+		})
 	})
 
 	if err != nil {
@@ -389,21 +816,63 @@ func (es extentList) last() *proto.Extent {
 	return es[len(es)-1]
 }
 
-type extents map[uint64]extentList
+// extents holds the extents written by open files, by file UID.
+type extents struct {
+	sync.Mutex
+	m map[uint64]extentList
+}
 
-func (e extents) last(f *File) *proto.Extent {
-	if es, ok := e[f.id]; ok {
+func (e *extents) last(f *File) *proto.Extent {
+	if es, ok := e.m[f.id]; ok && len(es) > 0 {
 		return es.last()
 	}
 
 	return nil
 }
 
+// addExtent records the extent e written by f. An extent continuing the last
+// extent of the file, both in the file and on the partition, is merged into
+// it.
 func (idx *index) addExtent(f *File, e *proto.Extent) {
-	prev := idx.extents.last(f)
-	if prev.Block+prev.Length/idx.blkSize == e.Block {
+	idx.extents.Lock()
+	defer idx.extents.Unlock()
 
+	if prev := idx.extents.last(f); prev != nil && idx.contiguous(prev, e) {
+		prev.Length += e.Length
+		return
 	}
+
+	if idx.extents.m == nil {
+		idx.extents.m = make(map[uint64]extentList)
+	}
+
+	idx.extents.m[f.id] = append(idx.extents.m[f.id], e)
+}
+
+// contiguous reports whether the extent e starts where prev ends. Records are
+// block sized except the last one written before a flush, so an extent ending
+// on a block boundary is continued by an extent at the start of the next
+// block; an extent ending mid-record is continued only at the same byte
+// offset in the same record.
+func (idx *index) contiguous(prev, e *proto.Extent) bool {
+	if prev.Partition != e.Partition || prev.Offset+prev.Length != e.Offset {
+		return false
+	}
+
+	end := prev.Block*idx.blkSize + prev.Boffset + prev.Length
+
+	return end == e.Block*idx.blkSize+e.Boffset
+}
+
+// takeExtents returns and forgets the extents written by f.
+func (idx *index) takeExtents(f *File) []*proto.Extent {
+	idx.extents.Lock()
+	defer idx.extents.Unlock()
+
+	es := idx.extents.m[f.id]
+	delete(idx.extents.m, f.id)
+
+	return es
 }
 
 type indexWriter struct {
@@ -428,29 +897,238 @@ func (w *indexWriter) start(ctx context.Context, pol *RecoveryPolicy) {
 }
 
 // ReadLTFSIndex reads the LTFS index from the device and returns a ltfs.Index
-// representation.
+// representation. The whole directory tree is held in memory, so memory use
+// grows with the size of the index; the store itself only reads indexes this
+// way to compare two generations (see DiffGenerations) and streams them
+// otherwise (see NewIndexFromReader).
 func (b *Store) ReadLTFSIndex() (*ltfs.Index, error) {
+	if err := b.locateLTFSIndex(); err != nil {
+		return nil, err
+	}
+
+	idx, _, err := b.readLTFSIndex()
+
+	return idx, err
+}
+
+// locateLTFSIndex positions the device at the latest index on the index
+// partition.
+func (b *Store) locateLTFSIndex() error {
 	// seek to EOD
-	if err := b.mu.backend.Locate(0, TapeBlockMax); err != nil {
-		return nil, errors.Wrap(err, "failed to seek to EOD")
+	if err := b.mu.backend.Locate(ltfs.IndexPartition, TapeBlockMax); err != nil {
+		return errors.Wrap(err, "failed to seek to EOD")
 	}
 
 	// space backwards to find the LTFS index
 	if err := b.mu.backend.SpaceFMB(2); err != nil {
-		return nil, errors.Wrap(err, "failed to space backward")
+		return errors.Wrap(err, "failed to space backward")
+	}
+
+	return nil
+}
+
+// readLTFSIndexAt reads the LTFS index starting at the given block, including
+// its directory tree (see ReadLTFSIndex). It returns the index and the block
+// following the filemark that terminates it.
+func (b *Store) readLTFSIndexAt(part uint32, block uint64) (*ltfs.Index, uint64, error) {
+	if err := b.mu.backend.Locate(part, block); err != nil {
+		return nil, 0, errors.Wrap(err, "failed to locate index")
+	}
+
+	return b.readLTFSIndex()
+}
+
+// decodeLTFSIndexAt decodes the LTFS index starting at the given block with
+// dec, so the directory tree is never held in memory (see ltfs.IndexDecoder).
+// It returns the index without its directory tree and the block following
+// the filemark that terminates it.
+func (b *Store) decodeLTFSIndexAt(part uint32, block uint64, dec *ltfs.IndexDecoder) (*ltfs.Index, uint64, error) {
+	if err := b.mu.backend.Locate(part, block); err != nil {
+		return nil, 0, errors.Wrap(err, "failed to locate index")
 	}
 
-	buf, err := b.ReadFile()
+	rr := b.newRecordReader()
+
+	idx, err := dec.Decode(rr)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to read file")
+		return nil, 0, err
+	}
+
+	// skip the rest of the file up to the filemark
+	if _, err := io.Copy(ioutil.Discard, rr); err != nil {
+		return nil, 0, errors.Wrap(err, "failed to read file")
 	}
 
+	end, err := b.mu.backend.ReadPosition()
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to read position")
+	}
+
+	if _, err := ltfs.ParseVersion(idx.Version); err != nil {
+		return nil, 0, err
+	}
+
+	return idx, end, nil
+}
+
+// readLTFSIndex reads the LTFS index at the current position, including its
+// directory tree (see ReadLTFSIndex). The index is decoded as its records are
+// read, so the XML is not held in memory as well.
+func (b *Store) readLTFSIndex() (*ltfs.Index, uint64, error) {
+	rr := b.newRecordReader()
+
 	var idx ltfs.Index
+	if err := xml.NewDecoder(rr).Decode(&idx); err != nil {
+		return nil, 0, err
+	}
 
-	// unmarshal the LTFS index
-	if err := xml.Unmarshal(buf, &idx); err != nil {
-		return nil, err
+	// skip the rest of the file up to the filemark
+	if _, err := io.Copy(ioutil.Discard, rr); err != nil {
+		return nil, 0, errors.Wrap(err, "failed to read file")
+	}
+
+	end, err := b.mu.backend.ReadPosition()
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to read position")
+	}
+
+	if _, err := ltfs.ParseVersion(idx.Version); err != nil {
+		return nil, 0, err
+	}
+
+	return &idx, end, nil
+}
+
+// LTFSIndex returns the current LTFS index in the format version selected
+// with WithIndexVersion. Features that are not available in that version are
+// left out of the index and returned in dropped.
+func (b *Store) LTFSIndex() (idx *ltfs.Index, dropped []ltfs.Feature, err error) {
+	idx, err = b.idx.MakeLTFSIndex()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	idx.IndexPreface = b.ltfsPreface()
+
+	dropped, err = idx.ConvertVersion(b.sopts.version)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return idx, dropped, nil
+}
+
+// ltfsPreface returns the preface of the current LTFS index in the format
+// version selected with WithIndexVersion.
+func (b *Store) ltfsPreface() ltfs.IndexPreface {
+	preface := b.idx.preface()
+
+	preface.Version = b.sopts.version
+	preface.VolumeLockState = b.volumeLockState()
+	preface.AllowPolicyUpdate = b.placement.allowUpdate
+	preface.DataPlacementPolicy = b.DataPlacementPolicy()
+
+	return preface
+}
+
+// WriteLTFSIndex writes the current LTFS index as XML to w in the format
+// version selected with WithIndexVersion. Features that are not available in
+// that version are left out and returned. The index is streamed from the
+// binary index (see encodeLTFSIndex).
+func (b *Store) WriteLTFSIndex(w io.Writer) ([]ltfs.Feature, error) {
+	return b.idx.encodeLTFSIndex(w, b.ltfsPreface())
+}
+
+// writeTapeIndex writes the binary index idx as an LTFS index with the given
+// preface to the partition at the given block, terminated by a filemark; a
+// filemark is written first unless the block follows one. The preface is
+// located at the block the index starts at. The index is streamed from the
+// binary index in block sized records (see encodeLTFSIndex). It returns the
+// preface written, the block following the index and the features left out
+// of it (see WriteLTFSIndex). The caller must hold the device.
+func (s *Store) writeTapeIndex(idx *index, part uint32, block uint64, preface ltfs.IndexPreface) (ltfs.IndexPreface, uint64, []ltfs.Feature, error) {
+	dev := s.mu.backend
+
+	if err := dev.Locate(part, block); err != nil {
+		return preface, 0, nil, errors.Wrap(err, "failed to locate index")
+	}
+
+	// make sure the index is preceded by a filemark
+	fm, err := s.afterFilemark(part, block)
+	if err != nil {
+		return preface, 0, nil, err
+	}
+
+	if !fm {
+		if err := dev.WriteFilemark(1); err != nil {
+			return preface, 0, nil, err
+		}
+
+		block++
+	}
+
+	id, err := idx.pmap.ID(part)
+	if err != nil {
+		return preface, 0, nil, err
+	}
+
+	preface.Partition = id
+	preface.StartBlock = int(block)
+
+	if err := validateLTFSIndex(idx, preface); err != nil {
+		return preface, 0, nil, err
+	}
+
+	rw := s.newRecordWriter()
+
+	dropped, err := idx.encodeLTFSIndex(rw, preface)
+	if err != nil {
+		return preface, 0, nil, errors.Wrap(err, "failed to write index")
+	}
+
+	if err := rw.Flush(); err != nil {
+		return preface, 0, nil, errors.Wrap(err, "failed to write index")
+	}
+
+	if err := dev.WriteFilemark(1); err != nil {
+		return preface, 0, nil, err
+	}
+
+	end, err := dev.ReadPosition()
+	if err != nil {
+		return preface, 0, nil, errors.Wrap(err, "failed to read position")
+	}
+
+	return preface, end, dropped, nil
+}
+
+// validateLTFSIndex checks the LTFS index encoded from idx with the given
+// preface against the format rules before it is written. The index is
+// validated as it is encoded, without holding it in memory.
+func validateLTFSIndex(idx *index, preface ltfs.IndexPreface) error {
+	pr, pw := io.Pipe()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		_, err := idx.encodeLTFSIndex(pw, preface)
+		pw.CloseWithError(err)
+	}()
+
+	vs, err := ltfs.ValidateStream(pr)
+
+	// stop the encoder if the validation ended early
+	pr.Close()
+	<-done
+
+	if err != nil {
+		return errors.Wrap(err, "failed to validate index")
 	}
 
-	return &idx, nil
+	if len(vs) > 0 {
+		return errors.Errorf("refusing to write invalid index: %s", vs[0])
+	}
+
+	return nil
 }
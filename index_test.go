@@ -0,0 +1,203 @@
+package bltfs_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+
+	pb "github.com/golang/protobuf/proto"
+
+	"hpt.space/bltfs"
+	"hpt.space/bltfs/engine"
+	"hpt.space/bltfs/engine/memory"
+	"hpt.space/bltfs/ltfs"
+)
+
+func openTestDB(t *testing.T) (engine.Interface, func()) {
+	db := memory.New()
+
+	return db, func() {
+		db.Close()
+	}
+}
+
+func TestIndexFromReader(t *testing.T) {
+	idx := makeTestIndex()
+
+	buf, err := xml.Marshal(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db0, done0 := openTestDB(t)
+	defer done0()
+
+	db1, done1 := openTestDB(t)
+	defer done1()
+
+	binIdx0, err := bltfs.NewIndex(idx, ltfs.DefaultPartitionMap, db0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binIdx1, preface, err := bltfs.NewIndexFromReader(bytes.NewReader(buf), ltfs.DefaultPartitionMap, db1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if preface.Generation != idx.Generation || preface.Root.Name != idx.Root.Name {
+		t.Errorf("unexpected preface %+v", preface.IndexPreface)
+	}
+
+	entries0, err := binIdx0.Scan("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries1, err := binIdx1.Scan("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries0) != len(entries1) {
+		t.Fatalf("expected %d entries, got %d", len(entries0), len(entries1))
+	}
+
+	for i := range entries0 {
+		if !pb.Equal(entries0[i], entries1[i]) {
+			t.Errorf("entry %d differs: %v != %v", i, entries0[i], entries1[i])
+		}
+	}
+}
+
+func TestIndexFromReaderCollision(t *testing.T) {
+	idx := makeTestIndex()
+
+	// a file with the same name as a directory
+	idx.Root.Contents.Files = append(idx.Root.Contents.Files, makeTestFile(5, "dir", 1, 6))
+
+	buf, err := xml.Marshal(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, done := openTestDB(t)
+	defer done()
+
+	if _, _, err := bltfs.NewIndexFromReader(bytes.NewReader(buf), ltfs.DefaultPartitionMap, db); err == nil {
+		t.Error("expected a name collision")
+	}
+}
+
+// indexPaths returns the paths of all entries of idx, directories with a
+// trailing slash.
+func indexPaths(idx *ltfs.Index) []string {
+	var paths []string
+
+	var walk func(d *ltfs.Directory, path string)
+	walk = func(d *ltfs.Directory, path string) {
+		paths = append(paths, path)
+
+		if d.Contents == nil {
+			return
+		}
+
+		for _, f := range d.Contents.Files {
+			paths = append(paths, path+f.Name)
+		}
+
+		for _, dir := range d.Contents.Directories {
+			walk(dir, path+dir.Name+"/")
+		}
+	}
+
+	walk(idx.Root, "/")
+	sort.Strings(paths)
+
+	return paths
+}
+
+func TestWriteLTFSIndexStream(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	// names sorting around the directory separator
+	for _, name := range []string{"/dir/sub", "/dir/sub/deep", "/dir-1", "/dir0", "/dir/sub-1"} {
+		if err := store.Mkdir(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.Symlink("../file", "/dir/sub/deep/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _, err := store.LTFSIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := store.WriteLTFSIndex(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var idx ltfs.Index
+	if err := xml.Unmarshal(buf.Bytes(), &idx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := indexPaths(&idx), indexPaths(expected); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected entries %v, got %v", want, got)
+	}
+}
+
+func TestIndexEngine(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "bltfstest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(tmp)
+
+	for _, opt := range []bltfs.StoreOption{
+		bltfs.WithIndexEngine(bltfs.MemoryEngine, ""),
+		bltfs.WithIndexEngine(bltfs.BoltEngine, tmp),
+	} {
+		store, dir := openTestStore(t, makeTestIndex(), opt)
+
+		if _, err := store.Stat("/dir/file"); err != nil {
+			t.Error(err)
+		}
+
+		if err := store.Mkdir("/dir/sub"); err != nil {
+			t.Error(err)
+		}
+
+		if err := store.Close(); err != nil {
+			t.Error(err)
+		}
+
+		cleanup(dir)
+	}
+
+	// the temporary database is removed on close
+	if names, _ := ioutil.ReadDir(tmp); len(names) != 0 {
+		t.Errorf("expected empty index directory, found %d entries", len(names))
+	}
+
+	dir := makeTestTape(t, makeTestIndex())
+	defer cleanup(dir)
+
+	_, err = bltfs.Open(openTestDevice(t, dir),
+		bltfs.WithIndexEngine(bltfs.MemoryEngine, ""),
+		bltfs.WithIndexCache(tmp),
+	)
+
+	if err == nil {
+		t.Error("expected the index cache to require the bolt engine")
+	}
+}
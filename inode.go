@@ -0,0 +1,664 @@
+package bltfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strings"
+
+	pb "github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+
+	"hpt.space/bltfs/engine"
+	"hpt.space/bltfs/ltfs"
+	"hpt.space/bltfs/proto"
+)
+
+// The binary index is stored in two buckets. The inodes bucket maps file UIDs
+// to entries. The dirents bucket maps a parent directory UID and the key of a
+// name (see NamePolicy) to the UID of the named entry; the root directory is
+// named by the empty name in the (non-existing) directory with UID 0. Entries
+// keep their original name, so only the dirent keys are normalized.
+//
+// Three secondary buckets are kept in sync with the inodes: parents maps a
+// UID to the UID of its directory, from which the path of an entry is
+// derived (see indexTx.path); blocks holds a key for every extent, made of the
+// partition, start block, UID and file offset of the extent; and mtimes holds
+// a key for every entry, made of its modification time and UID.
+//
+// The meta bucket holds the highest file UID ever allocated (lastuid), which
+// is persisted with every entry so that UIDs are never reused, even if the
+// LTFS index on the volume lags behind, and the statistics of the entries
+// (stats, see indexStats), which are kept in sync with the inodes. It also
+// holds the bytes on the data partition no longer referenced by any entry
+// (unreferenced, see Store.UnreferencedBytes).
+//
+// Only a cached binary index (see WithIndexCache) outlives the mount. After a
+// crash, the highest file UID is otherwise recovered from the logs on the
+// data partition, in which UIDs are reserved before they are handed out (see
+// Store.reserveUID), and is written with the next index (see Store.Sync).
+//
+// Earlier versions kept entries in a single index bucket keyed by normalized
+// path, with directories carrying a trailing slash. Such databases are
+// migrated when opened (see migrateIndexDB).
+var (
+	inodesBucket  = []byte("inodes")
+	direntsBucket = []byte("dirents")
+
+	parentsBucket = []byte("parents")
+	blocksBucket  = []byte("blocks")
+	mtimesBucket  = []byte("mtimes")
+
+	metaBucket      = []byte("meta")
+	lastUIDKey      = []byte("lastuid")
+	statsKey        = []byte("stats")
+	unreferencedKey = []byte("unreferenced")
+
+	// the path keyed layout
+	legacyBucket = []byte("index")
+)
+
+// indexBuckets are the buckets of the binary index.
+var indexBuckets = [][]byte{inodesBucket, direntsBucket, parentsBucket, blocksBucket, mtimesBucket, metaBucket}
+
+// uidKey returns the key of the file UID uid.
+func uidKey(uid uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uid)
+
+	return k
+}
+
+// direntKey returns the key of the entry with the given name key in the
+// directory with the given UID.
+func direntKey(parent uint64, name string) []byte {
+	return append(uidKey(parent), name...)
+}
+
+// blockKey returns the blocks key of the extent ex of the entry with the
+// given UID.
+func blockKey(uid uint64, ex *proto.Extent) []byte {
+	k := make([]byte, 28)
+	binary.BigEndian.PutUint32(k, ex.Partition)
+	binary.BigEndian.PutUint64(k[4:], ex.Block)
+	binary.BigEndian.PutUint64(k[12:], uid)
+	binary.BigEndian.PutUint64(k[20:], ex.Offset)
+
+	return k
+}
+
+// timeKey returns the key of the time t (in nanoseconds since the epoch); the
+// sign bit is flipped so that keys sort in time order.
+func timeKey(t int64) []byte {
+	return uidKey(uint64(t) ^ 1<<63)
+}
+
+// mtimeKey returns the mtimes key of the entry.
+func mtimeKey(e *proto.Entry) []byte {
+	return append(timeKey(e.ModifyTime), uidKey(e.Id)...)
+}
+
+// indexTx is a transaction on the binary index.
+type indexTx struct {
+	inodes  engine.Bucket
+	dirents engine.Bucket
+	parents engine.Bucket
+	blocks  engine.Bucket
+	mtimes  engine.Bucket
+	meta    engine.Bucket
+	policy  NamePolicy
+
+	// the highest file UID allocated in the transaction
+	uid uint64
+}
+
+func newIndexTx(tx engine.Tx, policy NamePolicy) (*indexTx, error) {
+	t := &indexTx{
+		inodes:  tx.Bucket(inodesBucket),
+		dirents: tx.Bucket(direntsBucket),
+		parents: tx.Bucket(parentsBucket),
+		blocks:  tx.Bucket(blocksBucket),
+		mtimes:  tx.Bucket(mtimesBucket),
+		meta:    tx.Bucket(metaBucket),
+		policy:  policy,
+	}
+
+	if t.inodes == nil || t.dirents == nil || t.parents == nil || t.blocks == nil || t.mtimes == nil || t.meta == nil {
+		return nil, errors.New("index buckets not found")
+	}
+
+	return t, nil
+}
+
+// view runs fn in a read-only transaction on the index.
+func (idx *index) view(fn func(t *indexTx) error) error {
+	return idx.db.View(func(tx engine.Tx) error {
+		t, err := newIndexTx(tx, idx.policy)
+		if err != nil {
+			return err
+		}
+
+		return fn(t)
+	})
+}
+
+// get returns the entry with the given UID.
+func (t *indexTx) get(uid uint64) (*proto.Entry, error) {
+	v := t.inodes.Get(uidKey(uid))
+	if v == nil {
+		return nil, os.ErrNotExist
+	}
+
+	var entry proto.Entry
+	if err := pb.Unmarshal(v, &entry); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal entry")
+	}
+
+	return &entry, nil
+}
+
+// lastUID returns the highest file UID allocated.
+func (t *indexTx) lastUID() uint64 {
+	v := t.meta.Get(lastUIDKey)
+	if v == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(v)
+}
+
+// reserveUID marks all file UIDs up to uid as allocated.
+func (t *indexTx) reserveUID(uid uint64) error {
+	if uid <= t.lastUID() {
+		return nil
+	}
+
+	if err := t.meta.Put(lastUIDKey, uidKey(uid)); err != nil {
+		return errors.Wrap(err, "failed to reserve file UID")
+	}
+
+	t.uid = uid
+
+	return nil
+}
+
+// allocUID allocates a new file UID. The UID is persisted with the
+// transaction.
+func (t *indexTx) allocUID() (uint64, error) {
+	uid := t.lastUID() + 1
+
+	if err := t.reserveUID(uid); err != nil {
+		return 0, err
+	}
+
+	return uid, nil
+}
+
+// put writes the entry to the inode table, replacing the entry with the same
+// UID.
+func (t *indexTx) put(entry *proto.Entry) error {
+	if err := t.reserveUID(entry.Id); err != nil {
+		return err
+	}
+
+	if old, err := t.get(entry.Id); err == nil {
+		if err := t.unindex(old); err != nil {
+			return err
+		}
+	}
+
+	buf, err := pb.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal entry")
+	}
+
+	if err := t.inodes.Put(uidKey(entry.Id), buf); err != nil {
+		return errors.Wrap(err, "failed to insert entry")
+	}
+
+	return t.index(entry)
+}
+
+// del removes the entry with the given UID from the inode table. Its
+// directory entry is left to the caller.
+func (t *indexTx) del(uid uint64) error {
+	old, err := t.get(uid)
+	if err != nil {
+		return err
+	}
+
+	if err := t.unindex(old); err != nil {
+		return err
+	}
+
+	if err := t.parents.Delete(uidKey(uid)); err != nil {
+		return err
+	}
+
+	return t.inodes.Delete(uidKey(uid))
+}
+
+// index adds the blocks and mtimes keys of the entry and counts it in the
+// statistics.
+func (t *indexTx) index(e *proto.Entry) error {
+	for _, ex := range e.GetFile().GetExtents() {
+		if err := t.blocks.Put(blockKey(e.Id, ex), nil); err != nil {
+			return errors.Wrap(err, "failed to index extent")
+		}
+	}
+
+	if err := t.mtimes.Put(mtimeKey(e), nil); err != nil {
+		return errors.Wrap(err, "failed to index modification time")
+	}
+
+	return t.count(e, 1)
+}
+
+// unindex removes the blocks and mtimes keys of the entry and discounts it
+// from the statistics.
+func (t *indexTx) unindex(e *proto.Entry) error {
+	for _, ex := range e.GetFile().GetExtents() {
+		if err := t.blocks.Delete(blockKey(e.Id, ex)); err != nil {
+			return err
+		}
+	}
+
+	if err := t.mtimes.Delete(mtimeKey(e)); err != nil {
+		return err
+	}
+
+	return t.count(e, -1)
+}
+
+// indexStats are the statistics of the entries of the binary index.
+type indexStats struct {
+	Files       uint64
+	Directories uint64
+	Symlinks    uint64
+
+	// the sum of the file lengths
+	Bytes uint64
+
+	// the number of extents and of files with more than one extent
+	Extents    uint64
+	Fragmented uint64
+
+	// the bytes of the extents on each (physical) partition
+	PartitionBytes [2]uint64
+}
+
+// add counts the entry n times (n is 1 or -1).
+func (st *indexStats) add(e *proto.Entry, n int64) {
+	d := uint64(n)
+
+	switch x := e.Elem.(type) {
+	case *proto.Entry_Dir:
+		st.Directories += d
+	case *proto.Entry_Symlink:
+		st.Symlinks += d
+	case *proto.Entry_File:
+		st.Files += d
+		st.Bytes += uint64(n * int64(x.File.Length))
+		st.Extents += uint64(n * int64(len(x.File.Extents)))
+
+		if len(x.File.Extents) > 1 {
+			st.Fragmented += d
+		}
+
+		for _, ex := range x.File.Extents {
+			if int(ex.Partition) < len(st.PartitionBytes) {
+				st.PartitionBytes[ex.Partition] += uint64(n * int64(ex.Length))
+			}
+		}
+	}
+}
+
+// entries returns the number of entries counted.
+func (st *indexStats) entries() uint64 {
+	return st.Files + st.Directories + st.Symlinks
+}
+
+// stats returns the statistics of the entries.
+func (t *indexTx) stats() (indexStats, error) {
+	var st indexStats
+
+	if v := t.meta.Get(statsKey); v != nil {
+		if err := binary.Read(bytes.NewReader(v), binary.BigEndian, &st); err != nil {
+			return st, errors.Wrap(err, "failed to read index statistics")
+		}
+	}
+
+	return st, nil
+}
+
+// unreferenced returns the bytes on the data partition no longer referenced
+// by any entry.
+func (t *indexTx) unreferenced() uint64 {
+	v := t.meta.Get(unreferencedKey)
+	if v == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(v)
+}
+
+// release adds the extents of e on the data partition to the unreferenced
+// bytes.
+func (t *indexTx) release(e *proto.Entry) error {
+	var n uint64
+	for _, ex := range e.GetFile().GetExtents() {
+		if ex.Partition == ltfs.DataPartition {
+			n += ex.Length
+		}
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	if err := t.meta.Put(unreferencedKey, uidKey(t.unreferenced()+n)); err != nil {
+		return errors.Wrap(err, "failed to write unreferenced bytes")
+	}
+
+	return nil
+}
+
+// count adds the entry n times to the statistics.
+func (t *indexTx) count(e *proto.Entry, n int64) error {
+	st, err := t.stats()
+	if err != nil {
+		return err
+	}
+
+	st.add(e, n)
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.BigEndian, &st); err != nil {
+		return err
+	}
+
+	if err := t.meta.Put(statsKey, buf.Bytes()); err != nil {
+		return errors.Wrap(err, "failed to write index statistics")
+	}
+
+	return nil
+}
+
+// child returns the UID of the entry with the given name in the directory
+// with the given UID.
+func (t *indexTx) child(parent uint64, name string) (uint64, bool) {
+	v := t.dirents.Get(direntKey(parent, t.policy.key(name)))
+	if v == nil {
+		return 0, false
+	}
+
+	return binary.BigEndian.Uint64(v), true
+}
+
+// link names the entry with the given UID in the directory with the UID
+// parent.
+func (t *indexTx) link(parent uint64, name string, uid uint64) error {
+	if err := t.dirents.Put(direntKey(parent, t.policy.key(name)), uidKey(uid)); err != nil {
+		return errors.Wrap(err, "failed to insert directory entry")
+	}
+
+	if err := t.parents.Put(uidKey(uid), uidKey(parent)); err != nil {
+		return errors.Wrap(err, "failed to insert parent")
+	}
+
+	return nil
+}
+
+// children calls fn with the UIDs of the entries of the directory with the
+// given UID, in name key order.
+func (t *indexTx) children(parent uint64, fn func(uid uint64) error) error {
+	prefix := uidKey(parent)
+
+	c := t.dirents.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if err := fn(binary.BigEndian.Uint64(v)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// root returns the UID of the root directory.
+func (t *indexTx) root() (uint64, error) {
+	uid, ok := t.child(0, "")
+	if !ok {
+		return 0, errors.New("root directory not found")
+	}
+
+	return uid, nil
+}
+
+// lookup returns the UID and entry at the clean, absolute path. Every
+// component but the last must be a directory.
+func (t *indexTx) lookup(path string) (uint64, *proto.Entry, error) {
+	uid, err := t.root()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+
+		var ok bool
+		if uid, ok = t.child(uid, name); !ok {
+			return 0, nil, os.ErrNotExist
+		}
+	}
+
+	entry, err := t.get(uid)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return uid, entry, nil
+}
+
+// path returns the path of the entry with the given UID.
+func (t *indexTx) path(uid uint64) (string, error) {
+	var names []string
+
+	// a directory seen twice on the way up is a cycle
+	seen := make(map[uint64]bool)
+
+	for {
+		if seen[uid] {
+			return "", errors.Errorf("directory cycle at file UID %d", uid)
+		}
+
+		seen[uid] = true
+
+		v := t.parents.Get(uidKey(uid))
+		if v == nil {
+			return "", os.ErrNotExist
+		}
+
+		parent := binary.BigEndian.Uint64(v)
+		if parent == 0 {
+			break
+		}
+
+		e, err := t.get(uid)
+		if err != nil {
+			return "", errors.Wrapf(err, "missing entry %d", uid)
+		}
+
+		names = append(names, e.Name)
+		uid = parent
+	}
+
+	// the names were collected bottom up
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+
+	return "/" + strings.Join(names, "/"), nil
+}
+
+// walk calls fn for the entry with the given UID and, if it is a directory,
+// all entries below it, depth first. The entries of a directory are passed
+// in name key order after the directory itself. If leave is not nil, it is
+// called for each directory after its entries.
+func (t *indexTx) walk(uid uint64, fn func(e *proto.Entry) error, leave func(e *proto.Entry) error) error {
+	entry, err := t.get(uid)
+	if err != nil {
+		return errors.Wrapf(err, "missing entry %d", uid)
+	}
+
+	if err := fn(entry); err != nil {
+		return err
+	}
+
+	if entry.GetDir() == nil {
+		return nil
+	}
+
+	err = t.children(uid, func(child uint64) error {
+		return t.walk(child, fn, leave)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if leave != nil {
+		return leave(entry)
+	}
+
+	return nil
+}
+
+// migrateIndexDB converts a database in the path keyed layout to the inode
+// layout. The names of the entries are keyed under policy.
+func migrateIndexDB(tx engine.Tx, policy NamePolicy) error {
+	if !tx.HasBucket(legacyBucket) {
+		return nil
+	}
+
+	legacy := tx.Bucket(legacyBucket)
+
+	t, err := newIndexTx(tx, policy)
+	if err != nil {
+		return err
+	}
+
+	// the UIDs of the directories by key; in key order a directory precedes
+	// its entries
+	dirs := make(map[string]uint64)
+
+	c := legacy.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		key := string(k)
+
+		var entry proto.Entry
+		if err := pb.Unmarshal(v, &entry); err != nil {
+			return errors.Wrapf(err, "failed to unmarshal entry '%s'", key)
+		}
+
+		if t.inodes.Get(uidKey(entry.Id)) != nil {
+			return errors.Errorf("duplicate file UID %d at '%s'", entry.Id, key)
+		}
+
+		var parent uint64
+		name := entry.Name
+
+		if key != "/" {
+			var ok bool
+			if parent, ok = dirs[parentKey(key)]; !ok {
+				return errors.Errorf("entry '%s' has no parent directory", key)
+			}
+		} else {
+			name = ""
+		}
+
+		if err := t.put(&entry); err != nil {
+			return err
+		}
+
+		if err := t.link(parent, name, entry.Id); err != nil {
+			return err
+		}
+
+		if entry.GetDir() != nil {
+			dirs[key] = entry.Id
+		}
+	}
+
+	return tx.DeleteBucket(legacyBucket)
+}
+
+// isEmpty reports whether the bucket has no keys. Unlike Len, it does not
+// count the keys.
+func isEmpty(b engine.Bucket) bool {
+	k, _ := b.Cursor().First()
+	return k == nil
+}
+
+// reindexIndexDB rebuilds the secondary buckets of a database without them,
+// and the statistics of a database without statistics.
+func reindexIndexDB(tx engine.Tx, policy NamePolicy) error {
+	t, err := newIndexTx(tx, policy)
+	if err != nil {
+		return err
+	}
+
+	// the statistics are counted again if they are missing
+	rebuild := isEmpty(t.parents)
+	recount := rebuild || t.meta.Get(statsKey) == nil
+
+	if !recount || isEmpty(t.inodes) {
+		return nil
+	}
+
+	if err := t.meta.Delete(statsKey); err != nil {
+		return err
+	}
+
+	if rebuild {
+		c := t.dirents.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if err := t.parents.Put(v, k[:8]); err != nil {
+				return err
+			}
+		}
+	}
+
+	c := t.inodes.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var entry proto.Entry
+		if err := pb.Unmarshal(v, &entry); err != nil {
+			return errors.Wrapf(err, "failed to unmarshal entry %d", binary.BigEndian.Uint64(k))
+		}
+
+		if !rebuild {
+			err = t.count(&entry, 1)
+		} else {
+			err = t.index(&entry)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parentKey returns the key of the directory holding the entry with the given
+// key in the path keyed layout, or "" for the root.
+func parentKey(key string) string {
+	if key == "/" {
+		return ""
+	}
+
+	i := strings.LastIndex(strings.TrimSuffix(key, "/"), "/")
+
+	return key[:i+1]
+}
@@ -0,0 +1,127 @@
+package bltfs
+
+import (
+	"path/filepath"
+	"testing"
+
+	pb "github.com/golang/protobuf/proto"
+
+	"hpt.space/bltfs/engine"
+	"hpt.space/bltfs/engine/memory"
+	"hpt.space/bltfs/proto"
+)
+
+func TestMigrateIndexDB(t *testing.T) {
+	db := memory.New()
+	defer db.Close()
+
+	dirEntry := func(uid uint64, name string) *proto.Entry {
+		return &proto.Entry{Id: uid, Name: name, Elem: &proto.Entry_Dir{Dir: &proto.Directory{}}}
+	}
+
+	fileEntry := func(uid uint64, name string) *proto.Entry {
+		return &proto.Entry{Id: uid, Name: name, Elem: &proto.Entry_File{File: &proto.File{Length: 1}}}
+	}
+
+	// the path keyed layout
+	legacy := map[string]*proto.Entry{
+		"/":             dirEntry(1, "root"),
+		"/dir/":         dirEntry(2, "dir"),
+		"/dir/a":        fileEntry(3, "a"),
+		"/dir/sub/":     dirEntry(4, "sub"),
+		"/dir/sub/b":    fileEntry(5, "b"),
+		"/testfile.txt": fileEntry(6, "testfile.txt"),
+	}
+
+	err := db.Update(func(tx engine.Tx) error {
+		bkt := tx.Bucket(legacyBucket)
+
+		for k, e := range legacy {
+			buf, err := pb.Marshal(e)
+			if err != nil {
+				return err
+			}
+
+			if err := bkt.Put([]byte(k), buf); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := initIndexDB(db, CaseSensitive); err != nil {
+		t.Fatal(err)
+	}
+
+	db.View(func(tx engine.Tx) error {
+		if tx.HasBucket(legacyBucket) {
+			t.Error("legacy bucket not removed")
+		}
+
+		return nil
+	})
+
+	idx := &index{db: db, policy: CaseSensitive}
+
+	for _, p := range []string{"/", "/dir", "/dir/", "/dir/a", "/dir/sub", "/dir/sub/b", "/testfile.txt"} {
+		e, err := idx.stat(p)
+		if err != nil {
+			t.Errorf("stat %s: %v", p, err)
+			continue
+		}
+
+		key := p
+		if e.GetDir() != nil && key != "/" {
+			key = filepath.Clean(key) + "/"
+		}
+
+		if want := legacy[key]; e.Id != want.Id {
+			t.Errorf("stat %s: expected UID %d, got %d", p, want.Id, e.Id)
+		}
+	}
+
+	// the secondary buckets are built as well
+	err = idx.view(func(tx *indexTx) error {
+		path, err := tx.path(5)
+		if err != nil {
+			return err
+		}
+
+		if path != "/dir/sub/b" {
+			t.Errorf("expected /dir/sub/b, got %s", path)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := idx.List("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || entries[0].Name != "a" || entries[1].Name != "sub" {
+		t.Errorf("unexpected entries %v", entries)
+	}
+
+	if _, err := idx.List("/testfile.txt"); err == nil {
+		t.Error("expected error listing a file")
+	}
+
+	entries, err = idx.Scan("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != len(legacy) {
+		t.Errorf("expected %d entries, got %d", len(legacy), len(entries))
+	}
+}
@@ -3,6 +3,8 @@ package bltfs
 import (
 	"bytes"
 	"io"
+
+	"hpt.space/bltfs/backend"
 )
 
 // Copy copies data from the io.Reader to the io.Writer, ensuring that the
@@ -116,8 +118,121 @@ func (b *Store) ReadFile() ([]byte, error) {
 		readZero = false
 
 		// write block to buffer
-		buf.Write(blk)
+		buf.Write(blk[:n])
 	}
 
 	return buf.Bytes(), nil
 }
+
+// recordReader reads the records of the current file on the device, up to the
+// next filemark, as a stream of bytes.
+type recordReader struct {
+	dev backend.Interface
+	blk []byte
+
+	// the unread part of the last record
+	p   []byte
+	eof bool
+}
+
+func (b *Store) newRecordReader() *recordReader {
+	return &recordReader{
+		dev: b.mu.backend,
+		blk: make([]byte, b.mu.backend.BlockSize()),
+	}
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+	for len(r.p) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+
+		n, err := r.dev.Read(r.blk)
+		if err != nil {
+			return 0, err
+		}
+
+		// a zero length read is a filemark
+		if n == 0 {
+			r.eof = true
+		}
+
+		r.p = r.blk[:n]
+	}
+
+	n := copy(p, r.p)
+	r.p = r.p[n:]
+
+	return n, nil
+}
+
+// recordWriter writes a stream of bytes to the device in records of the
+// device block size. Flush writes the final, possibly shorter, record.
+type recordWriter struct {
+	dev backend.Interface
+	blk []byte
+	n   int
+}
+
+func (b *Store) newRecordWriter() *recordWriter {
+	return &recordWriter{
+		dev: b.mu.backend,
+		blk: make([]byte, b.mu.backend.BlockSize()),
+	}
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	var written int
+
+	for len(p) > 0 {
+		n := copy(w.blk[w.n:], p)
+		w.n += n
+		written += n
+		p = p[n:]
+
+		if w.n == len(w.blk) {
+			if err := w.Flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// Flush writes any buffered bytes as a record.
+func (w *recordWriter) Flush() error {
+	if w.n == 0 {
+		return nil
+	}
+
+	if _, err := w.dev.Write(w.blk[:w.n]); err != nil {
+		return err
+	}
+
+	w.n = 0
+
+	return nil
+}
+
+// writeRecords writes p to the underlying device as a sequence of records of
+// at most the device block size. It does NOT write a filemark.
+func (b *Store) writeRecords(p []byte) error {
+	blkSize := int(b.mu.backend.BlockSize())
+
+	for len(p) > 0 {
+		n := len(p)
+		if n > blkSize {
+			n = blkSize
+		}
+
+		if _, err := b.mu.backend.Write(p[:n]); err != nil {
+			return err
+		}
+
+		p = p[n:]
+	}
+
+	return nil
+}
@@ -0,0 +1,274 @@
+package bltfs_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	pb "github.com/golang/protobuf/proto"
+
+	"hpt.space/bltfs"
+	"hpt.space/bltfs/ltfs"
+	"hpt.space/bltfs/proto"
+)
+
+// readTestLogs returns the logs on the data partition of the tape in dir. A
+// log is a file of its own that is stamped with its block.
+func readTestLogs(t *testing.T, dir string) []*proto.Log {
+	dev := openTestDevice(t, dir)
+	defer dev.Close()
+
+	if err := dev.Locate(ltfs.DataPartition, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		logs []*proto.Log
+		fm   bool
+	)
+
+	buf := make([]byte, dev.BlockSize())
+	for blk := uint64(0); ; blk++ {
+		n, err := dev.Read(buf)
+		if err != nil {
+			break
+		}
+
+		var l proto.Log
+		if fm && n > 0 && pb.Unmarshal(buf[:n], &l) == nil && l.Class != proto.Log_UNKNOWN && l.Block == blk {
+			logs = append(logs, &l)
+		}
+
+		fm = n == 0
+	}
+
+	return logs
+}
+
+func TestJournalLogs(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex(), bltfs.WithRecoveryPolicy(bltfs.RecoveryPolicy{
+		IncrementalAfter:  4,
+		DifferentialAfter: 10,
+	}))
+	defer cleanup(dir)
+
+	// an incremental log is written after 4 bytes
+	writeTestFile(t, store, "/a", []byte("abcd"))
+
+	b, err := store.Create("/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a differential log is written after 10 bytes
+	if _, err := b.Write([]byte("efghij")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, store, "/c", []byte("klmn"))
+
+	expectContent(t, store, "/a", []byte("abcd"))
+	expectContent(t, store, "/b", []byte("efghij"))
+	expectContent(t, store, "/c", []byte("klmn"))
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := readTestLogs(t, dir)
+	if len(logs) != 4 {
+		t.Fatalf("expected 4 logs, got %d", len(logs))
+	}
+
+	// the UID of /a is reserved in a log before it is handed out
+	res, inc, diff, next := logs[0], logs[1], logs[2], logs[3]
+
+	if res.Class != proto.Log_INC || inc.Class != proto.Log_INC || diff.Class != proto.Log_DIFF || next.Class != proto.Log_INC {
+		t.Fatalf("unexpected log classes %v, %v, %v, %v", res.Class, inc.Class, diff.Class, next.Class)
+	}
+
+	if inc.Prev != res.Block {
+		t.Errorf("expected incremental to point at %d, got %d", res.Block, inc.Prev)
+	}
+
+	// the differential goes back to the epoch, as the first incremental
+	if diff.Prev != res.Prev || diff.Prev >= inc.Block {
+		t.Errorf("expected differential to point at the epoch %d, got %d", res.Prev, diff.Prev)
+	}
+
+	// the epoch is reset by the differential
+	if next.Prev != diff.Block {
+		t.Errorf("expected incremental to point at %d, got %d", diff.Block, next.Prev)
+	}
+
+	names := func(l *proto.Log) (s []string) {
+		for _, e := range l.Entries {
+			s = append(s, e.Operation.String()+" "+e.Name)
+		}
+
+		return s
+	}
+
+	for _, tt := range []struct {
+		log     *proto.Log
+		entries []string
+		extents int
+	}{
+		{res, []string{"CH /"}, 0},
+		{inc, []string{"ADD /a"}, 1},
+		{diff, []string{"CH /", "ADD /a", "CH /a", "ADD /b"}, 2},
+		{next, []string{"CH /b", "ADD /c"}, 1},
+	} {
+		if got := names(tt.log); len(got) != len(tt.entries) {
+			t.Errorf("%v log at %d: expected entries %v, got %v", tt.log.Class, tt.log.Block, tt.entries, got)
+		} else {
+			for i := range got {
+				if got[i] != tt.entries[i] {
+					t.Errorf("%v log at %d: expected entries %v, got %v", tt.log.Class, tt.log.Block, tt.entries, got)
+					break
+				}
+			}
+		}
+
+		if len(tt.log.Extents) != tt.extents {
+			t.Errorf("%v log at %d: expected %d extents, got %d", tt.log.Class, tt.log.Block, tt.extents, len(tt.log.Extents))
+		}
+	}
+
+	// the extents identify their files
+	if e := inc.Extents[0]; e.Id != inc.Entries[0].Id || e.Length != 4 {
+		t.Errorf("unexpected extent %v", e)
+	}
+
+	// the volume can still be mounted
+	store, err = bltfs.Open(openTestDevice(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Close()
+}
+
+func TestJournalLogsDisabled(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+
+	writeTestFile(t, store, "/a", []byte("abcd"))
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if logs := readTestLogs(t, dir); len(logs) != 0 {
+		t.Errorf("expected no logs, got %d", len(logs))
+	}
+}
+
+func TestJournalAfterSync(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex(), bltfs.WithRecoveryPolicy(bltfs.RecoveryPolicy{
+		IncrementalAfter: 4,
+	}))
+	defer cleanup(dir)
+
+	writeTestFile(t, store, "/a", []byte("abcd"))
+
+	if _, err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the index holds the changes so far
+	if log := store.Journal(); len(log.Entries) != 0 || len(log.Extents) != 0 {
+		t.Errorf("unexpected journal after sync %v", log)
+	}
+
+	writeTestFile(t, store, "/b", []byte("efgh"))
+
+	gens, err := store.Generations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var index uint64
+	for _, g := range gens {
+		if g.Partition == ltfs.DataPartition {
+			index = g.Block
+			break
+		}
+	}
+
+	// each epoch starts with a log reserving UIDs
+	logs := readTestLogs(t, dir)
+	if len(logs) != 4 {
+		t.Fatalf("expected 4 logs, got %d", len(logs))
+	}
+
+	// the log after the index points back to it
+	if l := logs[2]; l.Prev != index || l.Block <= index {
+		t.Errorf("expected log at %d to point at the index at %d, got %d", l.Block, index, l.Prev)
+	}
+
+	if l := logs[3]; len(l.Entries) != 1 || l.Entries[0].Name != "/b" {
+		t.Errorf("unexpected log entries %v", l.Entries)
+	}
+}
+
+func TestJournalXattrs(t *testing.T) {
+	idx := makeTestIndex()
+	idx.AllowPolicyUpdate = true
+
+	store, dir := openTestStore(t, idx)
+	defer cleanup(dir)
+	defer store.Close()
+
+	if err := store.Setxattr("/testfile.txt", "user.a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Removexattr("/testfile.txt", "user.a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.SetDataPlacementPolicy(ltfs.DataPlacementPolicy{Size: 16}); err != nil {
+		t.Fatal(err)
+	}
+
+	log := store.Journal()
+
+	var names []string
+	for _, e := range log.Entries {
+		names = append(names, e.Operation.String()+" "+e.Name)
+	}
+
+	if expected := []string{"CH /testfile.txt", "CH /testfile.txt", "CH /"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected entries %v, got %v", expected, names)
+	}
+
+	// the volume state is logged with the root directory
+	var pol string
+	for _, x := range log.Entries[2].Xattrs {
+		if x.Key == "ltfs.dataPlacementPolicy" {
+			pol = string(x.Value)
+		}
+	}
+
+	if !strings.Contains(pol, "<size>16</size>") {
+		t.Errorf("unexpected logged policy %q", pol)
+	}
+
+	// locking the volume writes an index, which holds the changes so far
+	if err := store.LockVolume(); err != nil {
+		t.Fatal(err)
+	}
+
+	if log := store.Journal(); len(log.Entries) != 0 {
+		t.Errorf("unexpected journal after locking %v", log.Entries)
+	}
+}
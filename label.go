@@ -0,0 +1,40 @@
+package bltfs
+
+import (
+	"encoding/xml"
+
+	"github.com/pkg/errors"
+
+	"hpt.space/bltfs/ltfs"
+)
+
+// labelBlock is the block of the LTFS label on both partitions. It follows
+// the ANSI VOL1 label in block 0.
+const labelBlock = 1
+
+// ReadLTFSLabel reads the LTFS label from the index partition and returns a
+// ltfs.LabelLTFS representation.
+func (b *Store) ReadLTFSLabel() (*ltfs.LabelLTFS, error) {
+	return b.readLTFSLabel(ltfs.IndexPartition)
+}
+
+// readLTFSLabel reads the LTFS label recorded on the given partition.
+func (b *Store) readLTFSLabel(part uint32) (*ltfs.LabelLTFS, error) {
+	if err := b.mu.backend.Locate(part, labelBlock); err != nil {
+		return nil, errors.Wrap(err, "failed to locate label")
+	}
+
+	buf, err := b.ReadFile()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read file")
+	}
+
+	var label ltfs.LabelLTFS
+
+	// unmarshal the LTFS label
+	if err := xml.Unmarshal(buf, &label); err != nil {
+		return nil, err
+	}
+
+	return &label, nil
+}
@@ -2,8 +2,13 @@ package bltfs
 
 import (
 	"sync"
+	"sync/atomic"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
 
 	"hpt.space/bltfs/backend"
+	"hpt.space/bltfs/ltfs"
 	pb "hpt.space/bltfs/proto"
 )
 
@@ -11,6 +16,11 @@ type synchronizedWriter struct {
 	mu struct {
 		sync.Mutex
 		backend backend.Interface
+
+		// the record shared by the batched writes of open files and the
+		// block it will be written at; nil until the block is known
+		batch []byte
+		block uint64
 	}
 }
 
@@ -28,6 +38,98 @@ func (rw *synchronizedWriter) Write(p []byte) (n int, err error) {
 	return rw.mu.backend.Write(p)
 }
 
+// write appends p to the data partition and returns the extent holding it;
+// the extent has no file offset. Unless noBatch is set, p shares records with
+// the batched writes of other files, so the extent may start mid-record.
+// Otherwise p starts a new record and its last record is written right away.
+// The device must be positioned at EOD on the data partition.
+func (rw *synchronizedWriter) write(p []byte, noBatch bool) (*pb.Extent, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if noBatch {
+		if err := rw.flushBatch(); err != nil {
+			return nil, err
+		}
+	}
+
+	if rw.mu.batch == nil {
+		block, err := rw.mu.backend.ReadPosition()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read position")
+		}
+
+		rw.mu.block = block
+		rw.mu.batch = make([]byte, 0, rw.mu.backend.BlockSize())
+	}
+
+	e := &pb.Extent{
+		Partition: ltfs.DataPartition,
+		Block:     rw.mu.block,
+		Boffset:   uint64(len(rw.mu.batch)),
+		Length:    uint64(len(p)),
+	}
+
+	for len(p) > 0 {
+		n := cap(rw.mu.batch) - len(rw.mu.batch)
+		if n > len(p) {
+			n = len(p)
+		}
+
+		rw.mu.batch = append(rw.mu.batch, p[:n]...)
+		p = p[n:]
+
+		if len(rw.mu.batch) == cap(rw.mu.batch) {
+			if err := rw.writeBatch(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if noBatch {
+		if err := rw.flushBatch(); err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// writeBatch writes the batched record.
+func (rw *synchronizedWriter) writeBatch() error {
+	if _, err := rw.mu.backend.Write(rw.mu.batch); err != nil {
+		return errors.Wrap(err, "failed to write record")
+	}
+
+	rw.mu.block++
+	rw.mu.batch = rw.mu.batch[:0]
+
+	return nil
+}
+
+// flushBatch writes the batched record, if any, as a short record. The
+// position of the next batch is read from the device again, as the device
+// may be used by others in between.
+func (rw *synchronizedWriter) flushBatch() error {
+	if len(rw.mu.batch) > 0 {
+		if err := rw.writeBatch(); err != nil {
+			return err
+		}
+	}
+
+	rw.mu.batch = nil
+
+	return nil
+}
+
+// flush writes any batched data to the device.
+func (rw *synchronizedWriter) flush() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	return rw.flushBatch()
+}
+
 type Log interface {
 	Create(*pb.Entry)
 	Remove(*pb.Entry)
@@ -99,3 +201,330 @@ func (diff *Differential) Change(e *pb.Entry) {
 
 	diff.Entries = append(diff.Entries, e)
 }
+
+// journal records the changes to the binary index since the last full index
+// was written (see Store.Sync), or since the store was mounted. The logged
+// entries carry their full path as their name and are identified by their id
+// (see DiffLog).
+//
+// The changes are also collected into the logs written to the data partition
+// under the recovery policy (see Store.writeLog). An incremental log holds
+// the changes since the previous log and points back to it. A differential
+// log holds the changes since the epoch and points back to it; it becomes the
+// new epoch. The epoch starts out at the end of the data partition at mount
+// and is reset to each full index written to the data partition.
+type journal struct {
+	sync.Mutex
+
+	// all changes since the last full index
+	all *Incremental
+
+	// the pending logs
+	inc  *Incremental
+	diff *Differential
+
+	// the blocks of the last log written and of the epoch
+	last, epoch uint64
+
+	// the bytes written since the last log and since the epoch
+	incBytes, diffBytes uint64
+
+	// the highest file UID reserved in the logs since the epoch (see
+	// Store.reserveUID)
+	reserved uint64
+}
+
+// reset starts a new epoch at the given block of the data partition.
+func (j *journal) reset(block uint64) {
+	j.Lock()
+	defer j.Unlock()
+
+	j.restart(block)
+}
+
+// restart starts a new epoch at the given block of the data partition and
+// drops the changes recorded so far. The caller must hold the lock.
+func (j *journal) restart(block uint64) {
+	j.all = nil
+	j.last, j.epoch = block, block
+	j.incBytes, j.diffBytes = 0, 0
+	j.reserved = 0
+
+	j.inc = NewIncremental(&Incremental{Log: &pb.Log{Block: block}})
+	j.diff = NewDifferential(&pb.Index{Block: block})
+}
+
+// record logs the operation op on the entry at path.
+func (j *journal) record(op pb.Entry_Op, path string, e *pb.Entry) {
+	j.Lock()
+	defer j.Unlock()
+
+	e = proto.Clone(e).(*pb.Entry)
+	e.Name = path
+
+	for _, l := range j.logs() {
+		switch op {
+		case pb.Entry_ADD:
+			l.Create(e)
+		case pb.Entry_RM:
+			l.Remove(e)
+		default:
+			l.Change(e)
+		}
+	}
+}
+
+// reserve logs the root directory e, carrying a file UID reservation, in the
+// pending logs. The reservation only matters to Recover, so it is not among
+// the changes since the last full index (see Store.Journal). The caller must
+// hold the lock.
+func (j *journal) reserve(e *pb.Entry) {
+	e = proto.Clone(e).(*pb.Entry)
+	e.Name = "/"
+
+	j.logs()
+
+	j.inc.Change(e)
+	j.diff.Change(e)
+}
+
+// extent logs the extent e written to the file with the given UID. The
+// extents of a file are logged as they are written, so the data of files
+// still open is found in the logs.
+func (j *journal) extent(uid uint64, e *pb.Extent) {
+	j.Lock()
+	defer j.Unlock()
+
+	e = proto.Clone(e).(*pb.Extent)
+	e.Id = uid
+
+	j.logs()
+
+	for _, l := range []*pb.Log{j.all.Log, j.inc.Log, j.diff.Log} {
+		l.Extents = append(l.Extents, e)
+	}
+}
+
+// logs returns the logs that changes are recorded in.
+func (j *journal) logs() []Log {
+	if j.all == nil {
+		j.all = NewIncremental(&Incremental{Log: &pb.Log{}})
+	}
+
+	if j.inc == nil {
+		j.inc = NewIncremental(&Incremental{Log: &pb.Log{}})
+		j.diff = NewDifferential(&pb.Index{})
+	}
+
+	return []Log{j.all, j.inc, j.diff}
+}
+
+// wrote accounts for n bytes of file data written to the volume.
+func (j *journal) wrote(n uint64) {
+	j.Lock()
+	defer j.Unlock()
+
+	j.incBytes += n
+	j.diffBytes += n
+}
+
+// due returns the log to be written under the recovery policy, or nil. A
+// threshold of zero bytes disables the log. The caller must hold the lock.
+func (j *journal) due(pol RecoveryPolicy) *pb.Log {
+	j.logs()
+
+	switch {
+	case pol.DifferentialAfter > 0 && j.diffBytes >= pol.DifferentialAfter:
+		return j.diff.Log
+	case pol.IncrementalAfter > 0 && j.incBytes >= pol.IncrementalAfter:
+		return j.inc.Log
+	}
+
+	return nil
+}
+
+// written starts the next logs after l was written. The caller must hold the
+// lock.
+func (j *journal) written(l *pb.Log) {
+	j.last = l.Block
+	j.incBytes = 0
+	j.inc = NewIncremental(&Incremental{Log: l})
+
+	if l.Class == pb.Log_DIFF {
+		j.epoch = l.Block
+		j.diffBytes = 0
+		j.diff = NewDifferential(&pb.Index{Block: l.Block})
+	}
+}
+
+// writeLog writes the log due under the recovery policy, if any, to the end
+// of the data partition between filemarks. The log is stamped with the block
+// it starts at.
+func (s *Store) writeLog() error {
+	s.rw.mu.Lock()
+	defer s.rw.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.journal.Lock()
+	defer s.journal.Unlock()
+
+	l := s.journal.due(s.sopts.pol)
+	if l == nil {
+		return nil
+	}
+
+	return s.appendLog(l)
+}
+
+// appendLog writes the log l to the end of the data partition. The caller
+// must hold the device and the journal.
+func (s *Store) appendLog(l *pb.Log) error {
+	// batched data goes before the log
+	if err := s.rw.flushBatch(); err != nil {
+		return err
+	}
+
+	dev := s.mu.backend
+
+	block, err := dev.ReadPosition()
+	if err != nil {
+		return errors.Wrap(err, "failed to read position")
+	}
+
+	// make sure the log is preceded by a filemark
+	fm, err := s.afterFilemark(ltfs.DataPartition, block)
+	if err != nil {
+		return err
+	}
+
+	if !fm {
+		if err := dev.WriteFilemark(1); err != nil {
+			return err
+		}
+
+		block++
+	}
+
+	l.Block = block
+
+	buf, err := proto.Marshal(l)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal log")
+	}
+
+	if err := s.writeRecords(buf); err != nil {
+		return errors.Wrap(err, "failed to write log")
+	}
+
+	if err := dev.WriteFilemark(1); err != nil {
+		return err
+	}
+
+	s.journal.written(l)
+
+	return nil
+}
+
+// uidBatch is the number of file UIDs reserved in a log at a time (see
+// Store.reserveUID).
+const uidBatch = 1024
+
+// allocUID allocates a file UID for a new entry, reserving it first.
+func (s *Store) allocUID() (uint64, error) {
+	s.uids.Lock()
+	defer s.uids.Unlock()
+
+	uid := atomic.LoadUint64(&s.idx.lastUID) + 1
+	if uid < s.uids.next {
+		uid = s.uids.next
+	}
+
+	if err := s.reserveUID(uid); err != nil {
+		return 0, err
+	}
+
+	s.uids.next = uid + 1
+
+	return uid, nil
+}
+
+// reserveUID makes sure that uid is reserved in the logs before it is handed
+// out, so Recover never allocates it again. UIDs are reserved in batches of
+// uidBatch; a log holding the reservation is written right away whenever a
+// new batch is needed. Without logs (see RecoveryPolicy), nothing is
+// recovered after the last index, so nothing is reserved.
+func (s *Store) reserveUID(uid uint64) error {
+	if s.sopts.pol.DifferentialAfter == 0 && s.sopts.pol.IncrementalAfter == 0 {
+		return nil
+	}
+
+	s.rw.mu.Lock()
+	defer s.rw.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.journal.Lock()
+	defer s.journal.Unlock()
+
+	if uid <= s.journal.reserved {
+		return nil
+	}
+
+	root, err := s.volumeEntry(uid + uidBatch - 1)
+	if err != nil {
+		return err
+	}
+
+	s.journal.reserve(root)
+
+	l := s.journal.due(s.sopts.pol)
+	if l == nil {
+		l = s.journal.inc.Log
+	}
+
+	if err := s.appendLog(l); err != nil {
+		return errors.Wrap(err, "failed to reserve file UIDs")
+	}
+
+	s.journal.reserved = uid + uidBatch - 1
+
+	return nil
+}
+
+// afterFilemark reports whether the block before the given block of the
+// partition is a filemark. The device is positioned at the given block again.
+// The caller must hold the device.
+func (s *Store) afterFilemark(part uint32, block uint64) (bool, error) {
+	dev := s.mu.backend
+
+	if block == 0 {
+		return false, nil
+	}
+
+	if err := dev.Locate(part, block-1); err != nil {
+		return false, err
+	}
+
+	buf := make([]byte, dev.BlockSize())
+	n, rerr := dev.Read(buf)
+
+	if err := dev.Locate(part, block); err != nil {
+		return false, err
+	}
+
+	return rerr == nil && n == 0, nil
+}
+
+// Journal returns the changes made to the store since the last full index was
+// written, or since it was mounted.
+func (s *Store) Journal() *pb.Log {
+	s.journal.Lock()
+	defer s.journal.Unlock()
+
+	s.journal.logs()
+
+	return proto.Clone(s.journal.all.Log).(*pb.Log)
+}
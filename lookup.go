@@ -0,0 +1,181 @@
+package bltfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"time"
+
+	"hpt.space/bltfs/proto"
+)
+
+// An EntryRef is an entry of the store found by a lookup other than by path.
+type EntryRef struct {
+	Path string
+	Info os.FileInfo
+}
+
+// A BlockOwner is a file with an extent on a tape block.
+type BlockOwner struct {
+	EntryRef
+
+	// Extent is the extent of the file on the block.
+	Extent *proto.Extent
+}
+
+// LookupUID returns the path and a FileInfo describing the entry with the
+// given file UID. If there is no such entry, the error is os.ErrNotExist.
+func (s *Store) LookupUID(uid uint64) (string, os.FileInfo, error) {
+	var (
+		path  string
+		entry *proto.Entry
+	)
+
+	err := s.idx.view(func(t *indexTx) error {
+		var err error
+		if entry, err = t.get(uid); err != nil {
+			return err
+		}
+
+		path, err = t.path(uid)
+
+		return err
+	})
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return path, &entryStat{e: entry}, nil
+}
+
+// BlockOwners returns the files with an extent on the given block of the
+// given (physical) partition, in order of the start block of their extents.
+// A block may hold the end of one extent and the start of others.
+func (s *Store) BlockOwners(part uint32, block uint64) ([]BlockOwner, error) {
+	blkSize := uint64(s.ltfs.label.BlockSize)
+	if blkSize == 0 {
+		blkSize = s.sopts.blkSize
+	}
+
+	return s.idx.blockOwners(part, block, blkSize)
+}
+
+// ModifiedBetween returns the entries last modified at or after from and
+// before to, in order of their modification time.
+func (s *Store) ModifiedBetween(from, to time.Time) ([]EntryRef, error) {
+	var refs []EntryRef
+
+	err := s.idx.view(func(t *indexTx) error {
+		end := timeKey(to.UnixNano())
+
+		c := t.mtimes.Cursor()
+		for k, _ := c.Seek(timeKey(from.UnixNano())); k != nil && bytes.Compare(k[:8], end) < 0; k, _ = c.Next() {
+			ref, err := t.ref(binary.BigEndian.Uint64(k[8:]))
+			if err != nil {
+				return err
+			}
+
+			refs = append(refs, ref)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return refs, nil
+}
+
+// ref returns a reference to the entry with the given UID.
+func (t *indexTx) ref(uid uint64) (EntryRef, error) {
+	e, err := t.get(uid)
+	if err != nil {
+		return EntryRef{}, err
+	}
+
+	path, err := t.path(uid)
+	if err != nil {
+		return EntryRef{}, err
+	}
+
+	return EntryRef{Path: path, Info: &entryStat{e: e}}, nil
+}
+
+// blockOwners returns the files with an extent on the block (see
+// Store.BlockOwners). Blocks hold blkSize bytes.
+func (idx *index) blockOwners(part uint32, block uint64, blkSize uint64) ([]BlockOwner, error) {
+	var owners []BlockOwner
+
+	err := idx.view(func(t *indexTx) error {
+		prefix := make([]byte, 4)
+		binary.BigEndian.PutUint32(prefix, part)
+
+		// the extents starting at a block are scanned backwards from the
+		// block until a start block none of whose extents reach the block
+		c := t.blocks.Cursor()
+
+		k, _ := c.Seek(blockKey(0, &proto.Extent{Partition: part, Block: block + 1}))
+		if k == nil {
+			k, _ = c.Last()
+		} else {
+			k, _ = c.Prev()
+		}
+
+		var (
+			start   uint64
+			first   = true
+			covered bool
+		)
+
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Prev() {
+			if b := binary.BigEndian.Uint64(k[4:]); first || b != start {
+				if !first && !covered {
+					break
+				}
+
+				start, first, covered = b, false, false
+			}
+
+			uid, offset := binary.BigEndian.Uint64(k[12:]), binary.BigEndian.Uint64(k[20:])
+
+			ref, err := t.ref(uid)
+			if err != nil {
+				return err
+			}
+
+			for _, ex := range ref.Info.Sys().(*proto.Entry).GetFile().GetExtents() {
+				if ex.Partition != part || ex.Block != start || ex.Offset != offset {
+					continue
+				}
+
+				blocks := (ex.Boffset + ex.Length + blkSize - 1) / blkSize
+				if blocks == 0 {
+					blocks = 1
+				}
+
+				if block < start+blocks {
+					covered = true
+					owners = append(owners, BlockOwner{EntryRef: ref, Extent: ex})
+				}
+
+				break
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the owners were collected backwards
+	for i, j := 0, len(owners)-1; i < j; i, j = i+1, j-1 {
+		owners[i], owners[j] = owners[j], owners[i]
+	}
+
+	return owners, nil
+}
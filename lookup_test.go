@@ -0,0 +1,134 @@
+package bltfs_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"hpt.space/bltfs/ltfs"
+	"hpt.space/bltfs/util/testutil"
+)
+
+func TestLookupUID(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	for uid, want := range map[uint64]string{1: "/", 2: "/testfile.txt", 3: "/dir", 4: "/dir/file"} {
+		path, fi, err := store.LookupUID(uid)
+		if err != nil {
+			t.Errorf("uid %d: %v", uid, err)
+			continue
+		}
+
+		if path != want {
+			t.Errorf("uid %d: expected %s, got %s", uid, want, path)
+		}
+
+		if uid != 1 && fi.Name() != want[len(want)-len(fi.Name()):] {
+			t.Errorf("uid %d: unexpected name %s", uid, fi.Name())
+		}
+	}
+
+	if _, _, err := store.LookupUID(5); err != os.ErrNotExist {
+		t.Errorf("expected os.ErrNotExist, got %v", err)
+	}
+
+	// paths follow renames
+	if err := store.Rename("/dir", "/archive"); err != nil {
+		t.Fatal(err)
+	}
+
+	if path, _, err := store.LookupUID(4); err != nil || path != "/archive/file" {
+		t.Errorf("expected /archive/file, got %s (%v)", path, err)
+	}
+
+	if err := store.RemoveAll("/archive"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := store.LookupUID(4); err != os.ErrNotExist {
+		t.Errorf("expected os.ErrNotExist, got %v", err)
+	}
+}
+
+func TestBlockOwners(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	tests := []struct {
+		block uint64
+		paths []string
+	}{
+		{3, nil},
+		{4, []string{"/testfile.txt"}},
+		{5, []string{"/dir/file"}},
+		{6, nil},
+	}
+
+	for _, tt := range tests {
+		owners, err := store.BlockOwners(ltfs.DataPartition, tt.block)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(owners) != len(tt.paths) {
+			t.Errorf("block %d: expected %v, got %v", tt.block, tt.paths, owners)
+			continue
+		}
+
+		for i, o := range owners {
+			if o.Path != tt.paths[i] || o.Extent.Block != tt.block {
+				t.Errorf("block %d: unexpected owner %v", tt.block, o)
+			}
+		}
+	}
+
+	if owners, _ := store.BlockOwners(ltfs.IndexPartition, 4); len(owners) != 0 {
+		t.Errorf("expected no owners on the index partition, got %v", owners)
+	}
+
+	if err := store.Remove("/testfile.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if owners, _ := store.BlockOwners(ltfs.DataPartition, 4); len(owners) != 0 {
+		t.Errorf("expected no owners after removal, got %v", owners)
+	}
+}
+
+func TestModifiedBetween(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	t0 := time.Time(testutil.TestTime)
+
+	refs, err := store.ModifiedBetween(t0, t0.Add(time.Nanosecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(refs) != 4 {
+		t.Errorf("expected 4 entries, got %v", refs)
+	}
+
+	if refs, _ := store.ModifiedBetween(t0.Add(-time.Hour), t0); len(refs) != 0 {
+		t.Errorf("expected no entries, got %v", refs)
+	}
+
+	begin := time.Now()
+	if err := store.Mkdir("/new"); err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err = store.ModifiedBetween(begin, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(refs) != 1 || refs[0].Path != "/new" {
+		t.Errorf("expected /new, got %v", refs)
+	}
+}
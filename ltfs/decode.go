@@ -0,0 +1,326 @@
+package ltfs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+// IndexDecoder decodes an LTFS index from a stream without building the
+// directory tree in memory. Entries are passed to the callbacks as they are
+// decoded, so memory use is bounded by the size of a single entry and the
+// depth of the tree.
+type IndexDecoder struct {
+	// Directory is called for each directory, including the root, once its
+	// metadata has been decoded and before any of its contents. The
+	// Contents of d is nil.
+	Directory func(path string, d *Directory) error
+
+	// File is called for each file (and symbolic link).
+	File func(path string, f *File) error
+}
+
+// Decode decodes the index read from r, calling the callbacks for the entries
+// in document order. The paths passed to the callbacks are absolute; the
+// root directory has the path "/". The returned index holds the metadata of
+// the root directory only.
+//
+// The metadata of a directory must precede its contents element, as it does
+// in indexes written by LTFS implementations.
+func (dec *IndexDecoder) Decode(r io.Reader) (*Index, error) {
+	d := xml.NewDecoder(r)
+
+	start, err := nextStart(d)
+	if err != nil {
+		return nil, err
+	}
+
+	if start.Name.Local != "ltfsindex" {
+		return nil, errors.Errorf("expected ltfsindex element, got %s", start.Name.Local)
+	}
+
+	// the preface is small; it is collected and decoded as a whole once the
+	// end of the index is reached
+	var preface bytes.Buffer
+	enc := xml.NewEncoder(&preface)
+
+	if err := enc.EncodeToken(start); err != nil {
+		return nil, err
+	}
+
+	var root *Directory
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode index")
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "directory" {
+				if err := copyElement(enc, d, t); err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+
+			if root != nil {
+				return nil, errors.New("index has more than one root directory")
+			}
+
+			if root, err = dec.decodeDirectory(d, t, ""); err != nil {
+				return nil, err
+			}
+
+		case xml.EndElement:
+			if err := enc.EncodeToken(t); err != nil {
+				return nil, err
+			}
+
+			if err := enc.Flush(); err != nil {
+				return nil, err
+			}
+
+			var idx Index
+			if err := xml.Unmarshal(preface.Bytes(), &idx); err != nil {
+				return nil, errors.Wrap(err, "failed to decode index preface")
+			}
+
+			if root == nil {
+				return nil, errors.New("index has no root directory")
+			}
+
+			idx.Root = root
+
+			return &idx, nil
+		}
+	}
+}
+
+// DecodePreface decodes the preface of the index read from r, stopping at the
+// root directory, so only the beginning of the index is read. Preface elements
+// following the root directory are not included.
+func DecodePreface(r io.Reader) (*IndexPreface, error) {
+	d := xml.NewDecoder(r)
+
+	start, err := nextStart(d)
+	if err != nil {
+		return nil, err
+	}
+
+	if start.Name.Local != "ltfsindex" {
+		return nil, errors.Errorf("expected ltfsindex element, got %s", start.Name.Local)
+	}
+
+	var preface bytes.Buffer
+	enc := xml.NewEncoder(&preface)
+
+	if err := enc.EncodeToken(start); err != nil {
+		return nil, err
+	}
+
+	for {
+		t, err := nextStart(d)
+		if err != nil {
+			return nil, err
+		}
+
+		if t.Name.Local == "directory" {
+			break
+		}
+
+		if err := copyElement(enc, d, t); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := enc.EncodeToken(start.End()); err != nil {
+		return nil, err
+	}
+
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+
+	var idx Index
+	if err := xml.Unmarshal(preface.Bytes(), &idx); err != nil {
+		return nil, errors.Wrap(err, "failed to decode index preface")
+	}
+
+	return &idx.IndexPreface, nil
+}
+
+// decodeDirectory decodes the directory element started by start in the
+// directory parent ("" for the root directory) and returns its metadata.
+func (dec *IndexDecoder) decodeDirectory(d *xml.Decoder, start xml.StartElement, parent string) (*Directory, error) {
+	var meta bytes.Buffer
+	enc := xml.NewEncoder(&meta)
+
+	if err := enc.EncodeToken(start); err != nil {
+		return nil, err
+	}
+
+	var dir *Directory
+
+	// emit decodes the collected metadata and passes it on
+	emit := func() (string, error) {
+		if err := enc.EncodeToken(start.End()); err != nil {
+			return "", err
+		}
+
+		if err := enc.Flush(); err != nil {
+			return "", err
+		}
+
+		dir = &Directory{}
+		if err := xml.Unmarshal(meta.Bytes(), dir); err != nil {
+			return "", errors.Wrapf(err, "failed to decode directory in '%s'", parent)
+		}
+
+		p := "/"
+		if parent != "" {
+			p = path.Join(parent, dir.Name)
+		}
+
+		if dec.Directory != nil {
+			if err := dec.Directory(p, dir); err != nil {
+				return "", err
+			}
+		}
+
+		return p, nil
+	}
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode directory")
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if dir != nil {
+				return nil, errors.Errorf("unexpected %s element after contents of '%s'", t.Name.Local, dir.Name)
+			}
+
+			if t.Name.Local != "contents" {
+				if err := copyElement(enc, d, t); err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+
+			p, err := emit()
+			if err != nil {
+				return nil, err
+			}
+
+			if err := dec.decodeContents(d, p); err != nil {
+				return nil, err
+			}
+
+		case xml.EndElement:
+			// a directory without a contents element
+			if dir == nil {
+				if _, err := emit(); err != nil {
+					return nil, err
+				}
+			}
+
+			return dir, nil
+		}
+	}
+}
+
+// decodeContents decodes the entries of the directory at dirPath up to the
+// end of the contents element.
+func (dec *IndexDecoder) decodeContents(d *xml.Decoder, dirPath string) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return errors.Wrapf(err, "failed to decode contents of '%s'", dirPath)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "file":
+				var f File
+				if err := d.DecodeElement(&f, &t); err != nil {
+					return errors.Wrapf(err, "failed to decode file in '%s'", dirPath)
+				}
+
+				if dec.File != nil {
+					if err := dec.File(path.Join(dirPath, f.Name), &f); err != nil {
+						return err
+					}
+				}
+
+			case "directory":
+				if _, err := dec.decodeDirectory(d, t, dirPath); err != nil {
+					return err
+				}
+
+			default:
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			}
+
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// nextStart returns the next start element of the stream.
+func nextStart(d *xml.Decoder) (xml.StartElement, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return xml.StartElement{}, errors.Wrap(err, "failed to decode index")
+		}
+
+		if t, ok := tok.(xml.StartElement); ok {
+			return t, nil
+		}
+	}
+}
+
+// copyElement copies the element started by start from d to enc.
+func copyElement(enc *xml.Encoder, d *xml.Decoder, start xml.StartElement) error {
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	for depth := 1; depth > 0; {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+
+		switch tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+		default:
+			// comments, processing instructions and directives carry no
+			// index data
+			continue
+		}
+
+		if err := enc.EncodeToken(tok); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package ltfs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIndexDecoder(t *testing.T) {
+	idx0 := makeTestIndex()
+
+	buf, err := xml.MarshalIndent(idx0, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	dec := IndexDecoder{
+		Directory: func(path string, d *Directory) error {
+			if d.Contents != nil {
+				t.Errorf("%s: directory passed with contents", path)
+			}
+
+			paths = append(paths, path+"/")
+			return nil
+		},
+
+		File: func(path string, f *File) error {
+			if len(f.ExtentInfo) != 1 {
+				t.Errorf("%s: expected one extent", path)
+			}
+
+			paths = append(paths, path)
+			return nil
+		},
+	}
+
+	idx1, err := dec.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"//", "/testfile.txt", "/directory1/", "/directory1/subdir1/"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected entries %v, got %v", expected, paths)
+	}
+
+	if !reflect.DeepEqual(idx0.IndexPreface, idx1.IndexPreface) {
+		t.Errorf("preface mismatch: expected %+v, got %+v", idx0.IndexPreface, idx1.IndexPreface)
+	}
+
+	if idx1.Root.Name != idx0.Root.Name || idx1.Root.FileUID != idx0.Root.FileUID {
+		t.Errorf("unexpected root directory %+v", idx1.Root)
+	}
+}
+
+func TestIndexDecoderErrors(t *testing.T) {
+	tests := map[string]string{
+		"not an index": `<ltfslabel></ltfslabel>`,
+		"no root":      `<ltfsindex version="2.4.0"><creator>x</creator></ltfsindex>`,
+		"truncated":    `<ltfsindex version="2.4.0"><directory><name>root</name><contents>`,
+		"metadata after contents": `<ltfsindex version="2.4.0"><directory>
+			<contents></contents><name>root</name></directory></ltfsindex>`,
+	}
+
+	for name, doc := range tests {
+		if _, err := (&IndexDecoder{}).Decode(strings.NewReader(doc)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestDecodePreface(t *testing.T) {
+	idx := makeTestIndex()
+
+	buf, err := xml.Marshal(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the beginning of the index is needed
+	preface, err := DecodePreface(bytes.NewReader(buf[:bytes.Index(buf, []byte("<directory>"))+20]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(*preface, idx.IndexPreface) {
+		t.Errorf("expected preface %+v, got %+v", idx.IndexPreface, *preface)
+	}
+
+	if _, err := DecodePreface(strings.NewReader(`<ltfslabel></ltfslabel>`)); err == nil {
+		t.Error("expected error for a label")
+	}
+}
@@ -10,16 +10,17 @@ import (
 
 // Directory represents an LTFS directory construct.
 type Directory struct {
-	XMLName      xml.Name     `xml:"directory"`
-	FileUID      int          `xml:"fileuid"`
-	Name         string       `xml:"name"`
-	CreationTime xmlutil.Time `xml:"creationtime"`
-	ChangeTime   xmlutil.Time `xml:"changetime"`
-	ModifyTime   xmlutil.Time `xml:"modifytime"`
-	AccessTime   xmlutil.Time `xml:"accesstime"`
-	BackupTime   xmlutil.Time `xml:"backuptime"`
-	ReadOnly     bool         `xml:"readonly,omitempty"`
-	Contents     *Contents    `xml:"contents"`
+	XMLName            xml.Name           `xml:"directory"`
+	FileUID            int                `xml:"fileuid"`
+	Name               string             `xml:"name"`
+	CreationTime       xmlutil.Time       `xml:"creationtime"`
+	ChangeTime         xmlutil.Time       `xml:"changetime"`
+	ModifyTime         xmlutil.Time       `xml:"modifytime"`
+	AccessTime         xmlutil.Time       `xml:"accesstime"`
+	BackupTime         xmlutil.Time       `xml:"backuptime"`
+	ReadOnly           bool               `xml:"readonly,omitempty"`
+	ExtendedAttributes ExtendedAttributes `xml:"extendedattributes,omitempty"`
+	Contents           *Contents          `xml:"contents"`
 }
 
 // Contents is the structure that contains the Files and Directories of a given
@@ -51,3 +52,24 @@ func (d *Directory) visitAll(fn func(*Directory, string), subtree string) {
 func (d *Directory) VisitAllEntries(fn func(d *Directory, subtree string)) {
 	d.visitAll(fn, "")
 }
+
+// VisitAllFiles calls fn sequentially for every file (including symbolic
+// links) in the tree rooted at d with the absolute path of the file. The path
+// of d itself is "/".
+func (d *Directory) VisitAllFiles(fn func(f *File, path string)) {
+	d.visitAllFiles(fn, "/")
+}
+
+func (d *Directory) visitAllFiles(fn func(*File, string), path string) {
+	if d.Contents == nil {
+		return
+	}
+
+	for _, f := range d.Contents.Files {
+		fn(f, filepath.Join(path, f.Name))
+	}
+
+	for _, dir := range d.Contents.Directories {
+		dir.visitAllFiles(fn, filepath.Join(path, dir.Name))
+	}
+}
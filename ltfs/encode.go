@@ -0,0 +1,171 @@
+package ltfs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// IndexEncoder writes the directory tree of an LTFS index one entry at a
+// time, so the tree never has to be held in memory. Entries are converted to
+// the format version of the index being written (see ConvertVersion).
+type IndexEncoder struct {
+	e    *xml.Encoder
+	v    FormatVersion
+	used map[Feature]bool
+
+	// number of open directories
+	depth int
+	root  bool
+}
+
+// streamIndex is an index whose directory tree is produced by a function.
+type streamIndex struct {
+	XMLName xml.Name `xml:"ltfsindex"`
+	IndexPreface
+	Root treeFunc `xml:"directory"`
+}
+
+type treeFunc func(e *xml.Encoder) error
+
+func (fn treeFunc) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	return fn(e)
+}
+
+// EncodeIndex writes an LTFS index document with the given preface to w. The
+// directory tree is written by fn, which must write exactly one (root)
+// directory using the encoder. The index is written in the format version of
+// the preface; features not available in that version are left out and
+// returned.
+func EncodeIndex(w io.Writer, preface IndexPreface, fn func(enc *IndexEncoder) error) ([]Feature, error) {
+	v, err := ParseVersion(preface.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := &IndexEncoder{
+		v:    v,
+		used: make(map[Feature]bool),
+	}
+
+	preface.convertVersion(v, enc.used)
+
+	idx := streamIndex{
+		IndexPreface: preface,
+		Root: func(e *xml.Encoder) error {
+			enc.e = e
+
+			if err := fn(enc); err != nil {
+				return err
+			}
+
+			if !enc.root || enc.depth != 0 {
+				return errors.New("incomplete directory tree")
+			}
+
+			return nil
+		},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return nil, err
+	}
+
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+
+	if err := e.Encode(idx); err != nil {
+		return nil, err
+	}
+
+	return droppedFeatures(enc.used), nil
+}
+
+// StartDirectory writes the metadata of d (but not its contents) and opens
+// its contents. Entries written until the matching EndDirectory are placed in
+// the directory.
+func (enc *IndexEncoder) StartDirectory(d *Directory) error {
+	if enc.depth == 0 && enc.root {
+		return errors.New("index has more than one root directory")
+	}
+
+	meta := *d
+	meta.Name = convertName(meta.Name, enc.v, enc.used)
+	meta.Contents = nil
+
+	// the metadata is marshalled on its own and copied without the end of
+	// the directory element
+	buf, err := xml.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	d2 := xml.NewDecoder(bytes.NewReader(buf))
+
+	start, err := nextStart(d2)
+	if err != nil {
+		return err
+	}
+
+	if err := enc.e.EncodeToken(start); err != nil {
+		return err
+	}
+
+	for {
+		tok, err := d2.Token()
+		if err != nil {
+			return err
+		}
+
+		t, ok := tok.(xml.StartElement)
+		if !ok {
+			// the end of the directory element
+			if _, ok := tok.(xml.EndElement); ok {
+				break
+			}
+
+			continue
+		}
+
+		if err := copyElement(enc.e, d2, t); err != nil {
+			return err
+		}
+	}
+
+	enc.depth++
+	enc.root = true
+
+	return enc.e.EncodeToken(xml.StartElement{Name: xml.Name{Local: "contents"}})
+}
+
+// EndDirectory closes the directory opened by the last StartDirectory.
+func (enc *IndexEncoder) EndDirectory() error {
+	if enc.depth == 0 {
+		return errors.New("no open directory")
+	}
+
+	enc.depth--
+
+	if err := enc.e.EncodeToken(xml.EndElement{Name: xml.Name{Local: "contents"}}); err != nil {
+		return err
+	}
+
+	return enc.e.EncodeToken(xml.EndElement{Name: xml.Name{Local: "directory"}})
+}
+
+// File writes f to the open directory. Symbolic links are left out if the
+// format version does not support them.
+func (enc *IndexEncoder) File(f *File) error {
+	if enc.depth == 0 {
+		return errors.New("file outside of a directory")
+	}
+
+	c := *f
+	if !c.convertVersion(enc.v, enc.used) {
+		return nil
+	}
+
+	return enc.e.EncodeElement(c, xml.StartElement{Name: xml.Name{Local: "file"}})
+}
@@ -0,0 +1,110 @@
+package ltfs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"testing"
+)
+
+// encodeTree writes d and its contents using enc.
+func encodeTree(enc *IndexEncoder, d *Directory) error {
+	if err := enc.StartDirectory(d); err != nil {
+		return err
+	}
+
+	if d.Contents == nil {
+		return enc.EndDirectory()
+	}
+
+	for _, f := range d.Contents.Files {
+		if err := enc.File(f); err != nil {
+			return err
+		}
+	}
+
+	for _, dir := range d.Contents.Directories {
+		if err := encodeTree(enc, dir); err != nil {
+			return err
+		}
+	}
+
+	return enc.EndDirectory()
+}
+
+func TestEncodeIndex(t *testing.T) {
+	idx := makeTestIndex()
+
+	// the encoder always writes a contents element
+	idx.Root.Contents.Directories[0].Contents.Directories[0].Contents = &Contents{}
+
+	expected, err := xml.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	dropped, err := EncodeIndex(&buf, idx.IndexPreface, func(enc *IndexEncoder) error {
+		return encodeTree(enc, idx.Root)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dropped) != 0 {
+		t.Errorf("unexpected dropped features %v", dropped)
+	}
+
+	if got := buf.String(); got != xml.Header+string(expected) {
+		fmt.Printf("EXPECTED:\n%s\n", expected)
+		fmt.Println()
+		fmt.Printf("GOT:\n%s\n", got)
+
+		t.Error("expected != got")
+	}
+}
+
+func TestEncodeIndexVersion(t *testing.T) {
+	idx := makeTestIndex()
+	idx.Version = "2.2.0"
+	idx.Root.Contents.Files = append(idx.Root.Contents.Files, &File{
+		FileUID: 5,
+		Name:    "link",
+		Symlink: "testfile.txt",
+	})
+
+	var buf bytes.Buffer
+	dropped, err := EncodeIndex(&buf, idx.IndexPreface, func(enc *IndexEncoder) error {
+		return encodeTree(enc, idx.Root)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dropped) != 1 || dropped[0] != FeatureSymlinks {
+		t.Errorf("expected symlinks to be dropped, got %v", dropped)
+	}
+
+	var idx1 Index
+	if err := xml.Unmarshal(buf.Bytes(), &idx1); err != nil {
+		t.Fatal(err)
+	}
+
+	if idx1.Version != "2.2.0" || len(idx1.Root.Contents.Files) != 1 {
+		t.Errorf("unexpected index %+v", idx1)
+	}
+}
+
+func TestEncodeIndexIncomplete(t *testing.T) {
+	idx := makeTestIndex()
+
+	_, err := EncodeIndex(&bytes.Buffer{}, idx.IndexPreface, func(enc *IndexEncoder) error {
+		return enc.StartDirectory(idx.Root)
+	})
+
+	if err == nil {
+		t.Error("expected error for unterminated directory")
+	}
+}
@@ -8,23 +8,52 @@ import (
 
 // File represents an LTFS file construct.
 type File struct {
-	XMLName            xml.Name             `xml:"file"`
-	FileUID            int                  `xml:"fileuid"`
-	Name               string               `xml:"name"`
-	Length             int                  `xml:"length"`
-	CreationTime       xmlutil.Time         `xml:"creationtime"`
-	ChangeTime         xmlutil.Time         `xml:"changetime"`
-	ModifyTime         xmlutil.Time         `xml:"modifytime"`
-	AccessTime         xmlutil.Time         `xml:"accesstime"`
-	BackupTime         xmlutil.Time         `xml:"backuptime"`
-	ReadOnly           bool                 `xml:"readonly"`
-	ExtendedAttributes []*ExtendedAttribute `xml:"extendedattributes"`
-	ExtentInfo         []*Extent            `xml:"extentinfo>extent"`
+	XMLName            xml.Name           `xml:"file"`
+	FileUID            int                `xml:"fileuid"`
+	Name               string             `xml:"name"`
+	Length             int                `xml:"length"`
+	CreationTime       xmlutil.Time       `xml:"creationtime"`
+	ChangeTime         xmlutil.Time       `xml:"changetime"`
+	ModifyTime         xmlutil.Time       `xml:"modifytime"`
+	AccessTime         xmlutil.Time       `xml:"accesstime"`
+	BackupTime         xmlutil.Time       `xml:"backuptime"`
+	ReadOnly           bool               `xml:"readonly"`
+	OpenForWrite       bool               `xml:"openforwrite,omitempty"`
+	ExtendedAttributes ExtendedAttributes `xml:"extendedattributes,omitempty"`
+	Symlink            string             `xml:"symlink,omitempty"`
+	ExtentInfo         ExtentInfo         `xml:"extentinfo,omitempty"`
 }
 
-// ExtendedAttribute represents files extended attributes. Currently not
-// implemented/supported.
-type ExtendedAttribute struct{}
+// IsSymlink reports whether the file is a symbolic link. Symbolic links are
+// supported from LTFS 2.3.0.
+func (f *File) IsSymlink() bool {
+	return f.Symlink != ""
+}
+
+// ExtentInfo represents the LTFS extentinfo construct. The construct is
+// omitted if the file has no extents (as required for symbolic links).
+type ExtentInfo []*Extent
+
+type extentList struct {
+	Extents []*Extent `xml:"extent"`
+}
+
+// MarshalXML implements xml.Marshaler.
+func (es ExtentInfo) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(extentList{es}, start)
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (es *ExtentInfo) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var lst extentList
+	if err := d.DecodeElement(&lst, &start); err != nil {
+		return err
+	}
+
+	*es = append(*es, lst.Extents...)
+
+	return nil
+}
 
 // Extent represents an LTFS extent construct.
 type Extent struct {
@@ -97,3 +97,62 @@ func TestFile(t *testing.T) {
 		t.Error("file0 != file1")
 	}
 }
+
+func TestSymlink(t *testing.T) {
+	xmlExpected := `<file>
+  <fileuid>5</fileuid>
+  <name>link</name>
+  <length>0</length>
+  <creationtime>2017-03-07T13:27:38.192689471Z</creationtime>
+  <changetime>2017-03-07T13:27:38.192689471Z</changetime>
+  <modifytime>2017-03-07T13:27:38.192689471Z</modifytime>
+  <accesstime>2017-03-07T13:27:38.192689471Z</accesstime>
+  <backuptime>2017-03-07T13:27:38.192689471Z</backuptime>
+  <readonly>false</readonly>
+  <symlink>dir/testfile.txt</symlink>
+</file>`
+
+	file0 := File{
+		XMLName:      xml.Name{Space: "", Local: "file"},
+		FileUID:      5,
+		Name:         "link",
+		CreationTime: testutil.TestTime,
+		ChangeTime:   testutil.TestTime,
+		ModifyTime:   testutil.TestTime,
+		AccessTime:   testutil.TestTime,
+		BackupTime:   testutil.TestTime,
+		Symlink:      "dir/testfile.txt",
+	}
+
+	buf, err := xml.MarshalIndent(file0, "", "  ")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if xmlExpected != string(buf) {
+		fmt.Printf("EXPECTED:\n%s\n", xmlExpected)
+		fmt.Println()
+		fmt.Printf("GOT:\n%s\n", string(buf))
+
+		t.Error("xmlExpected != buf")
+	}
+
+	file1 := File{}
+
+	if err := xml.Unmarshal(buf, &file1); err != nil {
+		t.Error(err)
+	}
+
+	if !file1.IsSymlink() {
+		t.Error("expected a symbolic link")
+	}
+
+	if !reflect.DeepEqual(file0, file1) {
+		fmt.Println("EXPECTED:")
+		pretty.Println(file0)
+		fmt.Println("GOT:")
+		pretty.Println(file1)
+
+		t.Error("file0 != file1")
+	}
+}
@@ -2,7 +2,7 @@ package ltfs
 
 import (
 	"encoding/xml"
-	"io/ioutil"
+	"os"
 
 	"github.com/google/uuid"
 	"hpt.space/bltfs/util/xmlutil"
@@ -28,9 +28,17 @@ type IndexPreface struct {
 	PreviousGeneration
 	AllowPolicyUpdate bool `xml:"allowpolicyupdate"`
 	DataPlacementPolicy
-	HighestFileUID int `xml:"highestfileuid"`
+	HighestFileUID  int    `xml:"highestfileuid"`
+	VolumeLockState string `xml:"volumelockstate,omitempty"`
 }
 
+// Volume lock states (LTFS 2.4.0 and later).
+const (
+	VolumeUnlocked   = "unlocked"
+	VolumeLocked     = "locked"
+	VolumePermLocked = "permlocked"
+)
+
 // DataPlacementPolicy represents the LTFS dataplacementpolicy tag.
 type DataPlacementPolicy struct {
 	Size int      `xml:"dataplacementpolicy>indexpartitioncriteria>size"`
@@ -43,17 +51,19 @@ type PreviousGeneration struct {
 	StartBlock int    `xml:"previousgenerationlocation>startblock"`
 }
 
-// LoadIndexFromFile loads an LTFS index from a file.
+// LoadIndexFromFile loads an LTFS index from a file. The directory tree is
+// held in memory; use an IndexDecoder to process large indexes.
 func LoadIndexFromFile(path string) (*Index, error) {
-	buf, err := ioutil.ReadFile(path)
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
 	var idx Index
 
-	// unmarshal the LTFS index
-	if err := xml.Unmarshal(buf, &idx); err != nil {
+	// decode the LTFS index without reading the whole file first
+	if err := xml.NewDecoder(f).Decode(&idx); err != nil {
 		return nil, err
 	}
 
@@ -38,7 +38,7 @@ func makeTestIndex() Index {
 }
 
 func TestLTFSIndex(t *testing.T) {
-	xmlExpected := `<ltfsindex version="2.2.0">
+	xmlExpected := `<ltfsindex version="2.4.0">
   <creator>hpt.space bLTFS 0.0.1</creator>
   <volumeuuid>df925be0-44c0-4e49-af6a-7c3aa5b36d35</volumeuuid>
   <generationnumber>3</generationnumber>
@@ -35,7 +35,7 @@ func makeTestLabel() LabelLTFS {
 }
 
 func TestLTFSLabel(t *testing.T) {
-	xmlExpected := `<ltfslabel version="2.2.0">
+	xmlExpected := `<ltfslabel version="2.4.0">
   <creator>hpt.space bLTFS 0.0.1</creator>
   <formattime>2017-03-07T13:27:38.192689471Z</formattime>
   <volumeuuid>df925be0-44c0-4e49-af6a-7c3aa5b36d35</volumeuuid>
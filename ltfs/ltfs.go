@@ -5,6 +5,7 @@ const (
 	// Creator is used to identify the LTFS creator.
 	Creator = "hpt.space bLTFS 0.0.1"
 
-	// Version is the implemented version of LTFS.
-	Version = "2.2.0"
+	// Version is the implemented version of LTFS. Indexes of older versions
+	// (down to MinVersion) can be read and written as well.
+	Version = "2.4.0"
 )
@@ -0,0 +1,270 @@
+package ltfs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// name is the LTFS name construct as recorded in the index. From LTFS 2.3.0
+// names may be percent-encoded.
+type name struct {
+	PercentEncoded bool   `xml:"percentencoded,attr,omitempty"`
+	Value          string `xml:",chardata"`
+}
+
+// EncodeName returns the representation of s in an LTFS name construct. If s
+// contains characters that cannot be represented in XML, those characters
+// (and any percent signs) are percent-encoded and encoded is true.
+func EncodeName(s string) (value string, encoded bool) {
+	if !needsEncoding(s) {
+		return s, false
+	}
+
+	var buf strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == '%' || !validXMLRune(r, size) {
+			for _, b := range []byte(s[i : i+size]) {
+				fmt.Fprintf(&buf, "%%%02X", b)
+			}
+		} else {
+			buf.WriteString(s[i : i+size])
+		}
+
+		i += size
+	}
+
+	return buf.String(), true
+}
+
+// DecodeName returns the name represented by the value of an LTFS name
+// construct.
+func DecodeName(value string, encoded bool) (string, error) {
+	if !encoded {
+		return value, nil
+	}
+
+	var buf []byte
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' {
+			buf = append(buf, value[i])
+			continue
+		}
+
+		if i+2 >= len(value) || !isHex(value[i+1]) || !isHex(value[i+2]) {
+			return "", errors.Errorf("invalid percent-encoded name %q", value)
+		}
+
+		buf = append(buf, unhex(value[i+1])<<4|unhex(value[i+2]))
+		i += 2
+	}
+
+	s := string(buf)
+	if strings.ContainsAny(s, "/\x00") {
+		return "", errors.Errorf("invalid character in percent-encoded name %q", value)
+	}
+
+	return s, nil
+}
+
+// NormalizeName returns the form of s used when comparing LTFS names, that is,
+// the NFC normalization of s.
+func NormalizeName(s string) string {
+	return norm.NFC.String(s)
+}
+
+// FoldName returns the form of s used when comparing LTFS names without
+// regard to case.
+func FoldName(s string) string {
+	return norm.NFC.String(cases.Fold().String(norm.NFC.String(s)))
+}
+
+// needsEncoding reports whether s contains characters that must be
+// percent-encoded.
+func needsEncoding(s string) bool {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if !validXMLRune(r, size) {
+			return true
+		}
+
+		i += size
+	}
+
+	return false
+}
+
+// validXMLRune reports whether r is in the XML 1.0 Char production. size is
+// the encoded length of r, used to detect invalid UTF-8.
+func validXMLRune(r rune, size int) bool {
+	if r == utf8.RuneError && size == 1 {
+		return false
+	}
+
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+
+	return c - 'A' + 10
+}
+
+// marshalEncodedName encodes v (a struct with a name element) as start,
+// marking its name element as percent-encoded.
+func marshalEncodedName(e *xml.Encoder, start xml.StartElement, v interface{}) error {
+	buf, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(buf))
+
+	var depth int
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				t = start
+			}
+
+			if depth == 1 && t.Name.Local == "name" {
+				t.Attr = append(t.Attr, xml.Attr{
+					Name:  xml.Name{Local: "percentencoded"},
+					Value: "true",
+				})
+			}
+
+			depth++
+			tok = t
+
+		case xml.EndElement:
+			depth--
+
+			if depth == 0 {
+				tok = start.End()
+			}
+		}
+
+		if err := e.EncodeToken(xml.CopyToken(tok)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MarshalXML implements xml.Marshaler.
+func (f File) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	// the local type does not carry the methods of File
+	type Plain File
+
+	// the element name is fixed, as it would be by the XMLName field
+	start.Name = xml.Name{Local: "file"}
+
+	value, encoded := EncodeName(f.Name)
+	if !encoded {
+		return e.EncodeElement(Plain(f), start)
+	}
+
+	p := Plain(f)
+	p.Name = value
+
+	return marshalEncodedName(e, start, p)
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (f *File) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	// the local type does not carry the methods of File; it must be
+	// exported to be accessible to encoding/xml when embedded
+	type Plain File
+
+	var x struct {
+		Plain
+		Name name `xml:"name"`
+	}
+
+	if err := d.DecodeElement(&x, &start); err != nil {
+		return err
+	}
+
+	*f = File(x.Plain)
+	f.XMLName = start.Name
+
+	var err error
+	f.Name, err = DecodeName(x.Name.Value, x.Name.PercentEncoded)
+
+	return err
+}
+
+// MarshalXML implements xml.Marshaler.
+func (dir Directory) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	// the local type does not carry the methods of Directory
+	type Plain Directory
+
+	// the element name is fixed, as it would be by the XMLName field
+	start.Name = xml.Name{Local: "directory"}
+
+	value, encoded := EncodeName(dir.Name)
+	if !encoded {
+		return e.EncodeElement(Plain(dir), start)
+	}
+
+	p := Plain(dir)
+	p.Name = value
+
+	return marshalEncodedName(e, start, p)
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (dir *Directory) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	// the local type does not carry the methods of Directory; it must be
+	// exported to be accessible to encoding/xml when embedded
+	type Plain Directory
+
+	var x struct {
+		Plain
+		Name name `xml:"name"`
+	}
+
+	if err := d.DecodeElement(&x, &start); err != nil {
+		return err
+	}
+
+	*dir = Directory(x.Plain)
+	dir.XMLName = start.Name
+
+	var err error
+	dir.Name, err = DecodeName(x.Name.Value, x.Name.PercentEncoded)
+
+	return err
+}
@@ -0,0 +1,99 @@
+package ltfs
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestEncodeName(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		encoded bool
+	}{
+		{"plain.txt", "plain.txt", false},
+		{"100%", "100%", false},
+		{"a:b<c>&", "a:b<c>&", false},
+		{"æøå", "æøå", false},
+		{"bell\x07", "bell%07", true},
+		{"50%\x01", "50%25%01", true},
+		{"bad\xffutf8", "bad%FFutf8", true},
+	}
+
+	for _, tc := range tests {
+		value, encoded := EncodeName(tc.name)
+		if value != tc.value || encoded != tc.encoded {
+			t.Errorf("%q: got (%q, %v), expected (%q, %v)", tc.name, value, encoded, tc.value, tc.encoded)
+		}
+
+		name, err := DecodeName(value, encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if name != tc.name {
+			t.Errorf("%q: decoded to %q", tc.name, name)
+		}
+	}
+
+	for _, value := range []string{"%", "%4", "%zz", "a%2Fb", "%00"} {
+		if _, err := DecodeName(value, true); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestEncodedNameXML(t *testing.T) {
+	dir0 := Directory{
+		XMLName: xml.Name{Space: "", Local: "directory"},
+		FileUID: 1,
+		Name:    "dir\x01",
+		Contents: &Contents{
+			Files: []*File{
+				{XMLName: xml.Name{Space: "", Local: "file"}, FileUID: 2, Name: "tab\tand\x02"},
+				{XMLName: xml.Name{Space: "", Local: "file"}, FileUID: 3, Name: "plain"},
+			},
+		},
+	}
+
+	buf, err := xml.Marshal(dir0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		`<directory><fileuid>1</fileuid><name percentencoded="true">dir%01</name>`,
+		`<name percentencoded="true">tab&#x9;and%02</name>`,
+		`<name>plain</name>`,
+	} {
+		if !strings.Contains(string(buf), s) {
+			t.Errorf("expected %s in %s", s, buf)
+		}
+	}
+
+	var dir1 Directory
+	if err := xml.Unmarshal(buf, &dir1); err != nil {
+		t.Fatal(err)
+	}
+
+	if dir1.Name != dir0.Name || dir1.Contents.Files[0].Name != dir0.Contents.Files[0].Name {
+		t.Errorf("names not preserved: %q, %q", dir1.Name, dir1.Contents.Files[0].Name)
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	composed, decomposed := "é", "é"
+
+	if NormalizeName(decomposed) != composed {
+		t.Errorf("%q is not normalized to %q", decomposed, composed)
+	}
+
+	if FoldName("Caf"+decomposed) != FoldName("CAF"+composed) {
+		t.Error("expected names to fold to the same form")
+	}
+
+	if NormalizeName("File") == NormalizeName("file") {
+		t.Error("normalization must preserve case")
+	}
+}
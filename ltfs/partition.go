@@ -0,0 +1,81 @@
+package ltfs
+
+import "github.com/pkg/errors"
+
+// Physical partition numbers. The index partition is always the first
+// physical partition and the data partition the second, regardless of the
+// partition identifiers assigned to them by the LTFS label.
+const (
+	IndexPartition uint32 = 0
+	DataPartition  uint32 = 1
+)
+
+// PartitionMap maps the LTFS partition identifiers (as recorded in the LTFS
+// label) to physical partition numbers.
+type PartitionMap struct {
+	Index string
+	Data  string
+}
+
+// DefaultPartitionMap is the partition layout used when formatting new
+// volumes.
+var DefaultPartitionMap = PartitionMap{
+	Index: "a",
+	Data:  "b",
+}
+
+// NewPartitionMap returns a new PartitionMap for the given index and data
+// partition identifiers.
+func NewPartitionMap(index, data string) (PartitionMap, error) {
+	if !validPartitionID(index) {
+		return PartitionMap{}, errors.Errorf("invalid index partition identifier %q", index)
+	}
+
+	if !validPartitionID(data) {
+		return PartitionMap{}, errors.Errorf("invalid data partition identifier %q", data)
+	}
+
+	if index == data {
+		return PartitionMap{}, errors.Errorf("index and data partition identifiers are both %q", index)
+	}
+
+	return PartitionMap{
+		Index: index,
+		Data:  data,
+	}, nil
+}
+
+// PartitionMap returns the PartitionMap described by the label.
+func (l *LabelLTFS) PartitionMap() (PartitionMap, error) {
+	return NewPartitionMap(l.IndexPartition, l.DataPartition)
+}
+
+// Number returns the physical partition number of the partition identified
+// by id.
+func (m PartitionMap) Number(id string) (uint32, error) {
+	switch id {
+	case m.Index:
+		return IndexPartition, nil
+	case m.Data:
+		return DataPartition, nil
+	}
+
+	return 0, errors.Errorf("unknown partition identifier %q", id)
+}
+
+// ID returns the partition identifier of the physical partition num.
+func (m PartitionMap) ID(num uint32) (string, error) {
+	switch num {
+	case IndexPartition:
+		return m.Index, nil
+	case DataPartition:
+		return m.Data, nil
+	}
+
+	return "", errors.Errorf("unknown partition number %d", num)
+}
+
+// partition identifiers are single lower case letters
+func validPartitionID(id string) bool {
+	return len(id) == 1 && id[0] >= 'a' && id[0] <= 'z'
+}
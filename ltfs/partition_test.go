@@ -0,0 +1,65 @@
+package ltfs
+
+import "testing"
+
+func TestPartitionMap(t *testing.T) {
+	tests := []struct {
+		index, data string
+		valid       bool
+	}{
+		{"a", "b", true},
+		{"b", "a", true},
+		{"x", "y", true},
+		{"a", "a", false},
+		{"", "b", false},
+		{"a", "B", false},
+		{"ab", "c", false},
+	}
+
+	for _, tc := range tests {
+		label := makeTestLabel()
+		label.IndexPartition = tc.index
+		label.DataPartition = tc.data
+
+		pmap, err := label.PartitionMap()
+		if !tc.valid {
+			if err == nil {
+				t.Errorf("%q/%q: expected an error", tc.index, tc.data)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%q/%q: %v", tc.index, tc.data, err)
+		}
+
+		for id, num := range map[string]uint32{tc.index: IndexPartition, tc.data: DataPartition} {
+			n, err := pmap.Number(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n != num {
+				t.Errorf("Number(%q) = %d, expected %d", id, n, num)
+			}
+
+			s, err := pmap.ID(num)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if s != id {
+				t.Errorf("ID(%d) = %q, expected %q", num, s, id)
+			}
+		}
+
+		if _, err := pmap.Number("z"); err == nil && tc.index != "z" && tc.data != "z" {
+			t.Error("expected an error for unknown partition identifier")
+		}
+
+		if _, err := pmap.ID(2); err == nil {
+			t.Error("expected an error for unknown partition number")
+		}
+	}
+}
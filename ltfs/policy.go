@@ -0,0 +1,61 @@
+package ltfs
+
+// Match reports whether a file with the given name and size satisfies the
+// index partition criteria of the policy and should be written to the index
+// partition. Files match if their size is at most Size and their name matches
+// one of the Name patterns (or there are no patterns). Patterns may contain
+// the wildcards '*' (any sequence of characters) and '?' (any single
+// character) and are matched without regard to case. A zero Size matches no
+// files.
+func (pol DataPlacementPolicy) Match(name string, size int64) bool {
+	if pol.Size <= 0 || size > int64(pol.Size) {
+		return false
+	}
+
+	if len(pol.Name) == 0 {
+		return true
+	}
+
+	folded := []rune(FoldName(name))
+
+	for _, pattern := range pol.Name {
+		if matchPattern([]rune(FoldName(pattern)), folded) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchPattern reports whether name matches the wildcard pattern.
+func matchPattern(pattern, name []rune) bool {
+	// position to resume from after the last '*'
+	star, next := -1, 0
+
+	var p, n int
+	for n < len(name) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, n
+			p++
+
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == name[n]):
+			p++
+			n++
+
+		case star >= 0:
+			// let the last '*' consume one more character
+			next++
+			p, n = star+1, next
+
+		default:
+			return false
+		}
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
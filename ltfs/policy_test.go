@@ -0,0 +1,44 @@
+package ltfs
+
+import "testing"
+
+func TestDataPlacementPolicyMatch(t *testing.T) {
+	pol := DataPlacementPolicy{
+		Size: 1024,
+		Name: []string{"*.txt", "README", "img??.*"},
+	}
+
+	tests := []struct {
+		name  string
+		size  int64
+		match bool
+	}{
+		{"notes.txt", 10, true},
+		{"NOTES.TXT", 10, true},
+		{"notes.txt", 1024, true},
+		{"notes.txt", 1025, false},
+		{".txt", 0, true},
+		{"notes.txt.gz", 10, false},
+		{"readme", 10, true},
+		{"README.md", 10, false},
+		{"img01.jpg", 10, true},
+		{"img1.jpg", 10, false},
+		{"data.bin", 10, false},
+	}
+
+	for _, tt := range tests {
+		if got := pol.Match(tt.name, tt.size); got != tt.match {
+			t.Errorf("Match(%q, %d) = %v, want %v", tt.name, tt.size, got, tt.match)
+		}
+	}
+
+	// without name patterns, only the size is considered
+	if !(DataPlacementPolicy{Size: 10}).Match("anything", 10) {
+		t.Error("expected file to match size-only policy")
+	}
+
+	// a zero size matches nothing
+	if (DataPlacementPolicy{Name: []string{"*"}}).Match("empty", 0) {
+		t.Error("expected zero size policy to match nothing")
+	}
+}
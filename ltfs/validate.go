@@ -0,0 +1,390 @@
+package ltfs
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"hpt.space/bltfs/util/xmlutil"
+)
+
+// maxNameLength is the maximum length of a name in characters.
+const maxNameLength = 255
+
+// Rule identifies the LTFS format rule broken by a Violation.
+type Rule int
+
+const (
+	// RulePreface covers the version, volume UUID, generation number and
+	// volume lock state of the index.
+	RulePreface Rule = iota
+
+	// RuleLocation covers the location and previous generation location of
+	// the index.
+	RuleLocation
+
+	// RuleUID requires file UIDs to be unique, positive and at most the
+	// highest file UID of the index.
+	RuleUID
+
+	// RuleName requires names to be legal and unique within a directory.
+	RuleName
+
+	// RuleTime requires timestamps to be representable in the ISO 8601 UTC
+	// form of xmlutil.FormatISO8601.
+	RuleTime
+
+	// RuleExtent requires extents to be on a valid partition, sorted by file
+	// offset, non-overlapping and within the length of the file.
+	RuleExtent
+
+	// RuleSymlink requires symbolic links to have a target and no data.
+	RuleSymlink
+
+	// RuleXattr requires extended attribute names to be present and unique.
+	RuleXattr
+)
+
+var rules = [...]string{
+	RulePreface:  "preface",
+	RuleLocation: "location",
+	RuleUID:      "uid",
+	RuleName:     "name",
+	RuleTime:     "time",
+	RuleExtent:   "extent",
+	RuleSymlink:  "symlink",
+	RuleXattr:    "xattr",
+}
+
+func (r Rule) String() string {
+	return rules[r]
+}
+
+// Violation is a breach of the LTFS format rules found in an index.
+type Violation struct {
+	Rule Rule
+
+	// Path is the path of the offending entry, or empty if the violation
+	// is in the index preface.
+	Path string
+
+	Msg string
+}
+
+func (v Violation) String() string {
+	if v.Path == "" {
+		return fmt.Sprintf("%s: %s", v.Rule, v.Msg)
+	}
+
+	return fmt.Sprintf("%s %s: %s", v.Rule, v.Path, v.Msg)
+}
+
+// Validate checks idx against the LTFS format rules and returns the
+// violations found. Regions of a file not covered by an extent are sparse and
+// not a violation.
+func Validate(idx *Index) []Violation {
+	v := newValidator()
+
+	if idx.Root == nil {
+		v.add(RulePreface, "", "index has no root directory")
+	} else {
+		v.directory("/", idx.Root)
+		v.tree("/", idx.Root)
+	}
+
+	v.preface(&idx.IndexPreface)
+
+	return v.violations
+}
+
+// ValidateStream checks the index read from r against the LTFS format rules
+// like Validate, without holding the directory tree in memory. An error is
+// returned if the index cannot be decoded at all; this includes timestamps
+// that are not in the ISO 8601 UTC form.
+func ValidateStream(r io.Reader) ([]Violation, error) {
+	v := newValidator()
+
+	dec := IndexDecoder{
+		Directory: func(p string, d *Directory) error {
+			v.directory(p, d)
+			return nil
+		},
+
+		File: func(p string, f *File) error {
+			v.file(p, f)
+			return nil
+		},
+	}
+
+	idx, err := dec.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	v.preface(&idx.IndexPreface)
+
+	return v.violations, nil
+}
+
+// timeElements are the names of the timestamps of an entry, in the order
+// passed to validator.entry.
+var timeElements = [...]string{"creationtime", "changetime", "modifytime", "accesstime", "backuptime"}
+
+type validator struct {
+	violations []Violation
+
+	// UIDs seen so far, as a bitmap
+	uids []uint64
+
+	// the entry with the largest UID
+	maxUID     int
+	maxUIDPath string
+
+	// the names in each directory on the path to the current entry
+	dirs []dirNames
+
+	// whether the root directory has been seen
+	root bool
+}
+
+type dirNames struct {
+	path  string
+	names map[string]bool
+}
+
+func newValidator() *validator {
+	return &validator{}
+}
+
+func (v *validator) add(rule Rule, p string, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{
+		Rule: rule,
+		Path: p,
+		Msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+// tree validates the contents of the in-memory directory d at p.
+func (v *validator) tree(p string, d *Directory) {
+	if d.Contents == nil {
+		return
+	}
+
+	for _, f := range d.Contents.Files {
+		v.file(path.Join(p, f.Name), f)
+	}
+
+	for _, dir := range d.Contents.Directories {
+		dp := path.Join(p, dir.Name)
+
+		v.directory(dp, dir)
+		v.tree(dp, dir)
+	}
+}
+
+func (v *validator) preface(p *IndexPreface) {
+	if _, err := ParseVersion(p.Version); err != nil {
+		v.add(RulePreface, "", "%v", err)
+	}
+
+	if p.VolumeUUID == (uuid.UUID{}) {
+		v.add(RulePreface, "", "missing volume UUID")
+	}
+
+	if p.Generation < 1 {
+		v.add(RulePreface, "", "invalid generation number %d", p.Generation)
+	}
+
+	switch p.VolumeLockState {
+	case "", VolumeUnlocked, VolumeLocked, VolumePermLocked:
+	default:
+		v.add(RulePreface, "", "invalid volume lock state %q", p.VolumeLockState)
+	}
+
+	v.time(RulePreface, "", "updatetime", p.UpdateTime)
+
+	if !validPartitionID(p.Partition) {
+		v.add(RuleLocation, "", "invalid partition %q", p.Partition)
+	}
+
+	// the index follows the label (VOL1 label, LTFS label and a filemark)
+	if p.StartBlock < 3 {
+		v.add(RuleLocation, "", "invalid start block %d", p.StartBlock)
+	}
+
+	prev := p.PreviousGeneration
+	switch {
+	case prev.Partition == "" && prev.StartBlock == 0:
+		// the first generation
+
+	case !validPartitionID(prev.Partition):
+		v.add(RuleLocation, "", "invalid previous generation partition %q", prev.Partition)
+
+	case prev.StartBlock < 3:
+		v.add(RuleLocation, "", "invalid previous generation start block %d", prev.StartBlock)
+
+	case prev.Partition == p.Partition && prev.StartBlock >= p.StartBlock:
+		v.add(RuleLocation, "", "previous generation at %s:%d does not precede the index at %s:%d", prev.Partition, prev.StartBlock, p.Partition, p.StartBlock)
+	}
+
+	if v.maxUID > p.HighestFileUID {
+		v.add(RuleUID, v.maxUIDPath, "file UID %d exceeds the highest file UID %d", v.maxUID, p.HighestFileUID)
+	}
+}
+
+// entry validates what is common to files and directories and records the
+// name of the entry in its directory.
+func (v *validator) entry(p, name string, uid int, times [5]xmlutil.Time, xattrs ExtendedAttributes) {
+	v.uid(p, uid)
+
+	for i, t := range times {
+		v.time(RuleTime, p, timeElements[i], t)
+	}
+
+	keys := make(map[string]bool, len(xattrs))
+	for _, x := range xattrs {
+		switch {
+		case x.Key == "":
+			v.add(RuleXattr, p, "extended attribute without a name")
+		case keys[x.Key]:
+			v.add(RuleXattr, p, "duplicate extended attribute %q", x.Key)
+		}
+
+		keys[x.Key] = true
+	}
+
+	// the root directory (the first entry) carries the volume name, which is
+	// not a path component
+	if !v.root {
+		v.root = true
+		return
+	}
+
+	v.name(p, name)
+
+	// leave the directories that do not contain the entry
+	parent := path.Dir(p)
+	for len(v.dirs) > 0 && v.dirs[len(v.dirs)-1].path != parent {
+		v.dirs = v.dirs[:len(v.dirs)-1]
+	}
+
+	if len(v.dirs) == 0 {
+		return
+	}
+
+	names := v.dirs[len(v.dirs)-1].names
+
+	key := NormalizeName(name)
+	if names[key] {
+		v.add(RuleName, p, "duplicate name in directory")
+	}
+
+	names[key] = true
+}
+
+func (v *validator) directory(p string, d *Directory) {
+	v.entry(p, d.Name, d.FileUID, [...]xmlutil.Time{
+		d.CreationTime, d.ChangeTime, d.ModifyTime, d.AccessTime, d.BackupTime,
+	}, d.ExtendedAttributes)
+
+	v.dirs = append(v.dirs, dirNames{path: p, names: make(map[string]bool)})
+}
+
+func (v *validator) file(p string, f *File) {
+	v.entry(p, f.Name, f.FileUID, [...]xmlutil.Time{
+		f.CreationTime, f.ChangeTime, f.ModifyTime, f.AccessTime, f.BackupTime,
+	}, f.ExtendedAttributes)
+
+	if f.Length < 0 {
+		v.add(RuleExtent, p, "negative length %d", f.Length)
+	}
+
+	if f.IsSymlink() {
+		if f.Length != 0 || len(f.ExtentInfo) > 0 {
+			v.add(RuleSymlink, p, "symbolic link has data")
+		}
+
+		return
+	}
+
+	end := 0
+	for i, ex := range f.ExtentInfo {
+		if !validPartitionID(ex.Partition) {
+			v.add(RuleExtent, p, "extent %d: invalid partition %q", i, ex.Partition)
+		}
+
+		if ex.StartBlock < 0 || ex.ByteOffset < 0 || ex.ByteCount <= 0 || ex.FileOffset < 0 {
+			v.add(RuleExtent, p, "extent %d: invalid extent %+v", i, *ex)
+			continue
+		}
+
+		if ex.FileOffset < end {
+			v.add(RuleExtent, p, "extent %d: file offset %d is not after the previous extent (ending at %d)", i, ex.FileOffset, end)
+		}
+
+		end = ex.FileOffset + ex.ByteCount
+	}
+
+	if end > f.Length {
+		v.add(RuleExtent, p, "extents end at %d beyond the length %d", end, f.Length)
+	}
+}
+
+func (v *validator) uid(p string, uid int) {
+	if uid < 1 {
+		v.add(RuleUID, p, "invalid file UID %d", uid)
+		return
+	}
+
+	i, bit := uid/64, uint64(1)<<uint(uid%64)
+	for len(v.uids) <= i {
+		v.uids = append(v.uids, 0)
+	}
+
+	if v.uids[i]&bit != 0 {
+		v.add(RuleUID, p, "duplicate file UID %d", uid)
+	}
+
+	v.uids[i] |= bit
+
+	if uid > v.maxUID {
+		v.maxUID, v.maxUIDPath = uid, p
+	}
+}
+
+func (v *validator) name(p, name string) {
+	switch {
+	case name == "":
+		v.add(RuleName, p, "empty name")
+	case name == "." || name == "..":
+		v.add(RuleName, p, "reserved name %q", name)
+	case !utf8.ValidString(name):
+		v.add(RuleName, p, "name is not valid UTF-8")
+	case utf8.RuneCountInString(name) > maxNameLength:
+		v.add(RuleName, p, "name longer than %d characters", maxNameLength)
+	}
+
+	for _, r := range name {
+		if r == '/' || r == 0 {
+			v.add(RuleName, p, "name contains %q", r)
+			break
+		}
+	}
+}
+
+func (v *validator) time(rule Rule, p, elem string, t xmlutil.Time) {
+	tt := time.Time(t)
+
+	if tt.Location() != time.UTC {
+		v.add(rule, p, "%s is not in UTC", elem)
+		return
+	}
+
+	if tt.Year() < 0 || tt.Year() > 9999 {
+		v.add(rule, p, "%s %s is out of range", elem, tt.Format(xmlutil.FormatISO8601))
+	}
+}
@@ -0,0 +1,114 @@
+package ltfs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"hpt.space/bltfs/util/xmlutil"
+)
+
+func TestValidate(t *testing.T) {
+	idx := makeTestIndex()
+
+	if vs := Validate(&idx); len(vs) != 0 {
+		t.Errorf("unexpected violations %v", vs)
+	}
+
+	tests := []struct {
+		name string
+		fn   func(idx *Index)
+		rule Rule
+		path string
+	}{
+		{"version", func(idx *Index) { idx.Version = "1.0" }, RulePreface, ""},
+		{"generation", func(idx *Index) { idx.Generation = 0 }, RulePreface, ""},
+		{"lock state", func(idx *Index) { idx.VolumeLockState = "sealed" }, RulePreface, ""},
+		{"partition", func(idx *Index) { idx.Partition = "A" }, RuleLocation, ""},
+		{"start block", func(idx *Index) { idx.StartBlock = 1 }, RuleLocation, ""},
+		{"previous generation", func(idx *Index) { idx.PreviousGeneration.Partition = "a" }, RuleLocation, ""},
+		{"highest uid", func(idx *Index) { idx.HighestFileUID = 3 }, RuleUID, "/testfile.txt"},
+		{"duplicate uid", func(idx *Index) { idx.Root.Contents.Files[0].FileUID = 2 }, RuleUID, "/directory1"},
+		{"zero uid", func(idx *Index) { idx.Root.Contents.Directories[0].FileUID = 0 }, RuleUID, "/directory1"},
+		{"empty name", func(idx *Index) { idx.Root.Contents.Files[0].Name = "" }, RuleName, "/"},
+		{"reserved name", func(idx *Index) { idx.Root.Contents.Files[0].Name = ".." }, RuleName, "/"},
+		{"duplicate name", func(idx *Index) { idx.Root.Contents.Files[0].Name = "directory1" }, RuleName, "/directory1"},
+		{"local time", func(idx *Index) {
+			idx.Root.Contents.Files[0].ModifyTime = xmlutil.Time(time.Time(idx.UpdateTime).In(time.FixedZone("CET", 3600)))
+		}, RuleTime, "/testfile.txt"},
+		{"extent partition", func(idx *Index) { idx.Root.Contents.Files[0].ExtentInfo[0].Partition = "" }, RuleExtent, "/testfile.txt"},
+		{"extent beyond length", func(idx *Index) { idx.Root.Contents.Files[0].Length = 4 }, RuleExtent, "/testfile.txt"},
+		{"overlapping extents", func(idx *Index) {
+			f := idx.Root.Contents.Files[0]
+			f.Length = 8
+			f.ExtentInfo = append(f.ExtentInfo, &Extent{Partition: "b", StartBlock: 10, ByteCount: 4, FileOffset: 4})
+		}, RuleExtent, "/testfile.txt"},
+		{"symlink data", func(idx *Index) { idx.Root.Contents.Files[0].Symlink = "target" }, RuleSymlink, "/testfile.txt"},
+		{"duplicate xattr", func(idx *Index) {
+			idx.Root.ExtendedAttributes = ExtendedAttributes{{Key: "user.a"}, {Key: "user.a"}}
+		}, RuleXattr, "/"},
+	}
+
+	for _, tt := range tests {
+		idx := makeTestIndex()
+		tt.fn(&idx)
+
+		vs := Validate(&idx)
+		if len(vs) != 1 {
+			t.Errorf("%s: expected one violation, got %v", tt.name, vs)
+			continue
+		}
+
+		if vs[0].Rule != tt.rule || vs[0].Path != tt.path {
+			t.Errorf("%s: unexpected violation %v", tt.name, vs[0])
+		}
+	}
+}
+
+func TestValidateSparse(t *testing.T) {
+	idx := makeTestIndex()
+
+	f := idx.Root.Contents.Files[0]
+	f.Length = 100
+	f.ExtentInfo = append(f.ExtentInfo, &Extent{Partition: "b", StartBlock: 10, ByteCount: 5, FileOffset: 95})
+
+	if vs := Validate(&idx); len(vs) != 0 {
+		t.Errorf("unexpected violations %v", vs)
+	}
+}
+
+func TestValidateStream(t *testing.T) {
+	idx := makeTestIndex()
+	idx.HighestFileUID = 3
+	idx.Root.Contents.Directories[0].Contents.Files = []*File{
+		{FileUID: 5, Name: "subdir1"},
+	}
+
+	buf, err := xml.Marshal(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vs, err := ValidateStream(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(vs), len(Validate(&idx)); got != want {
+		t.Errorf("expected %d violations, got %v", want, vs)
+	}
+
+	for _, v := range vs {
+		switch {
+		case v.Rule == RuleName && v.Path == "/directory1/subdir1":
+		case v.Rule == RuleUID && v.Path == "/directory1/subdir1":
+		default:
+			t.Errorf("unexpected violation %v", v)
+		}
+	}
+
+	if _, err := ValidateStream(bytes.NewReader(buf[:len(buf)/2])); err == nil {
+		t.Error("expected error for truncated index")
+	}
+}
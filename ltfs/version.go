@@ -0,0 +1,214 @@
+package ltfs
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// MinVersion is the oldest LTFS format version that can be read.
+const MinVersion = "2.0.0"
+
+// FormatVersion is an LTFS format version of the form major.minor.revision.
+type FormatVersion struct {
+	Major, Minor, Revision int
+}
+
+// ParseVersion parses an LTFS format version such as "2.2.0". Only versions
+// between MinVersion and Version (inclusive) are accepted.
+func ParseVersion(s string) (FormatVersion, error) {
+	var v FormatVersion
+
+	var rest string
+	n, _ := fmt.Sscanf(s, "%d.%d.%d%s", &v.Major, &v.Minor, &v.Revision, &rest)
+	if n != 3 || v.Major < 0 || v.Minor < 0 || v.Revision < 0 || v.String() != s {
+		return FormatVersion{}, errors.Errorf("invalid LTFS format version %q", s)
+	}
+
+	if v.Less(mustParseVersion(MinVersion)) || mustParseVersion(Version).Less(v) {
+		return FormatVersion{}, errors.Errorf("unsupported LTFS format version %q", s)
+	}
+
+	return v, nil
+}
+
+func mustParseVersion(s string) FormatVersion {
+	var v FormatVersion
+	if _, err := fmt.Sscanf(s, "%d.%d.%d", &v.Major, &v.Minor, &v.Revision); err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+func (v FormatVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Revision)
+}
+
+// Less reports whether v is older than w.
+func (v FormatVersion) Less(w FormatVersion) bool {
+	if v.Major != w.Major {
+		return v.Major < w.Major
+	}
+
+	if v.Minor != w.Minor {
+		return v.Minor < w.Minor
+	}
+
+	return v.Revision < w.Revision
+}
+
+// Supports reports whether f is available in format version v.
+func (v FormatVersion) Supports(f Feature) bool {
+	return !v.Less(f.Since())
+}
+
+// Feature is an index feature that is not available in all LTFS format
+// versions.
+type Feature int
+
+// Index features introduced after LTFS 2.0.0.
+const (
+	// FeatureOpenForWrite is the openforwrite file flag.
+	FeatureOpenForWrite Feature = iota
+
+	// FeatureSymlinks is the symlink file construct.
+	FeatureSymlinks
+
+	// FeaturePercentEncoding is the percentencoded name attribute.
+	FeaturePercentEncoding
+
+	// FeatureVolumeLockState is the volumelockstate index tag.
+	FeatureVolumeLockState
+)
+
+var features = [...]struct {
+	name  string
+	since string
+}{
+	FeatureOpenForWrite:    {"openforwrite", "2.2.0"},
+	FeatureSymlinks:        {"symlinks", "2.3.0"},
+	FeaturePercentEncoding: {"percentencoded", "2.3.0"},
+	FeatureVolumeLockState: {"volumelockstate", "2.4.0"},
+}
+
+func (f Feature) String() string {
+	return features[f].name
+}
+
+// Since returns the first format version supporting f.
+func (f Feature) Since() FormatVersion {
+	return mustParseVersion(features[f].since)
+}
+
+// ConvertVersion converts the index to the given format version. Features
+// used by the index that are not available in that version are removed from
+// the index and returned. Characters in names that would require
+// percent-encoding are replaced by underscores.
+func (idx *Index) ConvertVersion(version string) ([]Feature, error) {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[Feature]bool)
+
+	idx.IndexPreface.convertVersion(v, used)
+
+	if idx.Root != nil {
+		idx.Root.Name = convertName(idx.Root.Name, v, used)
+		idx.Root.convertVersion(v, used)
+	}
+
+	return droppedFeatures(used), nil
+}
+
+// convertVersion converts the preface to format version v.
+func (p *IndexPreface) convertVersion(v FormatVersion, used map[Feature]bool) {
+	if !v.Supports(FeatureVolumeLockState) && p.VolumeLockState != "" {
+		// an unlocked volume looks the same without the tag
+		if p.VolumeLockState != VolumeUnlocked {
+			used[FeatureVolumeLockState] = true
+		}
+
+		p.VolumeLockState = ""
+	}
+
+	p.Version = v.String()
+}
+
+// droppedFeatures returns the used features in order.
+func droppedFeatures(used map[Feature]bool) []Feature {
+	var dropped []Feature
+	for f := range features {
+		if used[Feature(f)] {
+			dropped = append(dropped, Feature(f))
+		}
+	}
+
+	return dropped
+}
+
+func (d *Directory) convertVersion(v FormatVersion, used map[Feature]bool) {
+	if d.Contents == nil {
+		return
+	}
+
+	files := d.Contents.Files[:0]
+	for _, f := range d.Contents.Files {
+		if f.convertVersion(v, used) {
+			files = append(files, f)
+		}
+	}
+
+	d.Contents.Files = files
+
+	for _, dir := range d.Contents.Directories {
+		dir.Name = convertName(dir.Name, v, used)
+		dir.convertVersion(v, used)
+	}
+}
+
+// convertVersion converts the file to format version v. It returns false if
+// the file cannot be represented in that version and must be dropped.
+func (f *File) convertVersion(v FormatVersion, used map[Feature]bool) bool {
+	if f.IsSymlink() && !v.Supports(FeatureSymlinks) {
+		used[FeatureSymlinks] = true
+		return false
+	}
+
+	f.Name = convertName(f.Name, v, used)
+
+	if f.OpenForWrite && !v.Supports(FeatureOpenForWrite) {
+		f.OpenForWrite = false
+		used[FeatureOpenForWrite] = true
+	}
+
+	return true
+}
+
+// convertName replaces the characters of s that would have to be
+// percent-encoded if v does not support percent-encoding.
+func convertName(s string, v FormatVersion, used map[Feature]bool) string {
+	if v.Supports(FeaturePercentEncoding) || !needsEncoding(s) {
+		return s
+	}
+
+	used[FeaturePercentEncoding] = true
+
+	var buf strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if validXMLRune(r, size) {
+			buf.WriteRune(r)
+		} else {
+			buf.WriteByte('_')
+		}
+
+		i += size
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,205 @@
+package ltfs
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in    string
+		v     FormatVersion
+		valid bool
+	}{
+		{"2.0.0", FormatVersion{2, 0, 0}, true},
+		{"2.2.0", FormatVersion{2, 2, 0}, true},
+		{"2.4.0", FormatVersion{2, 4, 0}, true},
+		{"1.0.0", FormatVersion{}, false},
+		{"2.5.0", FormatVersion{}, false},
+		{"3.0.0", FormatVersion{}, false},
+		{"2.2", FormatVersion{}, false},
+		{"2.2.0a", FormatVersion{}, false},
+		{"", FormatVersion{}, false},
+	}
+
+	for _, tc := range tests {
+		v, err := ParseVersion(tc.in)
+		if !tc.valid {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.in)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v != tc.v {
+			t.Errorf("%q: got %v, expected %v", tc.in, v, tc.v)
+		}
+	}
+}
+
+// an index using features from all supported versions
+const testIndex24 = `<ltfsindex version="2.4.0">
+  <creator>other LTFS 2.4</creator>
+  <volumeuuid>df925be0-44c0-4e49-af6a-7c3aa5b36d35</volumeuuid>
+  <generationnumber>7</generationnumber>
+  <updatetime>2017-03-07T13:27:38.192689471Z</updatetime>
+  <location><partition>a</partition><startblock>6</startblock></location>
+  <allowpolicyupdate>true</allowpolicyupdate>
+  <highestfileuid>4</highestfileuid>
+  <volumelockstate>locked</volumelockstate>
+  <directory>
+    <fileuid>1</fileuid>
+    <name>vol</name>
+    <contents>
+      <file>
+        <fileuid>2</fileuid>
+        <name percentencoded="true">a%3Ab%25</name>
+        <length>0</length>
+        <readonly>false</readonly>
+        <openforwrite>true</openforwrite>
+      </file>
+      <directory>
+        <fileuid>3</fileuid>
+        <name percentencoded="false">dir</name>
+        <contents>
+          <file>
+            <fileuid>4</fileuid>
+            <name>link</name>
+            <length>0</length>
+            <readonly>false</readonly>
+            <symlink>../a:b%</symlink>
+          </file>
+        </contents>
+      </directory>
+    </contents>
+  </directory>
+</ltfsindex>`
+
+func TestParseIndexVersions(t *testing.T) {
+	var idx Index
+	if err := xml.Unmarshal([]byte(testIndex24), &idx); err != nil {
+		t.Fatal(err)
+	}
+
+	if idx.VolumeLockState != VolumeLocked {
+		t.Errorf("unexpected volume lock state %q", idx.VolumeLockState)
+	}
+
+	f := idx.Root.Contents.Files[0]
+	if f.Name != "a:b%" || !f.OpenForWrite {
+		t.Errorf("unexpected file %q (openforwrite %v)", f.Name, f.OpenForWrite)
+	}
+
+	dir := idx.Root.Contents.Directories[0]
+	if dir.Name != "dir" {
+		t.Errorf("unexpected directory name %q", dir.Name)
+	}
+
+	if link := dir.Contents.Files[0]; !link.IsSymlink() || link.Symlink != "../a:b%" {
+		t.Errorf("unexpected symlink %v", link)
+	}
+
+	// no data placement policy at all
+	if idx.AllowPolicyUpdate != true || idx.Size != 0 || len(idx.Name) != 0 {
+		t.Errorf("unexpected data placement policy %v", idx.DataPlacementPolicy)
+	}
+}
+
+func TestParseInvalidPercentEncoding(t *testing.T) {
+	var f File
+	err := xml.Unmarshal([]byte(`<file><name percentencoded="true">a%zz</name></file>`), &f)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestDataPlacementPolicyVariations(t *testing.T) {
+	tests := []struct {
+		xml    string
+		policy DataPlacementPolicy
+	}{
+		{`<ltfsindex version="2.0.0"></ltfsindex>`, DataPlacementPolicy{}},
+		{`<ltfsindex version="2.0.0"><dataplacementpolicy/></ltfsindex>`, DataPlacementPolicy{}},
+		{
+			`<ltfsindex version="2.1.0"><dataplacementpolicy><indexpartitioncriteria>
+			  <size> 4096 </size></indexpartitioncriteria></dataplacementpolicy></ltfsindex>`,
+			DataPlacementPolicy{Size: 4096},
+		},
+		{
+			`<ltfsindex version="2.2.0"><dataplacementpolicy><indexpartitioncriteria>
+			  <name>*.txt</name><size>1024</size><name>index.*</name>
+			  </indexpartitioncriteria></dataplacementpolicy></ltfsindex>`,
+			DataPlacementPolicy{Size: 1024, Name: []string{"*.txt", "index.*"}},
+		},
+	}
+
+	for _, tc := range tests {
+		var idx Index
+		if err := xml.Unmarshal([]byte(tc.xml), &idx); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(idx.DataPlacementPolicy, tc.policy) {
+			t.Errorf("%s: got %v, expected %v", tc.xml, idx.DataPlacementPolicy, tc.policy)
+		}
+	}
+}
+
+func TestConvertVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		dropped []Feature
+	}{
+		{"2.4.0", nil},
+		{"2.3.0", []Feature{FeatureVolumeLockState}},
+		{"2.2.0", []Feature{FeatureSymlinks, FeatureVolumeLockState}},
+		{"2.0.0", []Feature{FeatureOpenForWrite, FeatureSymlinks, FeatureVolumeLockState}},
+	}
+
+	for _, tc := range tests {
+		var idx Index
+		if err := xml.Unmarshal([]byte(testIndex24), &idx); err != nil {
+			t.Fatal(err)
+		}
+
+		dropped, err := idx.ConvertVersion(tc.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(dropped, tc.dropped) {
+			t.Errorf("%s: dropped %v, expected %v", tc.version, dropped, tc.dropped)
+		}
+
+		if idx.Version != tc.version {
+			t.Errorf("%s: got version %s", tc.version, idx.Version)
+		}
+
+		buf, err := xml.Marshal(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the converted index parses as the target version
+		var idx2 Index
+		if err := xml.Unmarshal(buf, &idx2); err != nil {
+			t.Fatal(err)
+		}
+
+		symlinks := len(idx2.Root.Contents.Directories[0].Contents.Files)
+		if v, _ := ParseVersion(tc.version); v.Supports(FeatureSymlinks) != (symlinks == 1) {
+			t.Errorf("%s: unexpected number of symlinks %d", tc.version, symlinks)
+		}
+	}
+
+	var idx Index
+	if _, err := idx.ConvertVersion("9.9.9"); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,120 @@
+package ltfs
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Extended attribute value types.
+const (
+	XattrTypeText   = "text"
+	XattrTypeBase64 = "base64"
+)
+
+// XattrReservedPrefix is the prefix of the extended attribute names reserved
+// by LTFS.
+const XattrReservedPrefix = "ltfs."
+
+// ExtendedAttributes represents the LTFS extendedattributes construct of a
+// file or directory.
+type ExtendedAttributes []*ExtendedAttribute
+
+type xattrList struct {
+	Xattrs []*ExtendedAttribute `xml:"xattr"`
+}
+
+// MarshalXML implements xml.Marshaler.
+func (xs ExtendedAttributes) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(xattrList{xs}, start)
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (xs *ExtendedAttributes) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var lst xattrList
+	if err := d.DecodeElement(&lst, &start); err != nil {
+		return err
+	}
+
+	*xs = append(*xs, lst.Xattrs...)
+
+	return nil
+}
+
+// ExtendedAttribute represents an LTFS extended attribute (xattr) construct.
+type ExtendedAttribute struct {
+	Key   string                 `xml:"key"`
+	Value ExtendedAttributeValue `xml:"value"`
+}
+
+// ExtendedAttributeValue is the value of an extended attribute. Values that
+// cannot be represented as XML character data are base64 encoded. The type
+// attribute is omitted for text values which keeps the output readable by
+// LTFS 2.0 implementations.
+type ExtendedAttributeValue struct {
+	Type string `xml:"type,attr,omitempty"`
+	Data string `xml:",chardata"`
+}
+
+// NewExtendedAttribute returns a new ExtendedAttribute with the given key and
+// value, base64 encoding the value if required.
+func NewExtendedAttribute(key string, value []byte) *ExtendedAttribute {
+	x := &ExtendedAttribute{Key: key}
+
+	if isXMLText(value) {
+		x.Value.Data = string(value)
+	} else {
+		x.Value.Type = XattrTypeBase64
+		x.Value.Data = base64.StdEncoding.EncodeToString(value)
+	}
+
+	return x
+}
+
+// Bytes returns the decoded value of the extended attribute.
+func (x *ExtendedAttribute) Bytes() ([]byte, error) {
+	switch x.Value.Type {
+	case "", XattrTypeText:
+		return []byte(x.Value.Data), nil
+	case XattrTypeBase64:
+		buf, err := base64.StdEncoding.DecodeString(x.Value.Data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid base64 value of extended attribute '%s'", x.Key)
+		}
+
+		return buf, nil
+	}
+
+	return nil, errors.Errorf("unknown value type '%s' of extended attribute '%s'", x.Value.Type, x.Key)
+}
+
+// isXMLText reports whether buf can be stored as XML character data without
+// modification.
+func isXMLText(buf []byte) bool {
+	if !utf8.Valid(buf) {
+		return false
+	}
+
+	for _, r := range string(buf) {
+		if !isXMLChar(r) {
+			return false
+		}
+
+		// XML parsers normalize carriage returns
+		if r == '\r' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isXMLChar reports whether r is in the XML 1.0 Char production.
+func isXMLChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
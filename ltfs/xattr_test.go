@@ -0,0 +1,113 @@
+package ltfs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/kr/pretty"
+)
+
+func TestExtendedAttributes(t *testing.T) {
+	xmlExpected := `<extendedattributes>
+  <xattr>
+    <key>user.comment</key>
+    <value>hello, world</value>
+  </xattr>
+  <xattr>
+    <key>user.digest</key>
+    <value type="base64">AAH+/w==</value>
+  </xattr>
+</extendedattributes>`
+
+	values := map[string][]byte{
+		"user.comment": []byte("hello, world"),
+		"user.digest":  []byte{0x00, 0x01, 0xfe, 0xff},
+	}
+
+	x0 := ExtendedAttributes{
+		NewExtendedAttribute("user.comment", values["user.comment"]),
+		NewExtendedAttribute("user.digest", values["user.digest"]),
+	}
+
+	start := xml.StartElement{Name: xml.Name{Space: "", Local: "extendedattributes"}}
+
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+
+	if err := enc.EncodeElement(x0, start); err != nil {
+		t.Fatal(err)
+	}
+
+	if xmlExpected != buf.String() {
+		fmt.Printf("EXPECTED:\n%s\n", xmlExpected)
+		fmt.Println()
+		fmt.Printf("GOT:\n%s\n", buf.String())
+
+		t.Error("xmlExpected != buf")
+	}
+
+	x1 := ExtendedAttributes{}
+
+	if err := xml.Unmarshal(buf.Bytes(), &x1); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(x0, x1) {
+		fmt.Println("EXPECTED:")
+		pretty.Println(x0)
+		fmt.Println("GOT:")
+		pretty.Println(x1)
+
+		t.Error("x0 != x1")
+	}
+
+	for _, x := range x1 {
+		v, err := x.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(v, values[x.Key]) {
+			t.Errorf("%s: got %v, expected %v", x.Key, v, values[x.Key])
+		}
+	}
+}
+
+func TestExtendedAttributeValueTypes(t *testing.T) {
+	tests := []struct {
+		value   ExtendedAttributeValue
+		decoded []byte
+		valid   bool
+	}{
+		{ExtendedAttributeValue{Type: "", Data: "text"}, []byte("text"), true},
+		{ExtendedAttributeValue{Type: "text", Data: "text"}, []byte("text"), true},
+		{ExtendedAttributeValue{Type: "base64", Data: "dGV4dA=="}, []byte("text"), true},
+		{ExtendedAttributeValue{Type: "base64", Data: "!!!"}, nil, false},
+		{ExtendedAttributeValue{Type: "binary", Data: "text"}, nil, false},
+	}
+
+	for _, tc := range tests {
+		x := &ExtendedAttribute{Key: "user.test", Value: tc.value}
+
+		v, err := x.Bytes()
+		if !tc.valid {
+			if err == nil {
+				t.Errorf("%v: expected an error", tc.value)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(v, tc.decoded) {
+			t.Errorf("%v: got %q, expected %q", tc.value, v, tc.decoded)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package bltfs
+
+import "hpt.space/bltfs/ltfs"
+
+// NamePolicy determines when two names are considered equal. Names are
+// always compared after NFC normalization; creating a name that is equal to
+// an existing one under the policy fails with os.ErrExist.
+type NamePolicy int
+
+const (
+	// CaseSensitive compares normalized names as they are.
+	CaseSensitive NamePolicy = iota
+
+	// CaseInsensitive compares normalized names without regard to case.
+	CaseInsensitive
+)
+
+// key returns the binary index key for path under the policy. Entry names
+// keep their original form; only the keys are normalized.
+func (p NamePolicy) key(path string) string {
+	if p == CaseInsensitive {
+		return ltfs.FoldName(path)
+	}
+
+	return ltfs.NormalizeName(path)
+}
+
+type indexOptions struct {
+	policy NamePolicy
+}
+
+// IndexOption configures a binary index.
+type IndexOption func(*indexOptions)
+
+// WithIndexNamePolicy sets the name policy of the binary index. The default
+// is CaseSensitive.
+func WithIndexNamePolicy(policy NamePolicy) IndexOption {
+	return func(o *indexOptions) {
+		o.policy = policy
+	}
+}
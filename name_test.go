@@ -0,0 +1,85 @@
+package bltfs_test
+
+import (
+	"os"
+	"testing"
+
+	"hpt.space/bltfs"
+	filedebug "hpt.space/bltfs/backend/file"
+)
+
+const (
+	composed   = "café"
+	decomposed = "café"
+)
+
+func TestNameNormalization(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	if err := store.Mkdir("/" + composed); err != nil {
+		t.Fatal(err)
+	}
+
+	// the entry is found using either form
+	fi, err := store.Stat("/" + decomposed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Name() != composed {
+		t.Errorf("got name %q, expected %q", fi.Name(), composed)
+	}
+
+	if err := store.Mkdir("/" + decomposed); !os.IsExist(err) {
+		t.Errorf("expected an exist error, got %v", err)
+	}
+
+	// case sensitive by default
+	if err := store.Mkdir("/DIR"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCaseInsensitiveNames(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex(), bltfs.WithNamePolicy(bltfs.CaseInsensitive))
+	defer cleanup(dir)
+	defer store.Close()
+
+	if err := store.Mkdir("/DIR"); !os.IsExist(err) {
+		t.Errorf("expected an exist error, got %v", err)
+	}
+
+	if err := store.Symlink("x", "/TestFile.TXT"); !os.IsExist(err) {
+		t.Errorf("expected an exist error, got %v", err)
+	}
+
+	// case is preserved
+	fi, err := store.Stat("/Dir/FILE")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Name() != "file" {
+		t.Errorf("got name %q", fi.Name())
+	}
+}
+
+func TestNameCollisionOnMount(t *testing.T) {
+	idx := makeTestIndex()
+	idx.Root.Contents.Files = append(idx.Root.Contents.Files, makeTestFile(5, "TESTFILE.txt", 1, 6))
+	idx.HighestFileUID = 5
+
+	dir := makeTestTape(t, idx)
+	defer cleanup(dir)
+
+	dev, err := filedebug.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bltfs.Open(dev, bltfs.WithNamePolicy(bltfs.CaseInsensitive)); err == nil {
+		t.Error("expected a name collision")
+	}
+}
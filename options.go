@@ -17,6 +17,20 @@ type storeOptions struct {
 	pol       RecoveryPolicy
 	reporter  Reporter
 	filedebug bool
+	version   string
+	policy    NamePolicy
+
+	// open the volume read-only at a past generation
+	generation int
+	at         time.Time
+
+	// directory caching binary indexes between mounts
+	cacheDir string
+
+	// the storage engine of the binary index and the directory of its
+	// (temporary) database
+	engine   IndexEngine
+	indexDir string
 }
 
 type StoreOption func(*storeOptions)
@@ -44,3 +58,71 @@ func WithReporter(reporter Reporter) StoreOption {
 		o.reporter = reporter
 	}
 }
+
+// WithIndexVersion selects the LTFS format version of the indexes written by
+// the store. Features not available in that version are dropped when the
+// index is written. The default is ltfs.Version.
+func WithIndexVersion(version string) StoreOption {
+	return func(o *storeOptions) {
+		o.version = version
+	}
+}
+
+// WithNamePolicy sets the policy used to compare names. The default is
+// CaseSensitive.
+func WithNamePolicy(policy NamePolicy) StoreOption {
+	return func(o *storeOptions) {
+		o.policy = policy
+	}
+}
+
+// WithGeneration opens the store read-only at the index generation with the
+// given number.
+func WithGeneration(n int) StoreOption {
+	return func(o *storeOptions) {
+		o.generation = n
+	}
+}
+
+// WithTimestamp opens the store read-only at the newest index generation
+// updated at or before t.
+func WithTimestamp(t time.Time) StoreOption {
+	return func(o *storeOptions) {
+		o.at = t
+	}
+}
+
+// WithIndexCache keeps the binary index in the given directory between
+// mounts, keyed by volume UUID. If the binary index was built from the latest
+// index generation on the volume, the volume is mounted without reading the
+// LTFS index; otherwise the cache is rebuilt. Stores opened at a past
+// generation do not use the cache.
+func WithIndexCache(dir string) StoreOption {
+	return func(o *storeOptions) {
+		o.cacheDir = dir
+	}
+}
+
+// IndexEngine is a storage engine of the binary index.
+type IndexEngine int
+
+const (
+	// BoltEngine keeps the binary index in a bolt database on disk.
+	BoltEngine IndexEngine = iota
+
+	// MemoryEngine keeps the binary index in memory. It is meant for tests
+	// and short-lived (read-only) mounts.
+	MemoryEngine
+)
+
+// WithIndexEngine selects the storage engine of the binary index. A bolt
+// database is created in a temporary directory in dir (or the default
+// directory for temporary files if dir is empty), which is removed when the
+// store is closed. The default is BoltEngine. The index cache (see
+// WithIndexCache) requires BoltEngine.
+func WithIndexEngine(engine IndexEngine, dir string) StoreOption {
+	return func(o *storeOptions) {
+		o.engine = engine
+		o.indexDir = dir
+	}
+}
@@ -2,7 +2,11 @@ package bltfs
 
 import (
 	"os"
+	"path/filepath"
 	"syscall"
+	"time"
+
+	"hpt.space/bltfs/proto"
 )
 
 const (
@@ -15,6 +19,12 @@ func IsPathSeparator(c uint8) bool {
 	return PathSeparator == c
 }
 
+// cleanPath returns the canonical (absolute and clean) form of the given
+// store path.
+func cleanPath(path string) string {
+	return filepath.Join("/", path)
+}
+
 // MkdirAll creates a directory named path, along with any necessary parents,
 // and returns nil, or else returns an error.  The permission bits perm are
 // used for all directories that MkdirAll creates.  If path is already a
@@ -29,7 +39,7 @@ func (s *Store) MkdirAll(path string) error {
 			return nil
 		}
 
-		return &os.PathError{"mkdir", path, syscall.ENOTDIR}
+		return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
 	}
 
 	// Slow path: make sure parent exists and then call Mkdir for path.
@@ -70,5 +80,36 @@ func (s *Store) MkdirAll(path string) error {
 // Mkdir creates a new directory with the specified name and permission bits.
 // If there is an error, it will be of type *PathError.
 func (s *Store) Mkdir(name string) error {
+	if err := s.writable(); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+
+	path := cleanPath(name)
+	now := time.Now().UnixNano()
+
+	entry := &proto.Entry{
+		Name:       filepath.Base(path),
+		CreateTime: now,
+		ChangeTime: now,
+		ModifyTime: now,
+		AccessTime: now,
+		BackupTime: now,
+
+		Elem: &proto.Entry_Dir{
+			Dir: &proto.Directory{},
+		},
+	}
+
+	var err error
+	if entry.Id, err = s.allocUID(); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+
+	if err := s.idx.create(path, entry); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+
+	s.journal.record(proto.Entry_ADD, path, entry)
+
 	return nil
 }
@@ -0,0 +1,82 @@
+package bltfs
+
+import (
+	"github.com/pkg/errors"
+
+	"hpt.space/bltfs/ltfs"
+)
+
+// ErrPolicyUpdate is returned when changing the data placement policy of a
+// volume that does not allow policy updates.
+var ErrPolicyUpdate = errors.New("data placement policy may not be updated")
+
+// DataPlacementPolicy returns the data placement policy of the volume. Files
+// matching its index partition criteria are written to the index partition.
+func (s *Store) DataPlacementPolicy() ltfs.DataPlacementPolicy {
+	s.placement.Lock()
+	defer s.placement.Unlock()
+
+	return copyPolicy(s.placement.pol)
+}
+
+// SetDataPlacementPolicy changes the data placement policy of the volume. The
+// new policy applies to files created from now on and is recorded in the next
+// index. It fails with ErrPolicyUpdate if the volume does not allow policy
+// updates.
+func (s *Store) SetDataPlacementPolicy(pol ltfs.DataPlacementPolicy) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+
+	s.placement.Lock()
+
+	if !s.placement.allowUpdate {
+		s.placement.Unlock()
+		return ErrPolicyUpdate
+	}
+
+	s.placement.pol = copyPolicy(pol)
+	s.placement.Unlock()
+
+	return s.recordVolume()
+}
+
+func copyPolicy(pol ltfs.DataPlacementPolicy) ltfs.DataPlacementPolicy {
+	pol.Name = append([]string(nil), pol.Name...)
+
+	return pol
+}
+
+// writeIndexPartition appends p to the data on the index partition and
+// returns the block it was written at. No filemark is written, so the current
+// index can still be found by spacing backwards over two filemarks from EOD
+// until the next index is written after the data (see Store.Sync). The device
+// is positioned at EOD on the data partition afterwards.
+func (s *Store) writeIndexPartition(p []byte) (uint64, error) {
+	s.rw.mu.Lock()
+	defer s.rw.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dev := s.mu.backend
+
+	if err := dev.Locate(ltfs.IndexPartition, TapeBlockMax); err != nil {
+		return 0, errors.Wrap(err, "failed to seek to EOD")
+	}
+
+	block, err := dev.ReadPosition()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read position")
+	}
+
+	if err := s.writeRecords(p); err != nil {
+		return 0, err
+	}
+
+	if err := s.seekEOD(); err != nil {
+		return 0, err
+	}
+
+	return block, nil
+}
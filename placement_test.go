@@ -0,0 +1,181 @@
+package bltfs_test
+
+import (
+	"bytes"
+	"testing"
+
+	"hpt.space/bltfs"
+	"hpt.space/bltfs/ltfs"
+)
+
+func writeTestFile(t *testing.T, store *bltfs.Store, name string, data []byte) {
+	f, err := store.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTestRecord returns the record at the given position.
+func readTestRecord(t *testing.T, dir string, part uint32, block uint64) []byte {
+	dev := openTestDevice(t, dir)
+	defer dev.Close()
+
+	if err := dev.Locate(part, block); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, dev.BlockSize())
+
+	n, err := dev.Read(buf)
+	if err != nil {
+		return nil
+	}
+
+	return buf[:n]
+}
+
+func TestDataPlacement(t *testing.T) {
+	idx := makeTestIndex()
+	idx.DataPlacementPolicy = ltfs.DataPlacementPolicy{
+		Size: 16,
+		Name: []string{"*.txt"},
+	}
+
+	store, dir := openTestStore(t, idx)
+	defer cleanup(dir)
+
+	writeTestFile(t, store, "/small.txt", []byte("small"))
+	writeTestFile(t, store, "/small.bin", []byte("binary"))
+	writeTestFile(t, store, "/large.txt", bytes.Repeat([]byte("x"), 17))
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the index partition holds VOL1, label, FM, index, FM
+	if rec := readTestRecord(t, dir, ltfs.IndexPartition, 5); string(rec) != "small" {
+		t.Errorf("expected small file on index partition, got %q", rec)
+	}
+
+	if rec := readTestRecord(t, dir, ltfs.IndexPartition, 6); rec != nil {
+		t.Errorf("unexpected record %q on index partition", rec)
+	}
+
+	if rec := readTestRecord(t, dir, ltfs.DataPartition, 5); string(rec) != "binary" {
+		t.Errorf("expected non-matching file on data partition, got %q", rec)
+	}
+
+	// the volume can still be mounted
+	store, err := bltfs.Open(openTestDevice(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Close()
+}
+
+func TestDataPlacementRemount(t *testing.T) {
+	idx := makeTestIndex()
+	idx.DataPlacementPolicy = ltfs.DataPlacementPolicy{
+		Size: 16,
+		Name: []string{"*.txt"},
+	}
+
+	store, dir := openTestStore(t, idx)
+	defer cleanup(dir)
+
+	writeTestFile(t, store, "/small.txt", []byte("small"))
+	writeTestFile(t, store, "/small.bin", []byte("binary"))
+
+	// the index is written after the small file on the index partition
+	if _, err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, store, "/other.txt", []byte("other"))
+
+	if _, err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the index partition holds VOL1, label, FM, index, FM, data, FM,
+	// index, FM, data, FM, index, FM
+	for _, blk := range []uint64{6, 10} {
+		if rec := readTestRecord(t, dir, ltfs.IndexPartition, blk); rec == nil || len(rec) != 0 {
+			t.Errorf("expected filemark at block %d of the index partition, got %q", blk, rec)
+		}
+	}
+
+	store, err := bltfs.Open(openTestDevice(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	expectContent(t, store, "/small.txt", []byte("small"))
+	expectContent(t, store, "/small.bin", []byte("binary"))
+	expectContent(t, store, "/other.txt", []byte("other"))
+
+	if v, err := store.Getxattr("/other.txt", "ltfs.partition"); err != nil || string(v) != "a" {
+		t.Errorf("expected /other.txt on the index partition, got %q (%v)", v, err)
+	}
+}
+
+func TestSetDataPlacementPolicy(t *testing.T) {
+	idx := makeTestIndex()
+	idx.AllowPolicyUpdate = true
+
+	store, dir := openTestStore(t, idx)
+	defer cleanup(dir)
+
+	pol := ltfs.DataPlacementPolicy{Size: 1024, Name: []string{"*.xml"}}
+	if err := store.SetDataPlacementPolicy(pol); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := store.DataPlacementPolicy(); got.Size != 1024 || len(got.Name) != 1 || got.Name[0] != "*.xml" {
+		t.Errorf("unexpected policy %+v", got)
+	}
+
+	lidx, _, err := store.LTFSIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !lidx.AllowPolicyUpdate || lidx.DataPlacementPolicy.Size != 1024 {
+		t.Errorf("policy not recorded in index: %+v", lidx.IndexPreface)
+	}
+
+	// files created from now on follow the new policy
+	writeTestFile(t, store, "/meta.xml", []byte("<meta/>"))
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := readTestRecord(t, dir, ltfs.IndexPartition, 5); string(rec) != "<meta/>" {
+		t.Errorf("expected file on index partition, got %q", rec)
+	}
+}
+
+func TestSetDataPlacementPolicyNotAllowed(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	if err := store.SetDataPlacementPolicy(ltfs.DataPlacementPolicy{Size: 1}); err != bltfs.ErrPolicyUpdate {
+		t.Errorf("expected ErrPolicyUpdate, got %v", err)
+	}
+}
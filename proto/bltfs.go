@@ -13,7 +13,7 @@ import (
 
 //go:generate protoc bltfs.proto --go_out=.
 
-func (e *Entry) MakeTree() (*ltfs.Directory, error) {
+func (e *Entry) MakeTree(pmap ltfs.PartitionMap) (*ltfs.Directory, error) {
 	d := &ltfs.Directory{
 		XMLName:      xml.Name{Space: "", Local: "directory"},
 		FileUID:      int(e.Id),
@@ -23,6 +23,9 @@ func (e *Entry) MakeTree() (*ltfs.Directory, error) {
 		ModifyTime:   xmlutil.Unix(0, e.ModifyTime),
 		AccessTime:   xmlutil.Unix(0, e.AccessTime),
 		BackupTime:   xmlutil.Unix(0, e.BackupTime),
+		ReadOnly:     e.Readonly,
+
+		ExtendedAttributes: e.MakeExtendedAttributes(),
 
 		Contents: &ltfs.Contents{
 			Directories: make([]*ltfs.Directory, 0),
@@ -38,14 +41,14 @@ func (e *Entry) MakeTree() (*ltfs.Directory, error) {
 	for _, dentry := range elem.Dir.Entries {
 		switch dentry.Elem.(type) {
 		case *Entry_Dir:
-			dir, err := dentry.MakeTree()
+			dir, err := dentry.MakeTree(pmap)
 			if err != nil {
 				return nil, err
 			}
 
 			d.Contents.Directories = append(d.Contents.Directories, dir)
-		case *Entry_File:
-			file, err := dentry.MakeFile()
+		case *Entry_File, *Entry_Symlink:
+			file, err := dentry.MakeFile(pmap)
 			if err != nil {
 				return nil, err
 			}
@@ -57,7 +60,7 @@ func (e *Entry) MakeTree() (*ltfs.Directory, error) {
 	return d, nil
 }
 
-func (e *Entry) MakeFile() (*ltfs.File, error) {
+func (e *Entry) MakeFile(pmap ltfs.PartitionMap) (*ltfs.File, error) {
 	f := &ltfs.File{
 		XMLName:      xml.Name{Space: "", Local: "file"},
 		FileUID:      int(e.Id),
@@ -67,35 +70,70 @@ func (e *Entry) MakeFile() (*ltfs.File, error) {
 		ModifyTime:   xmlutil.Unix(0, e.ModifyTime),
 		AccessTime:   xmlutil.Unix(0, e.AccessTime),
 		BackupTime:   xmlutil.Unix(0, e.BackupTime),
+		ReadOnly:     e.Readonly,
+
+		ExtendedAttributes: e.MakeExtendedAttributes(),
 	}
 
-	elem, ok := e.Elem.(*Entry_File)
-	if !ok {
+	switch elem := e.Elem.(type) {
+	case *Entry_File:
+		f.Length = int(elem.File.Length)
+		f.OpenForWrite = elem.File.OpenForWrite
+		f.ExtentInfo = make([]*ltfs.Extent, 0)
+
+		for _, extent := range elem.File.Extents {
+			ex, err := extent.MakeExtent(pmap)
+			if err != nil {
+				return nil, err
+			}
+
+			f.ExtentInfo = append(f.ExtentInfo, ex)
+		}
+
+	case *Entry_Symlink:
+		f.Symlink = elem.Symlink.Target
+
+	default:
 		return nil, errors.New("entry is not a file")
 	}
 
-	f.Length = int(elem.File.Length)
-	f.ExtentInfo = make([]*ltfs.Extent, 0)
+	return f, nil
+}
 
-	for _, extent := range elem.File.Extents {
-		ex := extent.MakeExtent()
-		f.ExtentInfo = append(f.ExtentInfo, ex)
+// MakeExtendedAttributes returns the ltfs.ExtendedAttribute representation of
+// the extended attributes of the entry.
+func (e *Entry) MakeExtendedAttributes() []*ltfs.ExtendedAttribute {
+	if len(e.Xattrs) == 0 {
+		return nil
 	}
 
-	return f, nil
+	xattrs := make([]*ltfs.ExtendedAttribute, 0, len(e.Xattrs))
+	for _, x := range e.Xattrs {
+		xattrs = append(xattrs, ltfs.NewExtendedAttribute(x.Key, x.Value))
+	}
+
+	return xattrs
 }
 
-func (e *Extent) MakeExtent() *ltfs.Extent {
+// MakeExtent returns the ltfs.Extent representation of the extent. The
+// physical partition number is translated to an LTFS partition identifier
+// using the given partition map.
+func (e *Extent) MakeExtent(pmap ltfs.PartitionMap) (*ltfs.Extent, error) {
+	part, err := pmap.ID(e.Partition)
+	if err != nil {
+		return nil, err
+	}
+
 	return &ltfs.Extent{
-		Partition:  "b", // TODO(kbj): DO NOT HARD CODE THIS
+		Partition:  part,
 		StartBlock: int(e.Block),
 		ByteCount:  int(e.Length),
 		ByteOffset: int(e.Boffset),
 		FileOffset: int(e.Offset),
-	}
+	}, nil
 }
 
-func MarshalDirectoryRecursive(d *ltfs.Directory, e *Entry) error {
+func MarshalDirectoryRecursive(d *ltfs.Directory, e *Entry, pmap ltfs.PartitionMap) error {
 	if err := MarshalDirectory(d, e); err != nil {
 		return err
 	}
@@ -104,7 +142,7 @@ func MarshalDirectoryRecursive(d *ltfs.Directory, e *Entry) error {
 
 	for _, file := range d.Contents.Files {
 		var pbfile Entry
-		if err := MarshalFile(file, &pbfile); err != nil {
+		if err := MarshalFile(file, &pbfile, pmap); err != nil {
 			return err
 		}
 
@@ -113,7 +151,7 @@ func MarshalDirectoryRecursive(d *ltfs.Directory, e *Entry) error {
 
 	for _, dir := range d.Contents.Directories {
 		var pbdir Entry
-		if err := MarshalDirectoryRecursive(dir, &pbdir); err != nil {
+		if err := MarshalDirectoryRecursive(dir, &pbdir, pmap); err != nil {
 			return err
 		}
 
@@ -140,6 +178,10 @@ func MarshalDirectory(d *ltfs.Directory, entry *Entry) error {
 	entry.AccessTime = d.AccessTime.UnixNano()
 	entry.BackupTime = d.BackupTime.UnixNano()
 
+	if err := MarshalExtendedAttributes(d.ExtendedAttributes, entry); err != nil {
+		return err
+	}
+
 	entry.Elem = &Entry_Dir{
 		Dir: &Directory{},
 	}
@@ -147,7 +189,9 @@ func MarshalDirectory(d *ltfs.Directory, entry *Entry) error {
 	return nil
 }
 
-func MarshalFile(f *ltfs.File, entry *Entry) error {
+// MarshalFile marshals the ltfs.File to a pb.Entry. Symbolic links are
+// marshalled to Symlink entries.
+func MarshalFile(f *ltfs.File, entry *Entry, pmap ltfs.PartitionMap) error {
 	entry.Id = uint64(f.FileUID)
 	entry.Name = f.Name
 
@@ -158,23 +202,71 @@ func MarshalFile(f *ltfs.File, entry *Entry) error {
 	entry.AccessTime = f.AccessTime.UnixNano()
 	entry.BackupTime = f.BackupTime.UnixNano()
 
+	if err := MarshalExtendedAttributes(f.ExtendedAttributes, entry); err != nil {
+		return err
+	}
+
+	if f.IsSymlink() {
+		entry.Elem = &Entry_Symlink{&Symlink{Target: f.Symlink}}
+
+		return nil
+	}
+
 	pbfile := &File{
-		Length:  uint64(f.Length),
-		Extents: make([]*Extent, 0),
+		Length:       uint64(f.Length),
+		Extents:      make([]*Extent, 0),
+		OpenForWrite: f.OpenForWrite,
 	}
 
 	for _, extent := range f.ExtentInfo {
-		pbfile.Extents = append(pbfile.Extents, &Extent{
-			Id:        uint64(f.FileUID),
-			Partition: 1, // TODO(kbj): don't hardcode this!
-			Block:     uint64(extent.StartBlock),
-			Length:    uint64(extent.ByteCount),
-			Boffset:   uint64(extent.ByteOffset),
-			Offset:    uint64(extent.FileOffset),
-		})
+		var pbextent Extent
+		if err := MarshalExtent(extent, uint64(f.FileUID), &pbextent, pmap); err != nil {
+			return err
+		}
+
+		pbfile.Extents = append(pbfile.Extents, &pbextent)
 	}
 
 	entry.Elem = &Entry_File{pbfile}
 
 	return nil
 }
+
+// MarshalExtent marshals the ltfs.Extent belonging to the file with the given
+// file UID to a pb.Extent. The LTFS partition identifier is translated to a
+// physical partition number using the given partition map.
+func MarshalExtent(ex *ltfs.Extent, uid uint64, e *Extent, pmap ltfs.PartitionMap) error {
+	part, err := pmap.Number(ex.Partition)
+	if err != nil {
+		return err
+	}
+
+	e.Id = uid
+	e.Partition = part
+	e.Block = uint64(ex.StartBlock)
+	e.Length = uint64(ex.ByteCount)
+	e.Boffset = uint64(ex.ByteOffset)
+	e.Offset = uint64(ex.FileOffset)
+
+	return nil
+}
+
+// MarshalExtendedAttributes marshals the extended attributes to the entry,
+// decoding any base64 encoded values.
+func MarshalExtendedAttributes(xattrs []*ltfs.ExtendedAttribute, entry *Entry) error {
+	entry.Xattrs = nil
+
+	for _, x := range xattrs {
+		value, err := x.Bytes()
+		if err != nil {
+			return err
+		}
+
+		entry.Xattrs = append(entry.Xattrs, &Xattr{
+			Key:   x.Key,
+			Value: value,
+		})
+	}
+
+	return nil
+}
@@ -11,9 +11,11 @@ It has these top-level messages:
 	Index
 	Directory
 	File
+	Symlink
 	Entry
 	Extent
 	Log
+	Xattr
 */
 package proto
 
@@ -57,7 +59,7 @@ var Entry_Op_value = map[string]int32{
 func (x Entry_Op) String() string {
 	return proto1.EnumName(Entry_Op_name, int32(x))
 }
-func (Entry_Op) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{3, 0} }
+func (Entry_Op) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{4, 0} }
 
 // log entry class
 type Log_Class int32
@@ -82,7 +84,7 @@ var Log_Class_value = map[string]int32{
 func (x Log_Class) String() string {
 	return proto1.EnumName(Log_Class_name, int32(x))
 }
-func (Log_Class) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{5, 0} }
+func (Log_Class) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{6, 0} }
 
 // An Index is a binary index with a rooted directory structure
 type Index struct {
@@ -140,6 +142,8 @@ func (m *Directory) GetEntries() []*Entry {
 type File struct {
 	Length  uint64    `protobuf:"varint,1,opt,name=length" json:"length,omitempty"`
 	Extents []*Extent `protobuf:"bytes,2,rep,name=extents" json:"extents,omitempty"`
+	// the file was open for writing when the index was written
+	OpenForWrite bool `protobuf:"varint,3,opt,name=open_for_write,json=openForWrite" json:"open_for_write,omitempty"`
 }
 
 func (m *File) Reset()                    { *m = File{} }
@@ -161,7 +165,32 @@ func (m *File) GetExtents() []*Extent {
 	return nil
 }
 
-// An entry has an id, metadata and contains either a File or a Directory.
+func (m *File) GetOpenForWrite() bool {
+	if m != nil {
+		return m.OpenForWrite
+	}
+	return false
+}
+
+// A symlink is a symbolic link to a target path.
+type Symlink struct {
+	Target string `protobuf:"bytes,1,opt,name=target" json:"target,omitempty"`
+}
+
+func (m *Symlink) Reset()                    { *m = Symlink{} }
+func (m *Symlink) String() string            { return proto1.CompactTextString(m) }
+func (*Symlink) ProtoMessage()               {}
+func (*Symlink) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *Symlink) GetTarget() string {
+	if m != nil {
+		return m.Target
+	}
+	return ""
+}
+
+// An entry has an id, metadata and contains either a File, a Directory or a
+// Symlink.
 type Entry struct {
 	Id         uint64   `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
 	Name       string   `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
@@ -172,16 +201,19 @@ type Entry struct {
 	AccessTime int64    `protobuf:"varint,7,opt,name=access_time,json=accessTime" json:"access_time,omitempty"`
 	BackupTime int64    `protobuf:"varint,8,opt,name=backup_time,json=backupTime" json:"backup_time,omitempty"`
 	Operation  Entry_Op `protobuf:"varint,9,opt,name=operation,enum=proto.Entry_Op" json:"operation,omitempty"`
+	// extended attributes
+	Xattrs []*Xattr `protobuf:"bytes,12,rep,name=xattrs" json:"xattrs,omitempty"`
 	// Types that are valid to be assigned to Elem:
 	//	*Entry_File
 	//	*Entry_Dir
+	//	*Entry_Symlink
 	Elem isEntry_Elem `protobuf_oneof:"elem"`
 }
 
 func (m *Entry) Reset()                    { *m = Entry{} }
 func (m *Entry) String() string            { return proto1.CompactTextString(m) }
 func (*Entry) ProtoMessage()               {}
-func (*Entry) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+func (*Entry) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
 
 type isEntry_Elem interface {
 	isEntry_Elem()
@@ -193,9 +225,13 @@ type Entry_File struct {
 type Entry_Dir struct {
 	Dir *Directory `protobuf:"bytes,11,opt,name=dir,oneof"`
 }
+type Entry_Symlink struct {
+	Symlink *Symlink `protobuf:"bytes,13,opt,name=symlink,oneof"`
+}
 
-func (*Entry_File) isEntry_Elem() {}
-func (*Entry_Dir) isEntry_Elem()  {}
+func (*Entry_File) isEntry_Elem()    {}
+func (*Entry_Dir) isEntry_Elem()     {}
+func (*Entry_Symlink) isEntry_Elem() {}
 
 func (m *Entry) GetElem() isEntry_Elem {
 	if m != nil {
@@ -267,6 +303,13 @@ func (m *Entry) GetOperation() Entry_Op {
 	return Entry_UNKNOWN
 }
 
+func (m *Entry) GetXattrs() []*Xattr {
+	if m != nil {
+		return m.Xattrs
+	}
+	return nil
+}
+
 func (m *Entry) GetFile() *File {
 	if x, ok := m.GetElem().(*Entry_File); ok {
 		return x.File
@@ -281,11 +324,19 @@ func (m *Entry) GetDir() *Directory {
 	return nil
 }
 
+func (m *Entry) GetSymlink() *Symlink {
+	if x, ok := m.GetElem().(*Entry_Symlink); ok {
+		return x.Symlink
+	}
+	return nil
+}
+
 // XXX_OneofFuncs is for the internal use of the proto package.
 func (*Entry) XXX_OneofFuncs() (func(msg proto1.Message, b *proto1.Buffer) error, func(msg proto1.Message, tag, wire int, b *proto1.Buffer) (bool, error), func(msg proto1.Message) (n int), []interface{}) {
 	return _Entry_OneofMarshaler, _Entry_OneofUnmarshaler, _Entry_OneofSizer, []interface{}{
 		(*Entry_File)(nil),
 		(*Entry_Dir)(nil),
+		(*Entry_Symlink)(nil),
 	}
 }
 
@@ -303,6 +354,11 @@ func _Entry_OneofMarshaler(msg proto1.Message, b *proto1.Buffer) error {
 		if err := b.EncodeMessage(x.Dir); err != nil {
 			return err
 		}
+	case *Entry_Symlink:
+		b.EncodeVarint(13<<3 | proto1.WireBytes)
+		if err := b.EncodeMessage(x.Symlink); err != nil {
+			return err
+		}
 	case nil:
 	default:
 		return fmt.Errorf("Entry.Elem has unexpected type %T", x)
@@ -329,6 +385,14 @@ func _Entry_OneofUnmarshaler(msg proto1.Message, tag, wire int, b *proto1.Buffer
 		err := b.DecodeMessage(msg)
 		m.Elem = &Entry_Dir{msg}
 		return true, err
+	case 13: // elem.symlink
+		if wire != proto1.WireBytes {
+			return true, proto1.ErrInternalBadWireType
+		}
+		msg := new(Symlink)
+		err := b.DecodeMessage(msg)
+		m.Elem = &Entry_Symlink{msg}
+		return true, err
 	default:
 		return false, nil
 	}
@@ -348,6 +412,11 @@ func _Entry_OneofSizer(msg proto1.Message) (n int) {
 		n += proto1.SizeVarint(11<<3 | proto1.WireBytes)
 		n += proto1.SizeVarint(uint64(s))
 		n += s
+	case *Entry_Symlink:
+		s := proto1.Size(x.Symlink)
+		n += proto1.SizeVarint(13<<3 | proto1.WireBytes)
+		n += proto1.SizeVarint(uint64(s))
+		n += s
 	case nil:
 	default:
 		panic(fmt.Sprintf("proto: unexpected type %T in oneof", x))
@@ -376,7 +445,7 @@ type Extent struct {
 func (m *Extent) Reset()                    { *m = Extent{} }
 func (m *Extent) String() string            { return proto1.CompactTextString(m) }
 func (*Extent) ProtoMessage()               {}
-func (*Extent) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+func (*Extent) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
 
 func (m *Extent) GetId() uint64 {
 	if m != nil {
@@ -436,7 +505,7 @@ type Log struct {
 func (m *Log) Reset()                    { *m = Log{} }
 func (m *Log) String() string            { return proto1.CompactTextString(m) }
 func (*Log) ProtoMessage()               {}
-func (*Log) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+func (*Log) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
 
 func (m *Log) GetClass() Log_Class {
 	if m != nil {
@@ -473,13 +542,40 @@ func (m *Log) GetExtents() []*Extent {
 	return nil
 }
 
+// Xattr is an extended attribute of an entry.
+type Xattr struct {
+	Key   string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Value []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (m *Xattr) Reset()                    { *m = Xattr{} }
+func (m *Xattr) String() string            { return proto1.CompactTextString(m) }
+func (*Xattr) ProtoMessage()               {}
+func (*Xattr) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *Xattr) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *Xattr) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
 func init() {
 	proto1.RegisterType((*Index)(nil), "proto.Index")
 	proto1.RegisterType((*Directory)(nil), "proto.Directory")
 	proto1.RegisterType((*File)(nil), "proto.File")
+	proto1.RegisterType((*Symlink)(nil), "proto.Symlink")
 	proto1.RegisterType((*Entry)(nil), "proto.Entry")
 	proto1.RegisterType((*Extent)(nil), "proto.Extent")
 	proto1.RegisterType((*Log)(nil), "proto.Log")
+	proto1.RegisterType((*Xattr)(nil), "proto.Xattr")
 	proto1.RegisterEnum("proto.Entry_Op", Entry_Op_name, Entry_Op_value)
 	proto1.RegisterEnum("proto.Log_Class", Log_Class_name, Log_Class_value)
 }
@@ -487,39 +583,45 @@ func init() {
 func init() { proto1.RegisterFile("bltfs.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 529 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x93, 0x61, 0x8b, 0xd3, 0x4c,
-	0x10, 0xc7, 0x2f, 0xc9, 0x26, 0x69, 0x27, 0xcf, 0xf5, 0x09, 0x8b, 0x48, 0x10, 0xc1, 0x18, 0xe4,
-	0xae, 0x08, 0xf6, 0xc5, 0xdd, 0x27, 0xd0, 0xd6, 0xda, 0xe2, 0xd9, 0xc2, 0xaa, 0xf8, 0x52, 0xd2,
-	0x64, 0xda, 0x5b, 0x2e, 0xcd, 0x86, 0xcd, 0x2a, 0xd7, 0x2f, 0x22, 0x7e, 0x2d, 0xbf, 0x91, 0x64,
-	0x37, 0x69, 0x7b, 0x54, 0xee, 0x55, 0x32, 0xff, 0xf9, 0xed, 0x30, 0xb3, 0xf3, 0x5f, 0x08, 0x56,
-	0x85, 0x5a, 0xd7, 0xa3, 0x4a, 0x0a, 0x25, 0xa8, 0xab, 0x3f, 0xc9, 0x67, 0x70, 0xe7, 0x65, 0x8e,
-	0xf7, 0x94, 0x02, 0xa9, 0x24, 0xfe, 0x8c, 0xac, 0xd8, 0x1a, 0x12, 0xa6, 0xff, 0xe9, 0x13, 0x70,
-	0x57, 0x85, 0xc8, 0xee, 0x22, 0x5b, 0x8b, 0x26, 0xa0, 0x31, 0x10, 0x29, 0x84, 0x8a, 0x9c, 0xd8,
-	0x1a, 0x06, 0x57, 0xff, 0x99, 0x7a, 0xa3, 0xf7, 0xa5, 0x92, 0x3b, 0xa6, 0x33, 0xc9, 0x35, 0xf4,
-	0x27, 0x5c, 0x62, 0xa6, 0x84, 0xdc, 0xd1, 0x0b, 0xf0, 0xb1, 0x54, 0x92, 0x63, 0x1d, 0xd9, 0xb1,
-	0x73, 0x72, 0xa2, 0x4b, 0x26, 0x1f, 0x80, 0x4c, 0x79, 0x81, 0xf4, 0x29, 0x78, 0x05, 0x96, 0x1b,
-	0x75, 0xdb, 0xb6, 0xd2, 0x46, 0xf4, 0x12, 0x7c, 0xbc, 0x57, 0x58, 0xaa, 0xae, 0xce, 0x79, 0x57,
-	0x47, 0xab, 0xac, 0xcb, 0x26, 0xbf, 0x1d, 0x70, 0x75, 0x6d, 0x3a, 0x00, 0x9b, 0xe7, 0x6d, 0x19,
-	0x9b, 0xe7, 0xcd, 0x8c, 0x65, 0xba, 0x45, 0x3d, 0x4e, 0x9f, 0xe9, 0x7f, 0xfa, 0x0c, 0x7a, 0x12,
-	0xd3, 0x5c, 0x94, 0xc5, 0x4e, 0x4f, 0xd4, 0x63, 0xfb, 0x98, 0xbe, 0x80, 0x20, 0x93, 0x98, 0x2a,
-	0xfc, 0xae, 0xf8, 0x16, 0x23, 0x12, 0x5b, 0x43, 0x87, 0x81, 0x91, 0xbe, 0xf0, 0x2d, 0x6a, 0xe0,
-	0x36, 0x2d, 0x37, 0x2d, 0xe0, 0xb6, 0x80, 0x96, 0x3a, 0x60, 0x2b, 0x72, 0xbe, 0xde, 0x19, 0xc0,
-	0x33, 0x80, 0x91, 0x3a, 0x20, 0xcd, 0x32, 0xac, 0x6b, 0x03, 0xf8, 0x06, 0x30, 0x52, 0x07, 0xac,
-	0xd2, 0xec, 0xee, 0x47, 0x65, 0x80, 0x9e, 0x01, 0x8c, 0xa4, 0x81, 0x37, 0xd0, 0x17, 0x15, 0xca,
-	0x54, 0x71, 0x51, 0x46, 0xfd, 0xd8, 0x1a, 0x0e, 0xae, 0xfe, 0x3f, 0xbe, 0xe1, 0xd1, 0xb2, 0x62,
-	0x07, 0x82, 0xbe, 0x04, 0xb2, 0xe6, 0x05, 0x46, 0xa0, 0xb7, 0x17, 0xb4, 0x64, 0x73, 0xf3, 0xb3,
-	0x33, 0xa6, 0x53, 0xf4, 0x15, 0x38, 0x39, 0x97, 0x51, 0xa0, 0x89, 0xb0, 0x25, 0xf6, 0x0b, 0x9d,
-	0x9d, 0xb1, 0x26, 0x9d, 0xbc, 0x06, 0x7b, 0x59, 0xd1, 0x00, 0xfc, 0xaf, 0x8b, 0x8f, 0x8b, 0xe5,
-	0xb7, 0x45, 0x78, 0x46, 0x7d, 0x70, 0xde, 0x4e, 0x26, 0xa1, 0x45, 0x3d, 0xb0, 0xd9, 0xa7, 0xd0,
-	0x6e, 0xbe, 0xe3, 0x59, 0xe8, 0xbc, 0xf3, 0x80, 0x60, 0x81, 0xdb, 0xe4, 0x97, 0x05, 0x9e, 0x59,
-	0xd7, 0xc9, 0x6e, 0x9e, 0x43, 0xbf, 0x4a, 0xa5, 0xe2, 0x7a, 0x8c, 0x66, 0x41, 0xe7, 0xec, 0x20,
-	0x1c, 0x9c, 0xe8, 0x1c, 0x3b, 0xf1, 0x60, 0x15, 0xf2, 0xc0, 0x2a, 0x11, 0xf8, 0x2b, 0xb1, 0x5e,
-	0xd7, 0xa8, 0xf4, 0x4a, 0x08, 0xeb, 0xc2, 0xe6, 0x44, 0x9b, 0xf0, 0xcc, 0x09, 0x13, 0x25, 0x7f,
-	0x2c, 0x70, 0x6e, 0xc4, 0x86, 0x5e, 0x80, 0x9b, 0x15, 0x69, 0x5d, 0xeb, 0xc6, 0x06, 0xfb, 0xe1,
-	0x6f, 0xc4, 0x66, 0x34, 0x6e, 0x74, 0x66, 0xd2, 0xfb, 0xd7, 0x62, 0xff, 0xeb, 0xb5, 0x3c, 0xe8,
-	0xf1, 0xc8, 0xfe, 0xe4, 0x11, 0xfb, 0x1f, 0xdb, 0xdb, 0x7d, 0xd4, 0xde, 0x97, 0xe0, 0xea, 0x56,
-	0x4e, 0xae, 0x7e, 0xbe, 0x18, 0x87, 0x16, 0xed, 0x01, 0x99, 0xcc, 0xa7, 0xd3, 0xd0, 0x5e, 0x79,
-	0xfa, 0xfc, 0xf5, 0xdf, 0x00, 0x00, 0x00, 0xff, 0xff, 0x8e, 0x8b, 0xae, 0x49, 0xf7, 0x03, 0x00,
-	0x00,
+	// 627 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x93, 0x6d, 0x6a, 0xdb, 0x40,
+	0x10, 0x86, 0xad, 0x6f, 0x7b, 0xec, 0xb8, 0x62, 0x29, 0x45, 0x94, 0x42, 0x1d, 0x11, 0x12, 0x13,
+	0xa8, 0x0b, 0xc9, 0x09, 0xda, 0xb8, 0xc6, 0xa1, 0xa9, 0x03, 0x9b, 0x96, 0xf4, 0x5f, 0x90, 0xe5,
+	0xb5, 0xb3, 0x58, 0xd2, 0x8a, 0xd5, 0x26, 0x8d, 0x2e, 0xd2, 0x7b, 0xf5, 0x1a, 0x3d, 0x45, 0xd9,
+	0x0f, 0xd9, 0x0e, 0x2e, 0xf9, 0x25, 0xcd, 0x3b, 0xcf, 0xee, 0x0e, 0x33, 0xf3, 0x42, 0x77, 0x9e,
+	0x89, 0x65, 0x35, 0x2a, 0x39, 0x13, 0x0c, 0x79, 0xea, 0x13, 0xdf, 0x80, 0x77, 0x59, 0x2c, 0xc8,
+	0x13, 0x42, 0xe0, 0x96, 0x9c, 0x3c, 0x46, 0xd6, 0xc0, 0x1a, 0xba, 0x58, 0xfd, 0xa3, 0xd7, 0xe0,
+	0xcd, 0x33, 0x96, 0xae, 0x23, 0x5b, 0x89, 0x3a, 0x40, 0x03, 0x70, 0x39, 0x63, 0x22, 0x72, 0x06,
+	0xd6, 0xb0, 0x7b, 0xd6, 0xd3, 0xf7, 0x8d, 0xbe, 0x14, 0x82, 0xd7, 0x58, 0x65, 0xe2, 0x73, 0xe8,
+	0x8c, 0x29, 0x27, 0xa9, 0x60, 0xbc, 0x46, 0xc7, 0x10, 0x90, 0x42, 0x70, 0x4a, 0xaa, 0xc8, 0x1e,
+	0x38, 0x7b, 0x27, 0x9a, 0x64, 0x9c, 0x83, 0x3b, 0xa1, 0x19, 0x41, 0x6f, 0xc0, 0xcf, 0x48, 0xb1,
+	0x12, 0xf7, 0xa6, 0x14, 0x13, 0xa1, 0x13, 0x08, 0xc8, 0x93, 0x20, 0x85, 0x68, 0xee, 0x39, 0x68,
+	0xee, 0x51, 0x2a, 0x6e, 0xb2, 0xe8, 0x08, 0xfa, 0xac, 0x24, 0xc5, 0xdd, 0x92, 0xf1, 0xbb, 0x5f,
+	0x9c, 0x0a, 0xa2, 0x2a, 0x6d, 0xe3, 0x9e, 0x54, 0x27, 0x8c, 0xdf, 0x4a, 0x2d, 0x3e, 0x84, 0xe0,
+	0xa6, 0xce, 0x33, 0x5a, 0xac, 0xe5, 0x8b, 0x22, 0xe1, 0x2b, 0x22, 0xd4, 0x8b, 0x1d, 0x6c, 0xa2,
+	0xf8, 0xaf, 0x03, 0x9e, 0x2a, 0x12, 0xf5, 0xc1, 0xa6, 0x0b, 0x53, 0x8f, 0x4d, 0x17, 0xb2, 0x59,
+	0x45, 0x92, 0x13, 0xd5, 0x97, 0x0e, 0x56, 0xff, 0xe8, 0x2d, 0xb4, 0x39, 0x49, 0x16, 0xac, 0xc8,
+	0x6a, 0xf3, 0xe0, 0x26, 0x46, 0xef, 0xa1, 0x9b, 0x72, 0x92, 0x08, 0x72, 0x27, 0x68, 0x4e, 0x22,
+	0x77, 0x60, 0x0d, 0x1d, 0x0c, 0x5a, 0xfa, 0x4e, 0x73, 0xa2, 0x80, 0xfb, 0xa4, 0x58, 0x19, 0xc0,
+	0x33, 0x80, 0x92, 0x1a, 0x20, 0x67, 0x0b, 0xba, 0xac, 0x35, 0xe0, 0x6b, 0x40, 0x4b, 0x0d, 0x90,
+	0xa4, 0x29, 0xa9, 0x2a, 0x0d, 0x04, 0x1a, 0xd0, 0x52, 0x03, 0xcc, 0x93, 0x74, 0xfd, 0x50, 0x6a,
+	0xa0, 0xad, 0x01, 0x2d, 0x29, 0xe0, 0x03, 0x74, 0x58, 0x49, 0x78, 0x22, 0x28, 0x2b, 0xa2, 0xce,
+	0xc0, 0x1a, 0xf6, 0xcf, 0x5e, 0xed, 0x8e, 0x6a, 0x74, 0x5d, 0xe2, 0x2d, 0x81, 0x8e, 0xc0, 0x7f,
+	0x4a, 0x84, 0xe0, 0x55, 0xd4, 0x7b, 0x36, 0xd6, 0x9f, 0x52, 0xc4, 0x26, 0x87, 0x0e, 0xc1, 0x5d,
+	0xd2, 0x8c, 0x44, 0xa0, 0x96, 0xa5, 0x6b, 0x18, 0x39, 0xe8, 0x69, 0x0b, 0xab, 0x14, 0x3a, 0x02,
+	0x67, 0x41, 0x79, 0xd4, 0x55, 0x44, 0x68, 0x88, 0xcd, 0xfe, 0x4c, 0x5b, 0x58, 0xa6, 0xd1, 0x29,
+	0x04, 0x95, 0x9e, 0x57, 0x74, 0xa0, 0xc8, 0xbe, 0x21, 0xcd, 0x14, 0xa7, 0x2d, 0xdc, 0x00, 0xf1,
+	0x29, 0xd8, 0xd7, 0x25, 0xea, 0x42, 0xf0, 0x63, 0xf6, 0x75, 0x76, 0x7d, 0x3b, 0x0b, 0x5b, 0x28,
+	0x00, 0xe7, 0xd3, 0x78, 0x1c, 0x5a, 0xc8, 0x07, 0x1b, 0x7f, 0x0b, 0x6d, 0xf9, 0xbd, 0x98, 0x86,
+	0xce, 0x67, 0x1f, 0x5c, 0x92, 0x91, 0x3c, 0xfe, 0x6d, 0x81, 0xaf, 0x37, 0x69, 0x6f, 0xda, 0xef,
+	0xa0, 0x53, 0x26, 0x5c, 0x50, 0xd5, 0x18, 0x39, 0xf2, 0x03, 0xbc, 0x15, 0xb6, 0x26, 0x71, 0x76,
+	0x4d, 0xb2, 0xdd, 0x62, 0xf7, 0xd9, 0x16, 0x47, 0x10, 0xcc, 0xd9, 0x72, 0x59, 0x11, 0xa1, 0x86,
+	0xec, 0xe2, 0x26, 0x94, 0x27, 0x4c, 0xc2, 0xd7, 0x27, 0x74, 0x14, 0xff, 0xb1, 0xc0, 0xb9, 0x62,
+	0x2b, 0x74, 0x0c, 0x5e, 0x9a, 0x25, 0x55, 0xa5, 0x0a, 0xeb, 0x6f, 0x1a, 0x75, 0xc5, 0x56, 0xa3,
+	0x0b, 0xa9, 0x63, 0x9d, 0xde, 0x18, 0xd9, 0xfe, 0x9f, 0x91, 0x9f, 0xd5, 0xb8, 0xe3, 0x4c, 0xf7,
+	0x05, 0x67, 0xee, 0x3a, 0xcf, 0x7b, 0xc9, 0x79, 0xf1, 0x09, 0x78, 0xaa, 0x94, 0xbd, 0xd6, 0x5f,
+	0xce, 0x2e, 0x42, 0x0b, 0xb5, 0xc1, 0x1d, 0x5f, 0x4e, 0x26, 0xa1, 0x1d, 0x7f, 0x04, 0x4f, 0xad,
+	0x09, 0x0a, 0xc1, 0x59, 0x93, 0xda, 0xf8, 0x4e, 0xfe, 0xca, 0x52, 0x1f, 0x93, 0xec, 0x41, 0x7b,
+	0xab, 0x87, 0x75, 0x30, 0xf7, 0xd5, 0x83, 0xe7, 0xff, 0x06, 0x00, 0x21, 0x33, 0x60, 0xc1, 0xc3,
+	0x04, 0x00, 0x00,
 }
@@ -0,0 +1,128 @@
+package proto_test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/kr/pretty"
+
+	"hpt.space/bltfs/ltfs"
+	"hpt.space/bltfs/proto"
+	"hpt.space/bltfs/util/testutil"
+)
+
+func makeTestFile(pmap ltfs.PartitionMap) *ltfs.File {
+	return &ltfs.File{
+		XMLName:      xml.Name{Space: "", Local: "file"},
+		FileUID:      4,
+		Name:         "testfile.txt",
+		Length:       10,
+		CreationTime: testutil.TestTime,
+		ChangeTime:   testutil.TestTime,
+		ModifyTime:   testutil.TestTime,
+		AccessTime:   testutil.TestTime,
+		BackupTime:   testutil.TestTime,
+		ReadOnly:     true,
+		ExtentInfo: []*ltfs.Extent{
+			// an extent on the index partition
+			&ltfs.Extent{
+				Partition:  pmap.Index,
+				StartBlock: 4,
+				ByteOffset: 0,
+				ByteCount:  5,
+				FileOffset: 0,
+			},
+			// an extent on the data partition
+			&ltfs.Extent{
+				Partition:  pmap.Data,
+				StartBlock: 9,
+				ByteOffset: 16,
+				ByteCount:  5,
+				FileOffset: 5,
+			},
+		},
+	}
+}
+
+func TestExtentRoundTrip(t *testing.T) {
+	pmaps := []ltfs.PartitionMap{
+		ltfs.DefaultPartitionMap,
+		{Index: "b", Data: "a"},
+		{Index: "x", Data: "y"},
+	}
+
+	for _, pmap := range pmaps {
+		file0 := makeTestFile(pmap)
+
+		var entry proto.Entry
+		if err := proto.MarshalFile(file0, &entry, pmap); err != nil {
+			t.Fatal(err)
+		}
+
+		extents := entry.GetFile().Extents
+		if extents[0].Partition != ltfs.IndexPartition {
+			t.Errorf("%v: expected first extent on the index partition", pmap)
+		}
+
+		if extents[1].Partition != ltfs.DataPartition {
+			t.Errorf("%v: expected second extent on the data partition", pmap)
+		}
+
+		file1, err := entry.MakeFile(pmap)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(file0.ExtentInfo, file1.ExtentInfo) {
+			fmt.Println("EXPECTED:")
+			pretty.Println(file0.ExtentInfo)
+			fmt.Println("GOT:")
+			pretty.Println(file1.ExtentInfo)
+
+			t.Errorf("%v: file0.ExtentInfo != file1.ExtentInfo", pmap)
+		}
+	}
+}
+
+func TestExtentUnknownPartition(t *testing.T) {
+	file := makeTestFile(ltfs.PartitionMap{Index: "x", Data: "y"})
+
+	var entry proto.Entry
+	if err := proto.MarshalFile(file, &entry, ltfs.DefaultPartitionMap); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	ex := &proto.Extent{Partition: 7}
+	if _, err := ex.MakeExtent(ltfs.DefaultPartitionMap); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestSymlinkRoundTrip(t *testing.T) {
+	file0 := &ltfs.File{
+		XMLName: xml.Name{Space: "", Local: "file"},
+		FileUID: 5,
+		Name:    "link",
+		Symlink: "../target",
+	}
+
+	var entry proto.Entry
+	if err := proto.MarshalFile(file0, &entry, ltfs.DefaultPartitionMap); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.GetSymlink().GetTarget() != "../target" {
+		t.Fatalf("unexpected entry %v", entry)
+	}
+
+	file1, err := entry.MakeFile(ltfs.DefaultPartitionMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if file1.Symlink != file0.Symlink || len(file1.ExtentInfo) != 0 {
+		t.Errorf("unexpected file %v", file1)
+	}
+}
@@ -0,0 +1,102 @@
+package bltfs
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"time"
+
+	"hpt.space/bltfs/proto"
+)
+
+// A Query selects entries of the store by their metadata. The zero Query
+// selects all entries; each set field narrows the selection.
+type Query struct {
+	// Name is a pattern (see path.Match) the name of the entry must match.
+	Name string
+
+	// MinSize and MaxSize bound the size of files and symbolic links; if
+	// either is set, directories are not selected. A MaxSize of zero is
+	// unbounded.
+	MinSize int64
+	MaxSize int64
+
+	// ModifiedFrom and ModifiedTo bound the modification time of the entry;
+	// ModifiedFrom is inclusive and ModifiedTo is exclusive. The zero time
+	// is unbounded.
+	ModifiedFrom time.Time
+	ModifiedTo   time.Time
+
+	// Xattr is the name of an extended attribute the entry must have. If
+	// XattrValue is not nil, the attribute must have that value.
+	Xattr      string
+	XattrValue []byte
+}
+
+// Match reports whether the entry described by info is selected by the
+// query. The info must have been returned by the store.
+func (q *Query) Match(info os.FileInfo) bool {
+	e, ok := info.Sys().(*proto.Entry)
+	if !ok {
+		return false
+	}
+
+	if q.Name != "" {
+		if ok, _ := path.Match(q.Name, e.Name); !ok {
+			return false
+		}
+	}
+
+	if q.MinSize != 0 || q.MaxSize != 0 {
+		if info.IsDir() {
+			return false
+		}
+
+		size := info.Size()
+		if size < q.MinSize || (q.MaxSize != 0 && size > q.MaxSize) {
+			return false
+		}
+	}
+
+	if !q.ModifiedFrom.IsZero() && e.ModifyTime < q.ModifiedFrom.UnixNano() {
+		return false
+	}
+
+	if !q.ModifiedTo.IsZero() && e.ModifyTime >= q.ModifiedTo.UnixNano() {
+		return false
+	}
+
+	if q.Xattr != "" {
+		for _, x := range e.Xattrs {
+			if x.Key == q.Xattr {
+				return q.XattrValue == nil || bytes.Equal(x.Value, q.XattrValue)
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// Find calls fn for each entry in the tree rooted at root that is selected by
+// the query, in the order of Walk. If fn returns an error, Find stops and
+// returns the error. If there is an error reading the tree, it will be of
+// type *os.PathError.
+func (s *Store) Find(root string, q Query, fn func(path string, info os.FileInfo) error) error {
+	if _, err := path.Match(q.Name, ""); err != nil {
+		return err
+	}
+
+	return s.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !q.Match(info) {
+			return nil
+		}
+
+		return fn(p, info)
+	})
+}
@@ -0,0 +1,543 @@
+package bltfs
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"syscall"
+
+	pb "github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+
+	"hpt.space/bltfs/ltfs"
+	"hpt.space/bltfs/proto"
+)
+
+// ErrModified is returned when recovering a store that was modified since it
+// was mounted.
+var ErrModified = errors.New("store was modified since it was mounted")
+
+// RecoveryReport describes the changes recovered from the logs on the data
+// partition (see Store.Recover).
+type RecoveryReport struct {
+	// Logs holds the logs applied to the index, oldest first: the newest
+	// differential log, if any, and the incremental logs after it.
+	Logs []*proto.Log
+
+	// Partial holds the paths of the files that were still open when the
+	// logs were written. Their content is recovered from the extents logged
+	// while they were written.
+	Partial []string
+
+	// Orphaned holds the data records after the last log (or the last index
+	// if there are no logs) that are not referenced by any file in the
+	// recovered index.
+	Orphaned []BlockRange
+
+	// Dropped holds the features left out of the recovered index written to
+	// the volume (see Store.Sync).
+	Dropped []ltfs.Feature
+}
+
+// Recover applies the logs written to the data partition after the mounted
+// index (see RecoveryPolicy) to the binary index, as needed after a crash
+// kept a full index from being written. The chain of logs is followed back
+// from the last log on the partition to the newest differential log or the
+// start of the chain and replayed oldest first, in a single transaction.
+// The recovered index is then written as a new index generation (see Sync),
+// so the logs are not replayed again after another crash.
+//
+// Recover must be called before the store is modified; otherwise it returns
+// ErrModified.
+func (s *Store) Recover() (*RecoveryReport, error) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+
+	s.rw.mu.Lock()
+	defer s.rw.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if atomic.LoadInt32(&s.idx.modified) != 0 {
+		return nil, ErrModified
+	}
+
+	if err := s.rw.flushBatch(); err != nil {
+		return nil, err
+	}
+
+	report := &RecoveryReport{}
+
+	chain, err := s.logChain()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read logs")
+	}
+
+	start, eod, err := s.dataTail()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find orphaned data")
+	}
+
+	if err := s.seekEOD(); err != nil {
+		return nil, err
+	}
+
+	report.Logs = chain
+
+	if len(chain) > 0 {
+		r, err := s.idx.replay(chain)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to replay logs")
+		}
+
+		for _, e := range r.released {
+			if err := s.release(e); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := s.applyVolume(r.volume); err != nil {
+			return nil, err
+		}
+
+		report.Partial = r.partial
+	}
+
+	if report.Orphaned, err = s.orphanedData(start, eod); err != nil {
+		return nil, errors.Wrap(err, "failed to find orphaned data")
+	}
+
+	if len(chain) == 0 {
+		return report, nil
+	}
+
+	if report.Dropped, err = s.sync(); err != nil {
+		return nil, errors.Wrap(err, "failed to write recovered index")
+	}
+
+	return report, nil
+}
+
+// logChain returns the chain of logs to recover, oldest first. The last log
+// is the last file on the data partition, possibly followed by data records.
+// The caller must hold the device.
+func (s *Store) logChain() ([]*proto.Log, error) {
+	dev := s.mu.backend
+
+	if err := dev.Locate(ltfs.DataPartition, TapeBlockMax); err != nil {
+		return nil, errors.Wrap(err, "failed to seek to EOD")
+	}
+
+	if err := dev.SpaceFMB(2); err != nil {
+		if err == ErrBOT {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "failed to space backward")
+	}
+
+	block, err := dev.ReadPosition()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read position")
+	}
+
+	// logs before the mounted index are part of it
+	floor := s.indexBlock()
+
+	var chain []*proto.Log
+	for block > floor {
+		l, err := s.readLog(block)
+		if err != nil {
+			return nil, err
+		}
+
+		if l == nil {
+			break
+		}
+
+		chain = append(chain, l)
+
+		// a differential log holds all changes since its epoch, and the
+		// back pointers go backwards
+		if l.Class == proto.Log_DIFF || l.Prev >= l.Block {
+			break
+		}
+
+		block = l.Prev
+	}
+
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+
+	return chain, nil
+}
+
+// indexBlock returns the block of the mounted index on the data partition, or
+// of its previous generation if the mounted index is on the index partition.
+// It returns 0 if neither is on the data partition.
+func (s *Store) indexBlock() uint64 {
+	curr := s.ltfs.curr
+
+	locs := []struct {
+		part  string
+		block int
+	}{
+		{curr.Partition, curr.StartBlock},
+		{curr.PreviousGeneration.Partition, curr.PreviousGeneration.StartBlock},
+	}
+
+	for _, loc := range locs {
+		if part, err := s.ltfs.pmap.Number(loc.part); err == nil && part == ltfs.DataPartition && loc.block > 0 {
+			return uint64(loc.block)
+		}
+	}
+
+	return 0
+}
+
+// readLog reads the log starting at the given block of the data partition. It
+// returns nil if there is no log at the block. The caller must hold the
+// device.
+func (s *Store) readLog(block uint64) (*proto.Log, error) {
+	dev := s.mu.backend
+
+	if err := dev.Locate(ltfs.DataPartition, block); err != nil {
+		return nil, errors.Wrap(err, "failed to locate log")
+	}
+
+	buf := make([]byte, dev.BlockSize())
+
+	n, err := dev.Read(buf)
+	if err == ErrEOD || err == nil && n == 0 {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// a log starts with its class; other files are not read to their end
+	head := pb.NewBuffer(buf[:n])
+	if tag, err := head.DecodeVarint(); err != nil || tag != 1<<3 {
+		return nil, nil
+	}
+
+	if class, err := head.DecodeVarint(); err != nil || class != uint64(proto.Log_INC) && class != uint64(proto.Log_DIFF) {
+		return nil, nil
+	}
+
+	rest, err := ioutil.ReadAll(s.newRecordReader())
+	if err != nil {
+		return nil, err
+	}
+
+	var l proto.Log
+	if err := pb.Unmarshal(append(buf[:n], rest...), &l); err != nil || l.Block != block {
+		return nil, nil
+	}
+
+	return &l, nil
+}
+
+// dataTail returns the range of records after the last filemark on the data
+// partition, which follow the last log or index. The caller must hold the
+// device.
+func (s *Store) dataTail() (start, eod uint64, err error) {
+	dev := s.mu.backend
+
+	if eod, err = s.eod(ltfs.DataPartition); err != nil {
+		return 0, 0, err
+	}
+
+	if err := dev.SpaceFMB(1); err != nil {
+		if err == ErrBOT {
+			return eod, eod, nil
+		}
+
+		return 0, 0, errors.Wrap(err, "failed to space backward")
+	}
+
+	if start, err = dev.ReadPosition(); err != nil {
+		return 0, 0, errors.Wrap(err, "failed to read position")
+	}
+
+	return start, eod, nil
+}
+
+// orphanedData returns the records in the blocks [start, eod) of the data
+// partition that are not referenced by any extent in the index, as
+// Check does for the newest generation.
+func (s *Store) orphanedData(start, eod uint64) ([]BlockRange, error) {
+	var (
+		orphaned []BlockRange
+		orphan   *BlockRange
+	)
+
+	for blk := start; blk < eod; blk++ {
+		owners, err := s.idx.blockOwners(ltfs.DataPartition, blk, s.idx.blkSize)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(owners) > 0 {
+			orphan = nil
+			continue
+		}
+
+		if orphan == nil {
+			orphaned = append(orphaned, BlockRange{Partition: ltfs.DataPartition, Start: blk})
+			orphan = &orphaned[len(orphaned)-1]
+		}
+
+		orphan.End = blk + 1
+	}
+
+	return orphaned, nil
+}
+
+// replayed describes the changes replayed from the logs (see index.replay).
+type replayed struct {
+	// the entries whose extents are no longer referenced
+	released []*proto.Entry
+
+	// the paths of the files recovered from their logged extents
+	partial []string
+
+	// the volume state last logged with the root directory (see
+	// Store.recordVolume)
+	volume []*proto.Xattr
+}
+
+// replay applies the entries of the logs, oldest first. Files without content
+// get the extents logged for them, as they were still open when the logs
+// were written.
+func (idx *index) replay(logs []*proto.Log) (*replayed, error) {
+	r := &replayed{}
+
+	err := idx.write(func(t *indexTx) error {
+		extents := make(map[uint64][]*proto.Extent)
+
+		for _, l := range logs {
+			for _, e := range l.Entries {
+				if cleanPath(e.Name) == "/" {
+					volume, err := t.replayRoot(e)
+					if err != nil {
+						return errors.Wrapf(err, "%v %s", e.Operation, e.Name)
+					}
+
+					// changes of its own attributes carry no volume state
+					if len(volume) > 0 {
+						r.volume = volume
+					}
+
+					continue
+				}
+
+				old, err := t.replay(e)
+				if err != nil {
+					return errors.Wrapf(err, "%v %s", e.Operation, e.Name)
+				}
+
+				if old != nil {
+					r.released = append(r.released, old)
+				}
+			}
+
+			for _, ex := range l.Extents {
+				extents[ex.Id] = append(extents[ex.Id], ex)
+			}
+		}
+
+		uids := make([]uint64, 0, len(extents))
+		for uid := range extents {
+			uids = append(uids, uid)
+		}
+
+		sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+
+		for _, uid := range uids {
+			ok, err := t.recoverExtents(uid, extents[uid], idx)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				continue
+			}
+
+			path, err := t.path(uid)
+			if err != nil {
+				return err
+			}
+
+			r.partial = append(r.partial, path)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// replayRoot applies a logged change of the root directory, which keeps its
+// name and place. The reserved extended attributes holding the volume state
+// are returned instead of being stored with the entry.
+func (t *indexTx) replayRoot(e *proto.Entry) ([]*proto.Xattr, error) {
+	if e.Operation != proto.Entry_CH {
+		return nil, errors.New("cannot replay the root directory")
+	}
+
+	uid, old, err := t.lookup("/")
+	if err != nil {
+		return nil, err
+	}
+
+	entry := pb.Clone(e).(*proto.Entry)
+	entry.Id = uid
+	entry.Name = old.Name
+	entry.Operation = proto.Entry_UNKNOWN
+	entry.Xattrs = nil
+
+	var volume []*proto.Xattr
+	for _, x := range e.Xattrs {
+		if isReservedXattr(x.Key) {
+			volume = append(volume, x)
+		} else {
+			entry.Xattrs = append(entry.Xattrs, x)
+		}
+	}
+
+	return volume, t.put(entry)
+}
+
+// replay applies the logged entry e, named by its full path. Entries are
+// matched by file UID, so a changed entry is moved to its logged path. It
+// returns the previous entry if its extents are no longer referenced.
+func (t *indexTx) replay(e *proto.Entry) (*proto.Entry, error) {
+	path := cleanPath(e.Name)
+
+	old, err := t.get(e.Id)
+	if err != nil && err != os.ErrNotExist {
+		return nil, err
+	}
+
+	if old != nil {
+		if err := t.unlink(old); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.Operation == proto.Entry_RM {
+		if old == nil {
+			return nil, nil
+		}
+
+		if old.GetDir() != nil {
+			if err := t.unlinkAll(old.Id); err != nil {
+				return nil, err
+			}
+		}
+
+		return old, t.del(old.Id)
+	}
+
+	entry := pb.Clone(e).(*proto.Entry)
+	entry.Name = filepath.Base(path)
+	entry.Operation = proto.Entry_UNKNOWN
+
+	dir, parent, err := t.lookup(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	if parent.GetDir() == nil {
+		return nil, syscall.ENOTDIR
+	}
+
+	if other, ok := t.child(dir, entry.Name); ok && other != entry.Id {
+		return nil, errors.Errorf("file UID %d is in the way", other)
+	}
+
+	if err := t.put(entry); err != nil {
+		return nil, err
+	}
+
+	if err := t.link(dir, entry.Name, entry.Id); err != nil {
+		return nil, err
+	}
+
+	if old != nil && !equalExtents(old.GetFile().GetExtents(), entry.GetFile().GetExtents()) {
+		return old, nil
+	}
+
+	return nil, nil
+}
+
+// unlink removes the directory entry of the entry e.
+func (t *indexTx) unlink(e *proto.Entry) error {
+	v := t.parents.Get(uidKey(e.Id))
+	if v == nil {
+		return nil
+	}
+
+	// the root directory is named by the empty name
+	name := t.policy.key(e.Name)
+	if binary.BigEndian.Uint64(v) == 0 {
+		name = ""
+	}
+
+	return t.dirents.Delete(direntKey(binary.BigEndian.Uint64(v), name))
+}
+
+// recoverExtents gives the file with the given UID the logged extents es, if
+// the file has no content. Only the extents written since the file was last
+// opened, the last of which starts at offset 0, are used.
+func (t *indexTx) recoverExtents(uid uint64, es []*proto.Extent, idx *index) (bool, error) {
+	e, err := t.get(uid)
+	if err == os.ErrNotExist {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	f := e.GetFile()
+	if f == nil || f.Length > 0 || len(f.Extents) > 0 {
+		return false, nil
+	}
+
+	for i := len(es) - 1; i >= 0; i-- {
+		if es[i].Offset == 0 {
+			es = es[i:]
+			break
+		}
+	}
+
+	for _, ex := range es {
+		if n := len(f.Extents); n > 0 && idx.contiguous(f.Extents[n-1], ex) {
+			f.Extents[n-1].Length += ex.Length
+		} else {
+			ex = pb.Clone(ex).(*proto.Extent)
+			ex.Id = 0
+
+			f.Extents = append(f.Extents, ex)
+		}
+
+		if end := ex.Offset + ex.Length; end > f.Length {
+			f.Length = end
+		}
+	}
+
+	return true, t.put(e)
+}
@@ -0,0 +1,341 @@
+package bltfs_test
+
+import (
+	"reflect"
+	"testing"
+
+	pb "github.com/golang/protobuf/proto"
+
+	"hpt.space/bltfs"
+	"hpt.space/bltfs/ltfs"
+	"hpt.space/bltfs/proto"
+)
+
+func recoverTestStore(t *testing.T, dir string) (*bltfs.Store, *bltfs.RecoveryReport) {
+	store, err := bltfs.Open(openTestDevice(t, dir), bltfs.WithRecoveryPolicy(bltfs.RecoveryPolicy{
+		IncrementalAfter: 4,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := store.Recover()
+	if err != nil {
+		store.Close()
+		t.Fatal(err)
+	}
+
+	return store, report
+}
+
+func TestRecover(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex(), bltfs.WithRecoveryPolicy(bltfs.RecoveryPolicy{
+		IncrementalAfter: 4,
+	}))
+	defer cleanup(dir)
+
+	// an incremental log is written after each 4 bytes
+	writeTestFile(t, store, "/a", []byte("abcd"))
+
+	if err := store.Remove("/dir/file"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Mkdir("/new"); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, store, "/new/b", []byte("efgh"))
+
+	// the data of /c is not followed by a log
+	c, err := store.Create("/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Write([]byte("ij")); err != nil {
+		t.Fatal(err)
+	}
+
+	// crash without writing an index
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first log reserves UIDs
+	logs := readTestLogs(t, dir)
+	if len(logs) != 3 {
+		t.Fatalf("expected 3 logs, got %d", len(logs))
+	}
+
+	store, report := recoverTestStore(t, dir)
+
+	if len(report.Logs) != 3 {
+		t.Errorf("unexpected logs %v", report.Logs)
+	} else {
+		for i, l := range report.Logs {
+			if l.Block != logs[i].Block {
+				t.Errorf("unexpected logs %v", report.Logs)
+				break
+			}
+		}
+	}
+
+	// /new/b was not closed before the last log
+	if len(report.Partial) != 1 || report.Partial[0] != "/new/b" {
+		t.Errorf("unexpected partial files %v", report.Partial)
+	}
+
+	orphan := logs[2].Block + 2
+	if len(report.Orphaned) != 1 || report.Orphaned[0] != (bltfs.BlockRange{Partition: ltfs.DataPartition, Start: orphan, End: orphan + 1}) {
+		t.Errorf("expected orphaned block %d, got %v", orphan, report.Orphaned)
+	}
+
+	expectContent(t, store, "/a", []byte("abcd"))
+	expectContent(t, store, "/new/b", []byte("efgh"))
+
+	for _, name := range []string{"/dir/file", "/c"} {
+		if _, err := store.Stat(name); err == nil {
+			t.Errorf("%s: expected entry to be gone", name)
+		}
+	}
+
+	if n := store.UnreferencedBytes(); n != 10 {
+		t.Errorf("expected 10 unreferenced bytes, got %d", n)
+	}
+
+	if st := statfs(t, store); st.Files != 3 || st.Directories != 3 || st.LogicalBytes != 13 {
+		t.Errorf("unexpected statistics %+v", st)
+	}
+
+	// the store can only be recovered before it is modified
+	writeTestFile(t, store, "/d", []byte("klmn"))
+
+	if _, err := store.Recover(); err != bltfs.ErrModified {
+		t.Errorf("expected ErrModified, got %v", err)
+	}
+
+	// the recovered index was written, so the journal starts over
+	if n := len(store.Journal().Entries); n != 2 {
+		t.Errorf("expected 2 journal entries, got %d", n)
+	}
+
+	gens, err := store.Generations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g := gens[0]; g.Number != 2 || g.Files != 3 {
+		t.Errorf("unexpected recovered generation %+v", g)
+	}
+
+	var index uint64
+	for _, g := range gens {
+		if g.Partition == ltfs.DataPartition {
+			index = g.Block
+			break
+		}
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next logs, reserving UIDs again and holding /d, point back to
+	// the recovered index
+	logs = readTestLogs(t, dir)
+	if len(logs) != 5 || logs[3].Prev != index || logs[4].Prev != logs[3].Block {
+		t.Fatalf("expected two more logs after %d, got %v", index, logs)
+	}
+
+	// only the logs after the recovered index are replayed
+	store, report = recoverTestStore(t, dir)
+	defer store.Close()
+
+	if len(report.Logs) != 2 || report.Logs[0].Block != logs[3].Block || report.Logs[1].Block != logs[4].Block || len(report.Orphaned) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	expectContent(t, store, "/new/b", []byte("efgh"))
+	expectContent(t, store, "/d", []byte("klmn"))
+	expectUID(t, store, 5, "/a")
+}
+
+func TestRecoverNoLogs(t *testing.T) {
+	dir := makeFsckTape(t, nil)
+	defer cleanup(dir)
+
+	store, err := bltfs.Open(openTestDevice(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, store, "/a", []byte("abcd"))
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, report := recoverTestStore(t, dir)
+	defer store.Close()
+
+	// the data of /a follows the last index
+	if len(report.Logs) != 0 || len(report.Orphaned) != 1 || report.Orphaned[0] != (bltfs.BlockRange{Partition: ltfs.DataPartition, Start: 10, End: 11}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	if _, err := store.Stat("/a"); err == nil {
+		t.Error("expected /a to be gone")
+	}
+
+	if _, err := store.Stat("/file1"); err != nil {
+		t.Error(err)
+	}
+
+	if log := store.Journal(); len(log.Entries) != 0 {
+		t.Errorf("unexpected journal %v", log.Entries)
+	}
+}
+
+func TestRecoverVolume(t *testing.T) {
+	idx := makeTestIndex()
+	idx.AllowPolicyUpdate = true
+
+	store, dir := openTestStore(t, idx, bltfs.WithRecoveryPolicy(bltfs.RecoveryPolicy{
+		IncrementalAfter: 4,
+	}))
+	defer cleanup(dir)
+
+	if err := store.Setxattr("/", "user.root", []byte("r")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Setxattr("/testfile.txt", "user.a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	pol := ltfs.DataPlacementPolicy{Size: 16, Name: []string{"*.txt"}}
+	if err := store.SetDataPlacementPolicy(pol); err != nil {
+		t.Fatal(err)
+	}
+
+	// the changes are logged with the data
+	writeTestFile(t, store, "/a", []byte("abcd"))
+
+	// crash without writing an index
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, _ = recoverTestStore(t, dir)
+	defer store.Close()
+
+	for _, tt := range []struct{ path, name, value string }{
+		{"/", "user.root", "r"},
+		{"/testfile.txt", "user.a", "1"},
+	} {
+		if v, err := store.Getxattr(tt.path, tt.name); err != nil || string(v) != tt.value {
+			t.Errorf("%s: expected %s=%q, got %q (%v)", tt.path, tt.name, tt.value, v, err)
+		}
+	}
+
+	// the volume state is not stored with the root directory
+	if names, err := store.Listxattr("/"); err != nil || !reflect.DeepEqual(names, []string{"user.root"}) {
+		t.Errorf("unexpected root attributes %v (%v)", names, err)
+	}
+
+	if got := store.DataPlacementPolicy(); !reflect.DeepEqual(got, pol) {
+		t.Errorf("expected policy %+v, got %+v", pol, got)
+	}
+}
+
+func TestRecoverReferencedData(t *testing.T) {
+	dir := makeFsckTape(t, nil)
+	defer cleanup(dir)
+
+	// a log after generation 2 adds /x, whose data follows the log
+	l := &proto.Log{
+		Class: proto.Log_INC,
+		Prev:  8,
+		Block: 10,
+		Entries: []*proto.Entry{{
+			Id:        10,
+			Name:      "/x",
+			Operation: proto.Entry_ADD,
+			Elem: &proto.Entry_File{File: &proto.File{
+				Length:  3,
+				Extents: []*proto.Extent{{Partition: ltfs.DataPartition, Block: 12, Length: 3}},
+			}},
+		}},
+	}
+
+	buf, err := pb.Marshal(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestRecords(t, dir, ltfs.DataPartition, [][]byte{buf, nil, []byte("xyz"), []byte("orphan")})
+
+	store, report := recoverTestStore(t, dir)
+
+	// only the record after the data of /x is orphaned
+	if len(report.Orphaned) != 1 || report.Orphaned[0] != (bltfs.BlockRange{Partition: ltfs.DataPartition, Start: 13, End: 14}) {
+		t.Errorf("unexpected orphaned data %v", report.Orphaned)
+	}
+
+	expectContent(t, store, "/x", []byte("xyz"))
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the recovered index was written as generation 3
+	report2 := checkTestTape(t, dir)
+	if !report2.Consistent() {
+		t.Fatalf("unexpected problems: %v", report2.Problems)
+	}
+
+	if gens := generationNumbers(report2); !reflect.DeepEqual(gens, []int{3, 2, 1}) {
+		t.Fatalf("unexpected generations %v", gens)
+	}
+
+	store, err = bltfs.Open(openTestDevice(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	expectContent(t, store, "/x", []byte("xyz"))
+	expectUID(t, store, 10, "/x")
+}
+
+func TestRecoverReadOnly(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex(), bltfs.WithRecoveryPolicy(bltfs.RecoveryPolicy{
+		IncrementalAfter: 4,
+	}))
+	defer cleanup(dir)
+	defer store.Close()
+
+	if err := store.SetReadOnly("/testfile.txt", true); err != nil {
+		t.Fatal(err)
+	}
+
+	// the change is logged with the data
+	writeTestFile(t, store, "/a", []byte("abcd"))
+
+	// the store crashes without closing or writing an index
+	crashed := copyTestDir(t, dir)
+	defer cleanup(crashed)
+
+	store, _ = recoverTestStore(t, crashed)
+	defer store.Close()
+
+	fi, err := store.Stat("/testfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !fi.Sys().(*proto.Entry).Readonly {
+		t.Error("expected /testfile.txt to be read-only after recovery")
+	}
+}
@@ -0,0 +1,207 @@
+package bltfs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"hpt.space/bltfs/proto"
+)
+
+// Remove removes the file, symbolic link or empty directory at path. If there
+// is an error, it will be of type *os.PathError.
+func (s *Store) Remove(path string) error {
+	if err := s.writable(); err != nil {
+		return &os.PathError{Op: "remove", Path: path, Err: err}
+	}
+
+	removed, err := s.idx.remove(cleanPath(path), false)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: path, Err: err}
+	}
+
+	if err := s.removed(removed); err != nil {
+		return &os.PathError{Op: "remove", Path: path, Err: err}
+	}
+
+	return nil
+}
+
+// RemoveAll removes path and any entries below it. It removes everything it
+// can in a single step: if any entry cannot be removed, nothing is. If path
+// does not exist, RemoveAll returns nil. If there is an error, it will be of
+// type *os.PathError.
+func (s *Store) RemoveAll(path string) error {
+	if err := s.writable(); err != nil {
+		return &os.PathError{Op: "removeall", Path: path, Err: err}
+	}
+
+	removed, err := s.idx.remove(cleanPath(path), true)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return &os.PathError{Op: "removeall", Path: path, Err: err}
+	}
+
+	if err := s.removed(removed); err != nil {
+		return &os.PathError{Op: "removeall", Path: path, Err: err}
+	}
+
+	return nil
+}
+
+// UnreferencedBytes returns the number of bytes on the data partition that
+// are no longer referenced by any entry because their files were removed or
+// replaced since the binary index was built. The count is kept in the binary
+// index, so it outlives the mount with WithIndexCache. The space can only be
+// reclaimed by reformatting the volume.
+func (s *Store) UnreferencedBytes() uint64 {
+	var n uint64
+
+	s.idx.view(func(t *indexTx) error {
+		n = t.unreferenced()
+		return nil
+	})
+
+	return n
+}
+
+// removal is an entry removed from the binary index.
+type removal struct {
+	path  string
+	entry *proto.Entry
+}
+
+// removed journals the removals and accounts for the extents of the removed
+// files.
+func (s *Store) removed(removed []removal) error {
+	for _, r := range removed {
+		s.journal.record(proto.Entry_RM, r.path, r.entry)
+
+		if err := s.release(r.entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// release accounts for the extents of e on the data partition, which are no
+// longer referenced.
+func (s *Store) release(e *proto.Entry) error {
+	return s.idx.commit(func(t *indexTx) error {
+		return t.release(e)
+	})
+}
+
+// remove removes the entry at the clean path and, if all is set, the entries
+// below it. The removed entries are returned with their paths, entries below
+// a directory before the directory itself.
+func (idx *index) remove(path string, all bool) ([]removal, error) {
+	if path == "/" {
+		return nil, syscall.EBUSY
+	}
+
+	var removed []removal
+
+	err := idx.write(func(t *indexTx) error {
+		dir, parent, err := t.lookup(filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+
+		if parent.GetDir() == nil {
+			return syscall.ENOTDIR
+		}
+
+		if parent.Readonly {
+			return syscall.EPERM
+		}
+
+		name := filepath.Base(path)
+
+		uid, ok := t.child(dir, name)
+		if !ok {
+			return os.ErrNotExist
+		}
+
+		// the paths of the directories on the way to the current entry
+		paths := []string{filepath.Dir(path)}
+
+		enter := func(e *proto.Entry) error {
+			if !all && len(removed) > 0 {
+				return syscall.ENOTEMPTY
+			}
+
+			if e.Readonly {
+				return syscall.EPERM
+			}
+
+			p := filepath.Join(paths[len(paths)-1], e.Name)
+			removed = append(removed, removal{path: p, entry: e})
+
+			if e.GetDir() != nil {
+				paths = append(paths, p)
+			}
+
+			return nil
+		}
+
+		leave := func(*proto.Entry) error {
+			paths = paths[:len(paths)-1]
+			return nil
+		}
+
+		if err := t.walk(uid, enter, leave); err != nil {
+			return err
+		}
+
+		for _, r := range removed {
+			if err := t.del(r.entry.Id); err != nil {
+				return err
+			}
+
+			if r.entry.GetDir() != nil {
+				if err := t.unlinkAll(r.entry.Id); err != nil {
+					return err
+				}
+			}
+		}
+
+		return t.dirents.Delete(direntKey(dir, t.policy.key(name)))
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// entries below a directory go first
+	for i, j := 0, len(removed)-1; i < j; i, j = i+1, j-1 {
+		removed[i], removed[j] = removed[j], removed[i]
+	}
+
+	return removed, nil
+}
+
+// unlinkAll removes all entries of the directory with the given UID.
+func (t *indexTx) unlinkAll(dir uint64) error {
+	prefix := uidKey(dir)
+
+	var keys [][]byte
+
+	c := t.dirents.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+
+	for _, k := range keys {
+		if err := t.dirents.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
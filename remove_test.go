@@ -0,0 +1,174 @@
+package bltfs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+
+	"hpt.space/bltfs/proto"
+)
+
+func expectPathError(t *testing.T, err error, want error) {
+	t.Helper()
+
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != want {
+		t.Errorf("expected %v, got %v", want, err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	expectPathError(t, store.Remove("/dir"), syscall.ENOTEMPTY)
+	expectPathError(t, store.Remove("/missing"), os.ErrNotExist)
+	expectPathError(t, store.Remove("/"), syscall.EBUSY)
+
+	if err := store.Remove("/testfile.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Stat("/testfile.txt"); !os.IsNotExist(err) {
+		t.Errorf("expected /testfile.txt to be gone, got %v", err)
+	}
+
+	if n := store.UnreferencedBytes(); n != 5 {
+		t.Errorf("expected 5 unreferenced bytes, got %d", n)
+	}
+
+	if err := store.Mkdir("/empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Remove("/empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	log := store.Journal()
+	if len(log.Entries) != 3 {
+		t.Fatalf("expected 3 log entries, got %d", len(log.Entries))
+	}
+
+	if e := log.Entries[0]; e.Operation != proto.Entry_RM || e.Id != 2 || e.Name != "/testfile.txt" {
+		t.Errorf("unexpected log entry %v", e)
+	}
+
+	if e := log.Entries[1]; e.Operation != proto.Entry_ADD || e.Name != "/empty" {
+		t.Errorf("unexpected log entry %v", e)
+	}
+
+	if e := log.Entries[2]; e.Operation != proto.Entry_RM || e.Name != "/empty" {
+		t.Errorf("unexpected log entry %v", e)
+	}
+}
+
+func TestUnreferencedBytesRemount(t *testing.T) {
+	dir := makeFsckTape(t, nil)
+	defer cleanup(dir)
+
+	cacheDir, err := ioutil.TempDir("", "bltfscache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(cacheDir)
+
+	store := openCachedStore(t, dir, cacheDir)
+
+	if err := store.Remove("/file1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the count is kept in the cached binary index
+	store = openCachedStore(t, dir, cacheDir)
+	defer store.Close()
+
+	if n := store.UnreferencedBytes(); n != fsckBlockSize+10 {
+		t.Errorf("expected %d unreferenced bytes, got %d", fsckBlockSize+10, n)
+	}
+
+	if st := statfs(t, store); st.UnreferencedBytes != fsckBlockSize+10 {
+		t.Errorf("expected %d unreferenced bytes, got %d", fsckBlockSize+10, st.UnreferencedBytes)
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	if err := store.Mkdir("/dir/sub"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Symlink("../file", "/dir/sub/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing is removed if an entry is read-only
+	if err := store.SetReadOnly("/dir/sub/link", true); err != nil {
+		t.Fatal(err)
+	}
+
+	expectPathError(t, store.RemoveAll("/dir"), syscall.EPERM)
+
+	if _, err := store.Stat("/dir/file"); err != nil {
+		t.Errorf("expected /dir/file to remain, got %v", err)
+	}
+
+	if err := store.SetReadOnly("/dir/sub/link", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.RemoveAll("/dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"/dir", "/dir/file", "/dir/sub", "/dir/sub/link"} {
+		if _, err := store.Lstat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be gone, got %v", p, err)
+		}
+	}
+
+	if err := store.RemoveAll("/dir"); err != nil {
+		t.Errorf("expected no error removing a missing path, got %v", err)
+	}
+
+	if n := store.UnreferencedBytes(); n != 10 {
+		t.Errorf("expected 10 unreferenced bytes, got %d", n)
+	}
+
+	// entries below a directory are logged before the directory
+	var names []string
+	for _, e := range store.Journal().Entries {
+		if e.Operation == proto.Entry_RM {
+			names = append(names, e.Name)
+		}
+	}
+
+	want := []string{"/dir/sub/link", "/dir/sub", "/dir/file", "/dir"}
+	if len(names) != len(want) {
+		t.Fatalf("expected removals %v, got %v", want, names)
+	}
+
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("expected removals %v, got %v", want, names)
+			break
+		}
+	}
+
+	// a directory can be recreated in place
+	if err := store.Mkdir("/dir"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,206 @@
+package bltfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"hpt.space/bltfs/proto"
+)
+
+type renameOptions struct {
+	overwrite bool
+}
+
+// RenameOption configures a rename.
+type RenameOption func(*renameOptions)
+
+// WithOverwrite allows a rename to replace an existing entry at the new path.
+// A file can only replace a file or symbolic link, and a directory can only
+// replace an empty directory.
+func WithOverwrite() RenameOption {
+	return func(o *renameOptions) {
+		o.overwrite = true
+	}
+}
+
+// Rename renames (moves) oldpath to newpath. Directories are moved with all
+// entries below them. The entries keep their file UIDs and extents. Unless
+// WithOverwrite is given, Rename fails with os.ErrExist if newpath exists. If
+// there is an error, it will be of type *os.LinkError.
+func (s *Store) Rename(oldpath, newpath string, opts ...RenameOption) error {
+	var ropts renameOptions
+	for _, opt := range opts {
+		opt(&ropts)
+	}
+
+	if err := s.writable(); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+
+	moved, replaced, err := s.idx.rename(cleanPath(oldpath), cleanPath(newpath), ropts.overwrite)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+
+	if replaced != nil {
+		s.journal.record(proto.Entry_RM, cleanPath(newpath), replaced)
+		if err := s.release(replaced); err != nil {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+		}
+	}
+
+	s.journal.record(proto.Entry_CH, cleanPath(newpath), moved)
+
+	return nil
+}
+
+// rename moves the entry at oldpath to newpath and returns it, along with the
+// entry it replaced, if any. Both paths must be clean.
+func (idx *index) rename(oldpath, newpath string, overwrite bool) (moved, replaced *proto.Entry, err error) {
+	if oldpath == "/" || newpath == "/" {
+		return nil, nil, syscall.EBUSY
+	}
+
+	err = idx.write(func(t *indexTx) error {
+		oldDir, oldParent, err := t.lookup(filepath.Dir(oldpath))
+		if err != nil {
+			return err
+		}
+
+		oldName := filepath.Base(oldpath)
+
+		uid, ok := t.child(oldDir, oldName)
+		if !ok {
+			return os.ErrNotExist
+		}
+
+		if moved, err = t.get(uid); err != nil {
+			return err
+		}
+
+		newDir, newParent, err := t.lookup(filepath.Dir(newpath))
+		if err != nil {
+			return err
+		}
+
+		if newParent.GetDir() == nil {
+			return syscall.ENOTDIR
+		}
+
+		if moved.Readonly || oldParent.Readonly || newParent.Readonly {
+			return syscall.EPERM
+		}
+
+		// a directory cannot be moved below itself
+		if moved.GetDir() != nil {
+			below, err := t.within(uid, filepath.Dir(newpath))
+			if err != nil {
+				return err
+			}
+
+			if below {
+				return syscall.EINVAL
+			}
+		}
+
+		newName := filepath.Base(newpath)
+
+		if other, ok := t.child(newDir, newName); ok && other != uid {
+			if !overwrite {
+				return os.ErrExist
+			}
+
+			if replaced, err = t.get(other); err != nil {
+				return err
+			}
+
+			if err := t.replaceable(moved, replaced); err != nil {
+				return err
+			}
+
+			if err := t.del(other); err != nil {
+				return err
+			}
+		}
+
+		if err := t.dirents.Delete(direntKey(oldDir, t.policy.key(oldName))); err != nil {
+			return err
+		}
+
+		moved.Name = newName
+		moved.ChangeTime = time.Now().UnixNano()
+
+		if err := t.put(moved); err != nil {
+			return err
+		}
+
+		return t.link(newDir, newName, uid)
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return moved, replaced, nil
+}
+
+// within reports whether the entry with the given UID is the directory at the
+// (existing) path or one of its parents.
+func (t *indexTx) within(uid uint64, path string) (bool, error) {
+	dir, err := t.root()
+	if err != nil {
+		return false, err
+	}
+
+	for _, name := range strings.Split(path, "/") {
+		if dir == uid {
+			return true, nil
+		}
+
+		if name == "" {
+			continue
+		}
+
+		var ok bool
+		if dir, ok = t.child(dir, name); !ok {
+			return false, os.ErrNotExist
+		}
+	}
+
+	return dir == uid, nil
+}
+
+// replaceable returns an error if the entry e cannot replace the entry
+// other.
+func (t *indexTx) replaceable(e, other *proto.Entry) error {
+	if other.Readonly {
+		return syscall.EPERM
+	}
+
+	if other.GetDir() == nil {
+		if e.GetDir() != nil {
+			return syscall.ENOTDIR
+		}
+
+		return nil
+	}
+
+	if e.GetDir() == nil {
+		return syscall.EISDIR
+	}
+
+	empty := true
+	t.children(other.Id, func(uint64) error {
+		empty = false
+		return nil
+	})
+
+	if !empty {
+		return syscall.ENOTEMPTY
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package bltfs_test
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"hpt.space/bltfs"
+	"hpt.space/bltfs/proto"
+)
+
+func expectLinkError(t *testing.T, err error, want error) {
+	t.Helper()
+
+	if lerr, ok := err.(*os.LinkError); !ok || lerr.Err != want {
+		t.Errorf("expected %v, got %v", want, err)
+	}
+}
+
+func TestRename(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	// move a directory with its contents
+	if err := store.Mkdir("/archive"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Rename("/dir", "/archive/2019"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Stat("/dir/file"); !os.IsNotExist(err) {
+		t.Errorf("expected /dir/file to be gone, got %v", err)
+	}
+
+	fi, err := store.Stat("/archive/2019/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := fi.Sys().(*proto.Entry)
+	if e.Id != 4 || len(e.GetFile().Extents) != 1 || e.GetFile().Extents[0].Block != 5 {
+		t.Errorf("unexpected entry %v", e)
+	}
+
+	fi, err = store.Stat("/archive/2019")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e := fi.Sys().(*proto.Entry); e.Id != 3 || e.Name != "2019" {
+		t.Errorf("unexpected entry %v", e)
+	}
+
+	// rename a file
+	if err := store.Rename("/testfile.txt", "/archive/2019/notes.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err = store.Stat("/archive/2019/notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e := fi.Sys().(*proto.Entry); e.Id != 2 || e.GetFile().Length != 5 {
+		t.Errorf("unexpected entry %v", e)
+	}
+
+	// the creation of /archive is logged first
+	log := store.Journal()
+	if len(log.Entries) != 3 {
+		t.Fatalf("expected 3 log entries, got %d", len(log.Entries))
+	}
+
+	if e := log.Entries[0]; e.Operation != proto.Entry_ADD || e.Name != "/archive" {
+		t.Errorf("unexpected log entry %v", e)
+	}
+
+	for i, want := range []struct {
+		id   uint64
+		name string
+	}{{3, "/archive/2019"}, {2, "/archive/2019/notes.txt"}} {
+		e := log.Entries[i+1]
+		if e.Operation != proto.Entry_CH || e.Id != want.id || e.Name != want.name {
+			t.Errorf("unexpected log entry %v", e)
+		}
+	}
+}
+
+func TestRenameErrors(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	if err := store.Mkdir("/empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectLinkError(t, store.Rename("/missing", "/x"), os.ErrNotExist)
+	expectLinkError(t, store.Rename("/testfile.txt", "/dir/file"), os.ErrExist)
+	expectLinkError(t, store.Rename("/testfile.txt", "/missing/x"), os.ErrNotExist)
+	expectLinkError(t, store.Rename("/dir", "/dir/sub"), syscall.EINVAL)
+	expectLinkError(t, store.Rename("/", "/x"), syscall.EBUSY)
+
+	// overwriting is refused unless asked for, and only for compatible entries
+	expectLinkError(t, store.Rename("/testfile.txt", "/empty", bltfs.WithOverwrite()), syscall.EISDIR)
+	expectLinkError(t, store.Rename("/empty", "/testfile.txt", bltfs.WithOverwrite()), syscall.ENOTDIR)
+	expectLinkError(t, store.Rename("/empty", "/dir", bltfs.WithOverwrite()), syscall.ENOTEMPTY)
+
+	// only the creation of /empty is logged
+	if len(store.Journal().Entries) != 1 {
+		t.Error("failed renames must not be logged")
+	}
+
+	if err := store.Rename("/testfile.txt", "/dir/file", bltfs.WithOverwrite()); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := store.Stat("/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e := fi.Sys().(*proto.Entry); e.Id != 2 {
+		t.Errorf("expected file UID 2, got %d", e.Id)
+	}
+
+	log := store.Journal()
+	if len(log.Entries) != 3 || log.Entries[1].Operation != proto.Entry_RM || log.Entries[1].Id != 4 {
+		t.Errorf("unexpected log %v", log.Entries)
+	}
+
+	// read-only entries cannot be moved
+	if err := store.SetReadOnly("/dir/file", true); err != nil {
+		t.Fatal(err)
+	}
+
+	expectLinkError(t, store.Rename("/dir/file", "/file"), syscall.EPERM)
+}
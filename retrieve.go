@@ -0,0 +1,138 @@
+package bltfs
+
+import (
+	"io"
+	"os"
+	"sort"
+	"syscall"
+
+	"hpt.space/bltfs/proto"
+)
+
+// Retrieve copies the content of the named file, as recorded in the mounted
+// index generation, to w. Symbolic links are followed. Ranges of the file not
+// covered by an extent read as zeros.
+func (s *Store) Retrieve(name string, w io.Writer) (int64, error) {
+	fi, err := s.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+
+	f := fi.Sys().(*proto.Entry).GetFile()
+	if f == nil {
+		return 0, &os.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+
+	extents := make([]*proto.Extent, len(f.Extents))
+	copy(extents, f.Extents)
+
+	sort.Slice(extents, func(i, j int) bool {
+		return extents[i].Offset < extents[j].Offset
+	})
+
+	s.rw.mu.Lock()
+	defer s.rw.mu.Unlock()
+
+	// the extents of open files may be batched
+	if err := s.rw.flushBatch(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var written int64
+	for _, e := range extents {
+		if e.Offset > uint64(written) {
+			n, err := writeZeros(w, int64(e.Offset)-written)
+			written += n
+			if err != nil {
+				return written, err
+			}
+		}
+
+		n, err := s.readExtent(e, w)
+		written += n
+		if err != nil {
+			return written, &os.PathError{Op: "read", Path: name, Err: err}
+		}
+	}
+
+	if f.Length > uint64(written) {
+		n, err := writeZeros(w, int64(f.Length)-written)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	if err := s.seekEOD(); err != nil {
+		return written, err
+	}
+
+	return written, nil
+}
+
+// readExtent copies the bytes of the extent to w. The caller must hold the
+// device.
+func (s *Store) readExtent(e *proto.Extent, w io.Writer) (int64, error) {
+	dev := s.mu.backend
+
+	if err := dev.Locate(e.Partition, e.Block); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, dev.BlockSize())
+	skip, remaining := e.Boffset, e.Length
+
+	var written int64
+	for remaining > 0 {
+		n, err := dev.Read(buf)
+		if err != nil {
+			return written, err
+		}
+
+		// a filemark ends the extent early
+		if n == 0 {
+			return written, io.ErrUnexpectedEOF
+		}
+
+		p := buf[:n]
+
+		if skip >= uint64(len(p)) {
+			skip -= uint64(len(p))
+			continue
+		}
+
+		p, skip = p[skip:], 0
+
+		if uint64(len(p)) > remaining {
+			p = p[:remaining]
+		}
+
+		nw, err := w.Write(p)
+		written += int64(nw)
+		if err != nil {
+			return written, err
+		}
+
+		remaining -= uint64(nw)
+	}
+
+	return written, nil
+}
+
+// writeZeros writes n zero bytes to w.
+func writeZeros(w io.Writer, n int64) (int64, error) {
+	return io.CopyN(w, zeroReader{}, n)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+
+	return len(p), nil
+}
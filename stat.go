@@ -1,19 +1,137 @@
 package bltfs
 
-import "os"
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
+	"hpt.space/bltfs/proto"
+)
+
+// maxSymlinks is the maximum number of symbolic links followed when
+// resolving a path.
+const maxSymlinks = 40
+
+// Lstat returns a FileInfo describing the entry at path. If the entry is a
+// symbolic link, the returned FileInfo describes the link itself.
 func (b *Store) Lstat(path string) (os.FileInfo, error) {
-	return b.Stat(path)
+	var es entryStat
+	e, err := b.idx.stat(cleanPath(path))
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: path, Err: err}
+	}
+
+	es.e = e
+
+	return &es, nil
 }
 
+// Stat returns a FileInfo describing the entry at path, following symbolic
+// links.
 func (b *Store) Stat(path string) (os.FileInfo, error) {
 	var es entryStat
-	e, err := b.idx.stat(path)
+	e, err := b.resolve(cleanPath(path))
 	if err != nil {
-		return nil, err
+		return nil, &os.PathError{Op: "stat", Path: path, Err: err}
 	}
 
 	es.e = e
 
 	return &es, nil
 }
+
+// resolve returns the entry at path, following symbolic links in every
+// component of the path.
+func (b *Store) resolve(path string) (*proto.Entry, error) {
+	e, err := b.idx.stat("/")
+	if err != nil {
+		return nil, err
+	}
+
+	// the resolved directory and the names left to resolve in it
+	dir, names := "/", splitPath(path)
+
+	for links := 0; len(names) > 0; {
+		if e.GetDir() == nil {
+			return nil, syscall.ENOTDIR
+		}
+
+		p := filepath.Join(dir, names[0])
+		names = names[1:]
+
+		if e, err = b.idx.stat(p); err != nil {
+			return nil, err
+		}
+
+		link := e.GetSymlink()
+		if link == nil {
+			dir = p
+			continue
+		}
+
+		if links++; links > maxSymlinks {
+			return nil, syscall.ELOOP
+		}
+
+		// relative targets are relative to the directory holding the link
+		if filepath.IsAbs(link.Target) {
+			dir = "/"
+		}
+
+		names = append(splitPath(link.Target), names...)
+
+		if e, err = b.idx.stat(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// splitPath returns the names in path.
+func splitPath(path string) []string {
+	var names []string
+	for _, name := range strings.Split(path, "/") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// SetReadOnly sets the read-only flag of the entry at path. The contents and
+// extended attributes of a read-only entry cannot be modified, and no entries
+// can be created in a read-only directory. If there is an error, it will be of
+// type *os.PathError.
+func (b *Store) SetReadOnly(path string, readonly bool) error {
+	if err := b.writable(); err != nil {
+		return &os.PathError{Op: "setreadonly", Path: path, Err: err}
+	}
+
+	err := b.idx.update(cleanPath(path), func(e *proto.Entry) error {
+		e.Readonly = readonly
+		e.ChangeTime = time.Now().UnixNano()
+
+		return nil
+	})
+
+	if err != nil {
+		return &os.PathError{Op: "setreadonly", Path: path, Err: err}
+	}
+
+	entry, err := b.idx.stat(cleanPath(path))
+	if err != nil {
+		return &os.PathError{Op: "setreadonly", Path: path, Err: err}
+	}
+
+	b.journal.record(proto.Entry_CH, cleanPath(path), entry)
+
+	if err := b.writeLog(); err != nil {
+		return &os.PathError{Op: "setreadonly", Path: path, Err: err}
+	}
+
+	return nil
+}
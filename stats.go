@@ -0,0 +1,151 @@
+package bltfs
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"hpt.space/bltfs/backend"
+	"hpt.space/bltfs/ltfs"
+)
+
+// Statfs describes the contents of a store and its use of the volume.
+type Statfs struct {
+	// the number of entries by type; Directories includes the root
+	Files       uint64
+	Directories uint64
+	Symlinks    uint64
+
+	// LogicalBytes is the sum of the lengths of all files.
+	LogicalBytes uint64
+
+	// Extents is the number of file extents and FragmentedFiles the number
+	// of files with more than one extent.
+	Extents         uint64
+	FragmentedFiles uint64
+
+	// the index and data partition of the volume
+	Partitions []PartitionStat
+
+	// UnreferencedBytes is the data no longer referenced by any file (see
+	// Store.UnreferencedBytes).
+	UnreferencedBytes uint64
+
+	// the generation and time of the last index written to the volume, and
+	// the time passed since
+	Generation int
+	IndexTime  time.Time
+	SinceIndex time.Duration
+}
+
+// PartitionStat describes the use of a partition of the volume.
+type PartitionStat struct {
+	// the physical partition number and its LTFS partition ID
+	Partition uint32
+	ID        string
+
+	// Bytes is the size of the file extents on the partition.
+	Bytes uint64
+
+	// the total and remaining capacity of the partition in bytes as reported
+	// by the backend; both are zero if the backend does not report them (see
+	// backend.CapacityReporter)
+	Capacity  uint64
+	Remaining uint64
+}
+
+// ExtentsPerFile returns the average number of extents of a file.
+func (st *Statfs) ExtentsPerFile() float64 {
+	if st.Files == 0 {
+		return 0
+	}
+
+	return float64(st.Extents) / float64(st.Files)
+}
+
+// AverageExtentLength returns the average length of an extent in bytes.
+func (st *Statfs) AverageExtentLength() float64 {
+	if st.Extents == 0 {
+		return 0
+	}
+
+	var n uint64
+	for _, p := range st.Partitions {
+		n += p.Bytes
+	}
+
+	return float64(n) / float64(st.Extents)
+}
+
+// Statfs returns statistics of the store. The counts are kept up to date in
+// the binary index, so the entries are not walked.
+func (s *Store) Statfs() (*Statfs, error) {
+	var (
+		is           indexStats
+		unreferenced uint64
+	)
+
+	err := s.idx.view(func(t *indexTx) error {
+		var err error
+		is, err = t.stats()
+		unreferenced = t.unreferenced()
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	st := &Statfs{
+		Files:             is.Files,
+		Directories:       is.Directories,
+		Symlinks:          is.Symlinks,
+		LogicalBytes:      is.Bytes,
+		Extents:           is.Extents,
+		FragmentedFiles:   is.Fragmented,
+		UnreferencedBytes: unreferenced,
+		Generation:        s.ltfs.curr.Generation,
+		IndexTime:         time.Time(s.ltfs.curr.UpdateTime),
+	}
+
+	st.SinceIndex = time.Since(st.IndexTime)
+
+	for _, part := range []uint32{ltfs.IndexPartition, ltfs.DataPartition} {
+		id, err := s.ltfs.pmap.ID(part)
+		if err != nil {
+			return nil, err
+		}
+
+		ps := PartitionStat{
+			Partition: part,
+			ID:        id,
+			Bytes:     is.PartitionBytes[part],
+		}
+
+		if ps.Capacity, ps.Remaining, err = s.capacity(part); err != nil {
+			return nil, errors.Wrapf(err, "failed to read capacity of partition %s", id)
+		}
+
+		st.Partitions = append(st.Partitions, ps)
+	}
+
+	return st, nil
+}
+
+// capacity returns the total and remaining capacity of the partition, or
+// zeros if the backend does not report its capacity.
+func (s *Store) capacity(part uint32) (uint64, uint64, error) {
+	s.rw.mu.Lock()
+	defer s.rw.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cr, ok := s.mu.backend.(backend.CapacityReporter)
+	if !ok {
+		return 0, 0, nil
+	}
+
+	return cr.Capacity(part)
+}
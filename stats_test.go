@@ -0,0 +1,121 @@
+package bltfs_test
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"hpt.space/bltfs"
+	"hpt.space/bltfs/ltfs"
+)
+
+func statfs(t *testing.T, store *bltfs.Store) *bltfs.Statfs {
+	st, err := store.Statfs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return st
+}
+
+func TestStatfs(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	st := statfs(t, store)
+
+	if st.Files != 2 || st.Directories != 2 || st.Symlinks != 0 {
+		t.Errorf("unexpected counts %+v", st)
+	}
+
+	if st.LogicalBytes != 15 || st.Extents != 2 || st.FragmentedFiles != 0 {
+		t.Errorf("unexpected sizes %+v", st)
+	}
+
+	if st.Generation != 1 || st.SinceIndex <= 0 {
+		t.Errorf("unexpected index generation %d (%v)", st.Generation, st.SinceIndex)
+	}
+
+	if len(st.Partitions) != 2 {
+		t.Fatalf("expected 2 partitions, got %v", st.Partitions)
+	}
+
+	data := st.Partitions[ltfs.DataPartition]
+	if data.ID != "b" || data.Bytes != 15 || data.Capacity == 0 || data.Remaining >= data.Capacity {
+		t.Errorf("unexpected data partition %+v", data)
+	}
+
+	if avg := st.AverageExtentLength(); avg != 7.5 {
+		t.Errorf("expected average extent length 7.5, got %v", avg)
+	}
+
+	// the statistics follow changes to the index
+	if err := store.Mkdir("/new"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Symlink("/dir/file", "/new/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := store.Create("/new/file", bltfs.WithNoBatch())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"abc", "def"} {
+		if _, err := f.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.RemoveAll("/dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	st = statfs(t, store)
+
+	if st.Files != 2 || st.Directories != 2 || st.Symlinks != 1 {
+		t.Errorf("unexpected counts %+v", st)
+	}
+
+	if st.LogicalBytes != 11 || st.Extents != 3 || st.FragmentedFiles != 1 {
+		t.Errorf("unexpected sizes %+v", st)
+	}
+
+	if st.ExtentsPerFile() != 1.5 {
+		t.Errorf("expected 1.5 extents per file, got %v", st.ExtentsPerFile())
+	}
+
+	if st.UnreferencedBytes != 10 {
+		t.Errorf("expected 10 unreferenced bytes, got %d", st.UnreferencedBytes)
+	}
+}
+
+func TestStatfsCached(t *testing.T) {
+	tape := makeTestTape(t, makeTestIndex())
+	defer cleanup(tape)
+
+	cacheDir, err := ioutil.TempDir("", "bltfscache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(cacheDir)
+
+	store := openCachedStore(t, tape, cacheDir)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the statistics are kept in the cached binary index
+	store = openCachedStore(t, tape, cacheDir)
+	defer store.Close()
+
+	if st := statfs(t, store); st.Files != 2 || st.Directories != 2 || st.LogicalBytes != 15 {
+		t.Errorf("unexpected statistics %+v", st)
+	}
+}
@@ -0,0 +1,69 @@
+package bltfs
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"hpt.space/bltfs/proto"
+)
+
+// Symlink creates newname as a symbolic link to oldname. If there is an
+// error, it will be of type *os.LinkError.
+func (s *Store) Symlink(oldname, newname string) error {
+	if oldname == "" {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.EINVAL}
+	}
+
+	if err := s.writable(); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+
+	path := cleanPath(newname)
+	now := time.Now().UnixNano()
+
+	entry := &proto.Entry{
+		Name:       filepath.Base(path),
+		CreateTime: now,
+		ChangeTime: now,
+		ModifyTime: now,
+		AccessTime: now,
+		BackupTime: now,
+
+		Elem: &proto.Entry_Symlink{
+			Symlink: &proto.Symlink{
+				Target: oldname,
+			},
+		},
+	}
+
+	var err error
+	if entry.Id, err = s.allocUID(); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+
+	if err := s.idx.create(path, entry); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+
+	s.journal.record(proto.Entry_ADD, path, entry)
+
+	return nil
+}
+
+// Readlink returns the destination of the named symbolic link. If there is an
+// error, it will be of type *os.PathError.
+func (s *Store) Readlink(name string) (string, error) {
+	e, err := s.idx.stat(cleanPath(name))
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+
+	link := e.GetSymlink()
+	if link == nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+
+	return link.Target, nil
+}
@@ -0,0 +1,148 @@
+package bltfs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestSymlink(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	if err := store.Symlink("dir/file", "/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Symlink("/testfile.txt", "/dir/abslink"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Symlink("dir", "/dirlink"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Symlink("../dirlink", "/dir/uplink"); err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := store.Readlink("/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if target != "dir/file" {
+		t.Errorf("got target %q, expected %q", target, "dir/file")
+	}
+
+	// Lstat describes the link itself
+	fi, err := store.Lstat("/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected a symbolic link, got mode %v", fi.Mode())
+	}
+
+	if fi.Size() != int64(len("dir/file")) {
+		t.Errorf("unexpected size %d", fi.Size())
+	}
+
+	// Stat follows relative and absolute links, in any component
+	tests := []struct {
+		path string
+		size int64
+	}{
+		{"/link", 10},
+		{"/dir/abslink", 5},
+		{"/dirlink/file", 10},
+		{"/dirlink/uplink/abslink", 5},
+	}
+
+	for _, tc := range tests {
+		fi, err := store.Stat(tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !fi.Mode().IsRegular() || fi.Size() != tc.size {
+			t.Errorf("%s: unexpected mode %v, size %d", tc.path, fi.Mode(), fi.Size())
+		}
+	}
+
+	// errors
+	if _, err := store.Readlink("/testfile.txt"); err == nil {
+		t.Error("expected an error")
+	}
+
+	if err := store.Symlink("x", "/link"); !os.IsExist(err) {
+		t.Errorf("expected an exist error, got %v", err)
+	}
+
+	if err := store.Symlink("x", "/nonexistent/link"); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+
+	if err := store.Symlink("/loop", "/loop"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/loop", "/loop/file"} {
+		_, err = store.Stat(path)
+		if perr, ok := err.(*os.PathError); !ok || perr.Err != syscall.ELOOP {
+			t.Errorf("%s: expected ELOOP, got %v", path, err)
+		}
+	}
+
+	// a file is not a directory
+	_, err = store.Stat("/link/x")
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != syscall.ENOTDIR {
+		t.Errorf("expected ENOTDIR, got %v", err)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	src, err := ioutil.TempDir("", "bltfstest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(src)
+
+	if err := os.Mkdir(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("../elsewhere", filepath.Join(src, "sub", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Archive(src, "/archive/root"); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := store.Stat("/archive/root/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !fi.IsDir() || fi.Size() != 0 {
+		t.Errorf("expected a directory of size 0, got %v (%d)", fi.Mode(), fi.Size())
+	}
+
+	target, err := store.Readlink("/archive/root/sub/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if target != "../elsewhere" {
+		t.Errorf("got target %q, expected %q", target, "../elsewhere")
+	}
+}
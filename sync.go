@@ -0,0 +1,177 @@
+package bltfs
+
+import (
+	"sync/atomic"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"hpt.space/bltfs/ltfs"
+	"hpt.space/bltfs/util/xmlutil"
+)
+
+// Sync writes the binary index as a new index generation to the volume. The
+// index is written to the end of the data partition, pointing back to the
+// previous generation there, and then to the index partition, pointing back
+// to the data partition copy. The index is streamed from the binary index
+// (see WriteLTFSIndex). Logs written after the index point back to it (see
+// RecoveryPolicy).
+//
+// The index is written in the format version selected with WithIndexVersion.
+// Features that are not available in that version are left out of the index
+// and returned in dropped.
+func (s *Store) Sync() (dropped []ltfs.Feature, err error) {
+	if s.readonly {
+		return nil, syscall.EROFS
+	}
+
+	s.rw.mu.Lock()
+	defer s.rw.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sync()
+}
+
+// sync writes a new index generation (see Sync). The caller must hold the
+// device.
+func (s *Store) sync() ([]ltfs.Feature, error) {
+	// changes are not recorded while the index is written
+	s.journal.Lock()
+	defer s.journal.Unlock()
+
+	// batched data goes before the index
+	if err := s.rw.flushBatch(); err != nil {
+		return nil, err
+	}
+
+	preface := s.ltfsPreface()
+	preface.Generation++
+	preface.UpdateTime = xmlutil.TimeNow()
+	preface.PreviousGeneration = ltfs.PreviousGeneration{}
+
+	if block := s.indexBlock(); block > 0 {
+		preface.PreviousGeneration = ltfs.PreviousGeneration{
+			Partition:  s.ltfs.pmap.Data,
+			StartBlock: int(block),
+		}
+	}
+
+	// changes from now on are not in the index written
+	atomic.StoreInt32(&s.idx.modified, 0)
+
+	eod, err := s.eod(ltfs.DataPartition)
+	if err != nil {
+		return nil, err
+	}
+
+	dp, _, dropped, err := s.writeTapeIndex(s.idx, ltfs.DataPartition, eod, preface)
+	if err != nil {
+		atomic.StoreInt32(&s.idx.modified, 1)
+		return nil, errors.Wrap(err, "failed to write index to data partition")
+	}
+
+	start, err := s.indexPartitionStart()
+	if err != nil {
+		atomic.StoreInt32(&s.idx.modified, 1)
+		return nil, err
+	}
+
+	// the index partition copy points back to the data partition copy
+	preface.PreviousGeneration = ltfs.PreviousGeneration{
+		Partition:  s.ltfs.pmap.Data,
+		StartBlock: dp.StartBlock,
+	}
+
+	ip, _, _, err := s.writeTapeIndex(s.idx, ltfs.IndexPartition, start, preface)
+	if err != nil {
+		atomic.StoreInt32(&s.idx.modified, 1)
+		return nil, errors.Wrap(err, "failed to write index to index partition")
+	}
+
+	// the index partition copy is the current index, as found at mount
+	curr := *s.ltfs.curr
+	curr.IndexPreface = ip
+
+	s.ltfs.prev = s.ltfs.curr
+	s.ltfs.curr = &curr
+	s.idx.setPreface(&ip)
+
+	s.journal.restart(uint64(dp.StartBlock))
+
+	if s.cache != nil {
+		s.cache = s.newCacheMeta(&curr, ltfs.IndexPartition, uint64(ip.StartBlock))
+	}
+
+	if err := s.seekEOD(); err != nil {
+		return nil, err
+	}
+
+	return dropped, nil
+}
+
+// eod returns the end of data position of the partition. The caller must hold
+// the device.
+func (s *Store) eod(part uint32) (uint64, error) {
+	if err := s.mu.backend.Locate(part, TapeBlockMax); err != nil {
+		return 0, errors.Wrap(err, "failed to seek to EOD")
+	}
+
+	block, err := s.mu.backend.ReadPosition()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read position")
+	}
+
+	return block, nil
+}
+
+// indexPartitionStart returns the block of the index partition the next index
+// is written at. The last index is replaced, unless file data was written
+// after it (see writeIndexPartition); the index then goes after the data.
+// The caller must hold the device.
+func (s *Store) indexPartitionStart() (uint64, error) {
+	dev := s.mu.backend
+
+	eod, err := s.eod(ltfs.IndexPartition)
+	if err != nil {
+		return 0, err
+	}
+
+	// the last file is terminated if the filemark precedes EOD
+	if err := dev.SpaceFMB(1); err != nil {
+		if err == ErrBOT {
+			return eod, nil
+		}
+
+		return 0, errors.Wrap(err, "failed to space backward")
+	}
+
+	if block, err := dev.ReadPosition(); err != nil || block != eod {
+		return eod, err
+	}
+
+	if err := dev.Locate(ltfs.IndexPartition, TapeBlockMax); err != nil {
+		return 0, errors.Wrap(err, "failed to seek to EOD")
+	}
+
+	if err := dev.SpaceFMB(2); err != nil {
+		if err == ErrBOT {
+			return eod, nil
+		}
+
+		return 0, errors.Wrap(err, "failed to space backward")
+	}
+
+	start, err := dev.ReadPosition()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read position")
+	}
+
+	// the label is never replaced
+	if start < labelBlock+2 {
+		return eod, nil
+	}
+
+	return start, nil
+}
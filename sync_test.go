@@ -0,0 +1,117 @@
+package bltfs_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"hpt.space/bltfs"
+	"hpt.space/bltfs/ltfs"
+)
+
+func TestSync(t *testing.T) {
+	dir := makeFsckTape(t, nil)
+	defer cleanup(dir)
+
+	store, err := bltfs.Open(openTestDevice(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, store, "/new", []byte("data"))
+
+	// each generation points back to the previous one on the data partition
+	for i := 0; i < 2; i++ {
+		if _, err := store.Sync(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if st := statfs(t, store); st.Generation != 4 {
+		t.Errorf("expected generation 4, got %d", st.Generation)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	report := checkTestTape(t, dir)
+	if !report.Consistent() {
+		t.Fatalf("unexpected problems: %v", report.Problems)
+	}
+
+	if gens := generationNumbers(report); !reflect.DeepEqual(gens, []int{4, 3, 2, 1}) {
+		t.Fatalf("unexpected generations %v", gens)
+	}
+
+	// the data record, a filemark and the index follow generation 2
+	if g := report.Generations[1]; g.Partition != ltfs.DataPartition || g.Block != 12 || g.Files != 2 {
+		t.Errorf("unexpected generation %+v", g)
+	}
+
+	// the index partition copy replaces the last index there
+	if eod := report.EOD[ltfs.IndexPartition]; eod != 5 {
+		t.Errorf("expected index partition EOD 5, got %d", eod)
+	}
+
+	store, err = bltfs.Open(openTestDevice(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	expectContent(t, store, "/new", []byte("data"))
+	expectUID(t, store, 3, "/new")
+}
+
+func TestSyncReadOnly(t *testing.T) {
+	dir := makeFsckTape(t, nil)
+	defer cleanup(dir)
+
+	store, err := bltfs.Open(openTestDevice(t, dir), bltfs.WithGeneration(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.Sync(); err == nil {
+		t.Error("expected sync of a past generation to fail")
+	}
+}
+
+func TestSyncDowngrade(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex(), bltfs.WithIndexVersion("2.2.0"))
+	defer cleanup(dir)
+
+	if err := store.Symlink("testfile.txt", "/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	// symbolic links cannot be written in format version 2.2.0
+	dropped, err := store.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(dropped, []ltfs.Feature{ltfs.FeatureSymlinks}) {
+		t.Errorf("expected symlinks to be dropped, got %v", dropped)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = bltfs.Open(openTestDevice(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if st := statfs(t, store); st.Generation != 2 || st.Symlinks != 0 {
+		t.Errorf("unexpected statistics %+v", st)
+	}
+
+	if _, err := store.Lstat("/link"); !os.IsNotExist(err) {
+		t.Errorf("expected the symbolic link to be gone, got %v", err)
+	}
+}
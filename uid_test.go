@@ -0,0 +1,214 @@
+package bltfs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"hpt.space/bltfs"
+	"hpt.space/bltfs/proto"
+)
+
+// expectUID checks that the entry at path has the given file UID.
+func expectUID(t *testing.T, store *bltfs.Store, uid uint64, want string) {
+	t.Helper()
+
+	path, _, err := store.LookupUID(uid)
+	if err != nil {
+		t.Errorf("uid %d: %v", uid, err)
+		return
+	}
+
+	if path != want {
+		t.Errorf("uid %d: expected %s, got %s", uid, want, path)
+	}
+}
+
+func TestAllocUID(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	if err := store.Mkdir("/new"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := store.Create("/new/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Symlink("file", "/new/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the mounted index has 4 as its highest file UID
+	expectUID(t, store, 5, "/new")
+	expectUID(t, store, 6, "/new/file")
+	expectUID(t, store, 7, "/new/link")
+
+	// UIDs of removed entries are not reused
+	if err := store.Remove("/new/file"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = store.Create("/new/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	expectUID(t, store, 8, "/new/file")
+
+	if _, _, err := store.LookupUID(6); err != os.ErrNotExist {
+		t.Errorf("expected os.ErrNotExist, got %v", err)
+	}
+
+	// creating an existing file opens it
+	f, err = store.Create("/new/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	expectUID(t, store, 8, "/new/file")
+
+	if _, err := store.Create("/new"); err == nil {
+		t.Error("expected error creating a directory")
+	}
+}
+
+// copyTestDir copies the directory src, as a tape or index cache left behind
+// by a crash of the store using it.
+func copyTestDir(t *testing.T, src string) string {
+	dst := setupCleanTape()
+
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(filepath.Join(dst, rel), buf, fi.Mode())
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dst
+}
+
+func TestAllocUIDAfterCrash(t *testing.T) {
+	tape := makeTestTape(t, makeTestIndex())
+	defer cleanup(tape)
+
+	cacheDir, err := ioutil.TempDir("", "bltfscache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(cacheDir)
+
+	store := openCachedStore(t, tape, cacheDir)
+	defer store.Close()
+
+	if err := store.Mkdir("/lost"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectUID(t, store, 5, "/lost")
+
+	// the store crashes without closing, so the modification is never
+	// written to the volume and the cache is rebuilt from the index on the
+	// volume
+	crashed, crashedCache := copyTestDir(t, tape), copyTestDir(t, cacheDir)
+	defer cleanup(crashed)
+	defer cleanup(crashedCache)
+
+	store = openCachedStore(t, crashed, crashedCache)
+	defer store.Close()
+
+	if _, err := store.Stat("/lost"); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+
+	if err := store.Mkdir("/found"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectUID(t, store, 6, "/found")
+}
+
+func TestAllocUIDAfterCrashWithLogs(t *testing.T) {
+	tape := makeTestTape(t, makeTestIndex())
+	defer cleanup(tape)
+
+	store, err := bltfs.Open(openTestDevice(t, tape), bltfs.WithRecoveryPolicy(bltfs.RecoveryPolicy{
+		IncrementalAfter: 4,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.Mkdir("/lost"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the data is followed by a log
+	writeTestFile(t, store, "/lost/a", []byte("abcd"))
+
+	if err := store.Remove("/lost/a"); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, store, "/b", []byte("efgh"))
+
+	expectUID(t, store, 7, "/b")
+
+	// no log follows /c
+	if err := store.Mkdir("/c"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectUID(t, store, 8, "/c")
+
+	// the store crashes without closing or writing an index
+	crashed := copyTestDir(t, tape)
+	defer cleanup(crashed)
+
+	store, _ = recoverTestStore(t, crashed)
+	defer store.Close()
+
+	if err := store.Mkdir("/found"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the UIDs reserved in the logs are not reused, including that of /c
+	fi, err := store.Stat("/found")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uid := fi.Sys().(*proto.Entry).Id; uid <= 8 {
+		t.Errorf("expected a UID above 8, got %d", uid)
+	}
+}
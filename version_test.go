@@ -0,0 +1,82 @@
+package bltfs_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"testing"
+
+	"hpt.space/bltfs"
+	filedebug "hpt.space/bltfs/backend/file"
+	"hpt.space/bltfs/ltfs"
+)
+
+func TestWriteLTFSIndexVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		dropped []ltfs.Feature
+	}{
+		{ltfs.Version, nil},
+		{"2.2.0", []ltfs.Feature{ltfs.FeatureSymlinks}},
+	}
+
+	for _, tc := range tests {
+		store, dir := openTestStore(t, makeTestIndex(), bltfs.WithIndexVersion(tc.version))
+
+		if err := store.Symlink("testfile.txt", "/link"); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		dropped, err := store.WriteLTFSIndex(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(dropped, tc.dropped) {
+			t.Errorf("%s: dropped %v, expected %v", tc.version, dropped, tc.dropped)
+		}
+
+		var idx ltfs.Index
+		if err := xml.Unmarshal(buf.Bytes(), &idx); err != nil {
+			t.Fatal(err)
+		}
+
+		if idx.Version != tc.version {
+			t.Errorf("got version %s, expected %s", idx.Version, tc.version)
+		}
+
+		store.Close()
+		cleanup(dir)
+	}
+}
+
+func TestOpenUnsupportedVersion(t *testing.T) {
+	dir := makeTestTape(t, makeTestIndex())
+	defer cleanup(dir)
+
+	dev, err := filedebug.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bltfs.Open(dev, bltfs.WithIndexVersion("1.0.0")); err == nil {
+		t.Error("expected an error")
+	}
+
+	// an index written by a newer implementation
+	idx := makeTestIndex()
+	idx.Version = "3.0.0"
+
+	dir3 := makeTestTape(t, idx)
+	defer cleanup(dir3)
+
+	dev3, err := filedebug.Open(dir3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bltfs.Open(dev3); err == nil {
+		t.Error("expected an error")
+	}
+}
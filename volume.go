@@ -0,0 +1,203 @@
+package bltfs
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"hpt.space/bltfs/ltfs"
+	"hpt.space/bltfs/proto"
+)
+
+var (
+	// ErrVolumeLocked is returned when modifying a locked volume.
+	ErrVolumeLocked = errors.New("volume is locked")
+
+	// ErrVolumePermLocked is returned when modifying a permanently locked
+	// volume or changing its lock state.
+	ErrVolumePermLocked = errors.New("volume is permanently locked")
+)
+
+// VolumeLockState returns the lock state of the volume; one of
+// ltfs.VolumeUnlocked, ltfs.VolumeLocked and ltfs.VolumePermLocked.
+func (s *Store) VolumeLockState() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.lock.state == "" {
+		return ltfs.VolumeUnlocked
+	}
+
+	return s.lock.state
+}
+
+// SetVolumeLockState changes the lock state of the volume. The contents of a
+// locked volume cannot be modified until it is unlocked again; a permanently
+// locked volume can never be unlocked. The change is logged (see
+// RecoveryPolicy) and written to the volume in a new index generation right
+// away (see Sync); if that fails, the lock state is left unchanged. Stores
+// writing format versions before 2.4.0 cannot change the lock state (see
+// ltfs.FeatureVolumeLockState).
+func (s *Store) SetVolumeLockState(state string) error {
+	switch state {
+	case ltfs.VolumeUnlocked, ltfs.VolumeLocked, ltfs.VolumePermLocked:
+	default:
+		return errors.Errorf("invalid volume lock state %q", state)
+	}
+
+	if s.readonly {
+		return syscall.EROFS
+	}
+
+	// the lock state would be dropped from the index written
+	v, err := ltfs.ParseVersion(s.sopts.version)
+	if err != nil {
+		return err
+	}
+
+	if !v.Supports(ltfs.FeatureVolumeLockState) {
+		return errors.Errorf("format version %s cannot record a volume lock state", v)
+	}
+
+	s.lock.Lock()
+
+	old := s.lock.state
+	if old == ltfs.VolumePermLocked {
+		s.lock.Unlock()
+		return ErrVolumePermLocked
+	}
+
+	s.lock.state = state
+	s.lock.Unlock()
+
+	// all features are available in versions with a lock state, so none
+	// are dropped
+	err = s.recordVolume()
+	if err == nil {
+		_, err = s.Sync()
+	}
+
+	// the lock state is only changed once it is on the volume
+	if err != nil {
+		s.lock.Lock()
+		s.lock.state = old
+		s.lock.Unlock()
+
+		s.recordVolume()
+
+		return err
+	}
+
+	return nil
+}
+
+// LockVolume locks the volume (see SetVolumeLockState).
+func (s *Store) LockVolume() error {
+	return s.SetVolumeLockState(ltfs.VolumeLocked)
+}
+
+// UnlockVolume unlocks the volume (see SetVolumeLockState).
+func (s *Store) UnlockVolume() error {
+	return s.SetVolumeLockState(ltfs.VolumeUnlocked)
+}
+
+// PermLockVolume locks the volume permanently (see SetVolumeLockState).
+func (s *Store) PermLockVolume() error {
+	return s.SetVolumeLockState(ltfs.VolumePermLocked)
+}
+
+// The volume state is recorded with the root directory in the logs (see
+// recordVolume).
+const (
+	lockStateXattr = ltfs.XattrReservedPrefix + "volumeLockState"
+	placementXattr = ltfs.XattrReservedPrefix + "dataPlacementPolicy"
+
+	// the highest file UID reserved (see Store.reserveUID)
+	reservedUIDXattr = ltfs.XattrReservedPrefix + "reservedFileUID"
+)
+
+// recordVolume journals a change of the volume lock state or data placement
+// policy as a change of the root directory. The logged entry carries the
+// volume state in reserved extended attributes, which are never stored with
+// the entry; Recover applies them to the store instead (see applyVolume).
+func (s *Store) recordVolume() error {
+	s.journal.Lock()
+	reserved := s.journal.reserved
+	s.journal.Unlock()
+
+	root, err := s.volumeEntry(reserved)
+	if err != nil {
+		return err
+	}
+
+	s.journal.record(proto.Entry_CH, "/", root)
+
+	return nil
+}
+
+// volumeEntry returns the root directory carrying the volume state and the
+// highest file UID reserved.
+func (s *Store) volumeEntry(reserved uint64) (*proto.Entry, error) {
+	root, err := s.idx.stat("/")
+	if err != nil {
+		return nil, err
+	}
+
+	pol, err := xml.Marshal(s.DataPlacementPolicy())
+	if err != nil {
+		return nil, err
+	}
+
+	root.Xattrs = append(root.Xattrs,
+		&proto.Xattr{Key: lockStateXattr, Value: []byte(s.volumeLockState())},
+		&proto.Xattr{Key: placementXattr, Value: pol},
+		&proto.Xattr{Key: reservedUIDXattr, Value: uidKey(reserved)},
+	)
+
+	return root, nil
+}
+
+// applyVolume applies the volume state logged with the root directory (see
+// recordVolume).
+func (s *Store) applyVolume(xattrs []*proto.Xattr) error {
+	for _, x := range xattrs {
+		switch x.Key {
+		case lockStateXattr:
+			s.lock.Lock()
+			s.lock.state = string(x.Value)
+			s.lock.Unlock()
+
+		case placementXattr:
+			var pol ltfs.DataPlacementPolicy
+			if err := xml.Unmarshal(x.Value, &pol); err != nil {
+				return errors.Wrap(err, "invalid data placement policy")
+			}
+
+			s.placement.Lock()
+			s.placement.pol = pol
+			s.placement.Unlock()
+
+		case reservedUIDXattr:
+			if len(x.Value) != 8 {
+				return errors.New("invalid reserved file UID")
+			}
+
+			if err := s.idx.reserveUID(binary.BigEndian.Uint64(x.Value)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// volumeLockState returns the lock state as recorded in the index, which is
+// empty if the volume has never been locked.
+func (s *Store) volumeLockState() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.lock.state
+}
@@ -0,0 +1,223 @@
+package bltfs_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+
+	"hpt.space/bltfs"
+	"hpt.space/bltfs/ltfs"
+)
+
+func TestVolumeLockState(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	if state := store.VolumeLockState(); state != ltfs.VolumeUnlocked {
+		t.Errorf("expected unlocked volume, got %q", state)
+	}
+
+	if err := store.LockVolume(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := store.Mkdir("/dir2")
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != bltfs.ErrVolumeLocked {
+		t.Errorf("expected ErrVolumeLocked, got %v", err)
+	}
+
+	if err := store.Setxattr("/testfile.txt", "user.a", nil); err == nil {
+		t.Error("expected setxattr to fail on a locked volume")
+	}
+
+	if err := store.Symlink("testfile.txt", "/link"); err == nil {
+		t.Error("expected symlink to fail on a locked volume")
+	}
+
+	if err := store.UnlockVolume(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Mkdir("/dir2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.PermLockVolume(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.UnlockVolume(); err != bltfs.ErrVolumePermLocked {
+		t.Errorf("expected ErrVolumePermLocked, got %v", err)
+	}
+
+	if err := store.SetVolumeLockState("sealed"); err == nil {
+		t.Error("expected error for invalid lock state")
+	}
+
+	// the lock state is recorded in the index
+	var buf bytes.Buffer
+	if _, err := store.WriteLTFSIndex(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var idx ltfs.Index
+	if err := xml.Unmarshal(buf.Bytes(), &idx); err != nil {
+		t.Fatal(err)
+	}
+
+	if idx.VolumeLockState != ltfs.VolumePermLocked {
+		t.Errorf("expected permlocked index, got %q", idx.VolumeLockState)
+	}
+}
+
+func TestOpenLockedVolume(t *testing.T) {
+	idx := makeTestIndex()
+	idx.VolumeLockState = ltfs.VolumeLocked
+
+	store, dir := openTestStore(t, idx)
+	defer cleanup(dir)
+	defer store.Close()
+
+	if _, err := store.Create("/file2"); err == nil {
+		t.Error("expected create to fail on a locked volume")
+	}
+
+	if err := store.SetDataPlacementPolicy(ltfs.DataPlacementPolicy{}); err != bltfs.ErrVolumeLocked {
+		t.Errorf("expected ErrVolumeLocked, got %v", err)
+	}
+}
+
+func TestVolumeLockStateRemount(t *testing.T) {
+	dir := makeFsckTape(t, nil)
+	defer cleanup(dir)
+
+	cacheDir, err := ioutil.TempDir("", "bltfscache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(cacheDir)
+
+	for _, tt := range []struct {
+		name string
+		open func() *bltfs.Store
+	}{
+		{"uncached", func() *bltfs.Store {
+			store, err := bltfs.Open(openTestDevice(t, dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return store
+		}},
+		{"cached", func() *bltfs.Store { return openCachedStore(t, dir, cacheDir) }},
+	} {
+		store := tt.open()
+
+		if err := store.LockVolume(); err != nil {
+			t.Fatal(err)
+		}
+
+		// the lock state is written to the volume right away
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		store = tt.open()
+
+		if state := store.VolumeLockState(); state != ltfs.VolumeLocked {
+			t.Errorf("%s: expected locked volume after remount, got %q", tt.name, state)
+		}
+
+		if err := store.Mkdir("/dir2"); err == nil {
+			t.Errorf("%s: expected mkdir to fail on a locked volume", tt.name)
+		}
+
+		if err := store.UnlockVolume(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		store = tt.open()
+
+		if state := store.VolumeLockState(); state != ltfs.VolumeUnlocked {
+			t.Errorf("%s: expected unlocked volume after remount, got %q", tt.name, state)
+		}
+
+		store.Close()
+	}
+
+	if report := checkTestTape(t, dir); !report.Consistent() {
+		t.Fatalf("unexpected problems: %v", report.Problems)
+	}
+}
+
+func TestVolumeLockStateVersion(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex(), bltfs.WithIndexVersion("2.3.0"))
+	defer cleanup(dir)
+	defer store.Close()
+
+	// the lock state cannot be written to the volume
+	if err := store.LockVolume(); err == nil {
+		t.Error("expected locking to fail for format version 2.3.0")
+	}
+
+	if state := store.VolumeLockState(); state != ltfs.VolumeUnlocked {
+		t.Errorf("expected unlocked volume, got %q", state)
+	}
+
+	if err := store.Mkdir("/dir2"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadOnlyEntry(t *testing.T) {
+	idx := makeTestIndex()
+	idx.Root.Contents.Files[0].ReadOnly = true
+
+	store, dir := openTestStore(t, idx)
+	defer cleanup(dir)
+	defer store.Close()
+
+	expectEPERM := func(op string, err error) {
+		perr, ok := err.(*os.PathError)
+		if !ok || perr.Err != syscall.EPERM {
+			t.Errorf("%s: expected EPERM, got %v", op, err)
+		}
+	}
+
+	expectEPERM("setxattr", store.Setxattr("/testfile.txt", "user.a", nil))
+	expectEPERM("removexattr", store.Removexattr("/testfile.txt", "user.a"))
+
+	_, err := store.Create("/testfile.txt")
+	expectEPERM("create", err)
+
+	f, err := store.Open("/testfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.Write([]byte("data"))
+	expectEPERM("write", err)
+
+	// no entries can be created in a read-only directory
+	if err := store.SetReadOnly("/dir", true); err != nil {
+		t.Fatal(err)
+	}
+
+	expectEPERM("mkdir", store.Mkdir("/dir/sub"))
+
+	if err := store.SetReadOnly("/dir", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Mkdir("/dir/sub"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,212 @@
+package bltfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"hpt.space/bltfs/proto"
+)
+
+// walkPageSize is the number of directory entries read at a time by Walk,
+// Glob and Find.
+const walkPageSize = 1000
+
+// A Cursor is a position in the listing of a directory (see ReadDir). The
+// empty Cursor is the start of the listing.
+type Cursor string
+
+// ReadDir returns up to n entries of the directory at path following the
+// cursor, in name key order (see NamePolicy), and the cursor of the next page.
+// The next cursor is empty if there are no more entries. If n <= 0, all
+// remaining entries are returned. Entries created or removed between pages
+// may or may not be listed. If there is an error, it will be of type
+// *os.PathError.
+func (s *Store) ReadDir(path string, cursor Cursor, n int) ([]os.FileInfo, Cursor, error) {
+	var (
+		infos []os.FileInfo
+		next  Cursor
+	)
+
+	err := s.idx.view(func(t *indexTx) error {
+		uid, dir, err := t.lookup(cleanPath(path))
+		if err != nil {
+			return err
+		}
+
+		if dir.GetDir() == nil {
+			return syscall.ENOTDIR
+		}
+
+		next, err = t.readDir(uid, cursor, n, func(e *proto.Entry) error {
+			infos = append(infos, &entryStat{e: e})
+			return nil
+		})
+
+		return err
+	})
+
+	if err != nil {
+		return nil, "", &os.PathError{Op: "readdir", Path: path, Err: err}
+	}
+
+	return infos, next, nil
+}
+
+// readDir calls fn with up to n entries of the directory with the given UID
+// following the cursor and returns the cursor of the next page (see
+// Store.ReadDir).
+func (t *indexTx) readDir(dir uint64, cursor Cursor, n int, fn func(e *proto.Entry) error) (Cursor, error) {
+	prefix := uidKey(dir)
+
+	c := t.dirents.Cursor()
+
+	k, v := c.Seek(append(prefix, cursor...))
+	if cursor != "" && k != nil && bytes.Equal(k[len(prefix):], []byte(cursor)) {
+		k, v = c.Next()
+	}
+
+	var last []byte
+	for i := 0; k != nil && bytes.HasPrefix(k, prefix); i++ {
+		if n > 0 && i == n {
+			return Cursor(last[len(prefix):]), nil
+		}
+
+		e, err := t.get(binary.BigEndian.Uint64(v))
+		if err != nil {
+			return "", err
+		}
+
+		if err := fn(e); err != nil {
+			return "", err
+		}
+
+		last = k
+		k, v = c.Next()
+	}
+
+	return "", nil
+}
+
+// Walk walks the tree rooted at root, calling fn for each entry in the tree,
+// including root, like filepath.Walk. The entries of a directory are walked
+// in name key order and read a page at a time, so Walk does not hold the
+// entries of large directories in memory. Symbolic links are not followed.
+// If fn returns filepath.SkipDir for a directory, the directory is skipped;
+// for any other entry, the remaining entries of its directory are skipped.
+func (s *Store) Walk(root string, fn filepath.WalkFunc) error {
+	e, err := s.idx.stat(cleanPath(root))
+	if err != nil {
+		err = fn(root, nil, &os.PathError{Op: "lstat", Path: root, Err: err})
+	} else {
+		err = s.walk(root, e, fn)
+	}
+
+	if err == filepath.SkipDir {
+		return nil
+	}
+
+	return err
+}
+
+func (s *Store) walk(p string, e *proto.Entry, fn filepath.WalkFunc) error {
+	info := &entryStat{e: e}
+
+	if err := fn(p, info, nil); err != nil || e.GetDir() == nil {
+		return err
+	}
+
+	var cursor Cursor
+	for {
+		var page []*proto.Entry
+
+		err := s.idx.view(func(t *indexTx) error {
+			var err error
+			cursor, err = t.readDir(e.Id, cursor, walkPageSize, func(e *proto.Entry) error {
+				page = append(page, e)
+				return nil
+			})
+
+			return err
+		})
+
+		if err != nil {
+			return fn(p, info, &os.PathError{Op: "readdir", Path: p, Err: err})
+		}
+
+		for _, child := range page {
+			err := s.walk(path.Join(p, child.Name), child, fn)
+			if err == filepath.SkipDir && child.GetDir() == nil {
+				return nil
+			}
+
+			if err != nil && err != filepath.SkipDir {
+				return err
+			}
+		}
+
+		if cursor == "" {
+			return nil
+		}
+	}
+}
+
+// Glob returns the paths of all entries matching pattern, like filepath.Glob.
+// Each element of the pattern is matched against the names of the entries
+// (see path.Match); elements without meta characters are looked up under the
+// name policy. The only possible returned error is path.ErrBadPattern.
+func (s *Store) Glob(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	matches := []string{"/"}
+
+	for _, elem := range strings.Split(cleanPath(pattern), "/") {
+		if elem == "" {
+			continue
+		}
+
+		var next []string
+
+		for _, dir := range matches {
+			if !strings.ContainsAny(elem, `*?[\`) {
+				p := path.Join(dir, elem)
+				if _, err := s.idx.stat(p); err == nil {
+					next = append(next, p)
+				}
+
+				continue
+			}
+
+			// like filepath.Glob, errors reading a directory are ignored
+			s.Walk(dir, func(p string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+
+				if p == dir {
+					return nil
+				}
+
+				if ok, _ := path.Match(elem, info.Name()); ok {
+					next = append(next, p)
+				}
+
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			})
+		}
+
+		matches = next
+	}
+
+	return matches, nil
+}
@@ -0,0 +1,207 @@
+package bltfs_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"hpt.space/bltfs"
+	"hpt.space/bltfs/util/testutil"
+)
+
+func TestWalk(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	if err := store.Mkdir("/dir/sub"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Symlink("file", "/dir/sub/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	err := store.Walk("/", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		paths = append(paths, path)
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/", "/dir", "/dir/file", "/dir/sub", "/dir/sub/link", "/testfile.txt"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("expected %v, got %v", want, paths)
+	}
+
+	paths = nil
+	err = store.Walk("/", func(path string, info os.FileInfo, err error) error {
+		paths = append(paths, path)
+
+		if path == "/dir/sub" {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = []string{"/", "/dir", "/dir/file", "/dir/sub", "/testfile.txt"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("expected %v, got %v", want, paths)
+	}
+
+	err = store.Walk("/missing", func(path string, info os.FileInfo, err error) error {
+		return err
+	})
+
+	if !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	if err := store.Mkdir("/many"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 25; i++ {
+		if err := store.Mkdir(fmt.Sprintf("/many/%02d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		names  []string
+		cursor bltfs.Cursor
+		pages  int
+	)
+
+	for {
+		infos, next, err := store.ReadDir("/many", cursor, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, fi := range infos {
+			names = append(names, fi.Name())
+		}
+
+		pages++
+
+		if next == "" {
+			break
+		}
+
+		cursor = next
+	}
+
+	if pages != 3 || len(names) != 25 || names[0] != "00" || names[24] != "24" {
+		t.Errorf("unexpected listing in %d pages: %v", pages, names)
+	}
+
+	infos, next, err := store.ReadDir("/many", "", 0)
+	if err != nil || len(infos) != 25 || next != "" {
+		t.Errorf("expected all 25 entries, got %d (%v)", len(infos), err)
+	}
+
+	if _, _, err := store.ReadDir("/testfile.txt", "", 0); err == nil {
+		t.Error("expected error listing a file")
+	}
+}
+
+func TestGlob(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	if err := store.Mkdir("/dir2"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"/*.txt", []string{"/testfile.txt"}},
+		{"/dir*", []string{"/dir", "/dir2"}},
+		{"/*/file", []string{"/dir/file"}},
+		{"/dir/file", []string{"/dir/file"}},
+		{"/nothing*", nil},
+	}
+
+	for _, tt := range tests {
+		matches, err := store.Glob(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(matches, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.pattern, tt.want, matches)
+		}
+	}
+
+	if _, err := store.Glob("/["); err == nil {
+		t.Error("expected bad pattern error")
+	}
+}
+
+func TestFind(t *testing.T) {
+	store, dir := openTestStore(t, makeTestIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	if err := store.Setxattr("/dir/file", "user.project", []byte("apollo")); err != nil {
+		t.Fatal(err)
+	}
+
+	t0 := time.Time(testutil.TestTime)
+
+	tests := []struct {
+		name string
+		q    bltfs.Query
+		want []string
+	}{
+		{"all", bltfs.Query{}, []string{"/", "/dir", "/dir/file", "/testfile.txt"}},
+		{"name", bltfs.Query{Name: "*.txt"}, []string{"/testfile.txt"}},
+		{"min size", bltfs.Query{MinSize: 6}, []string{"/dir/file"}},
+		{"max size", bltfs.Query{MaxSize: 5}, []string{"/testfile.txt"}},
+		{"time", bltfs.Query{ModifiedFrom: t0.Add(-time.Hour), ModifiedTo: t0}, nil},
+		{"xattr", bltfs.Query{Xattr: "user.project"}, []string{"/dir/file"}},
+		{"xattr value", bltfs.Query{Xattr: "user.project", XattrValue: []byte("gemini")}, nil},
+	}
+
+	for _, tt := range tests {
+		var paths []string
+		err := store.Find("/", tt.q, func(path string, info os.FileInfo) error {
+			paths = append(paths, path)
+			return nil
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(paths, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, paths)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package bltfs
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"hpt.space/bltfs/ltfs"
+	"hpt.space/bltfs/proto"
+	"hpt.space/bltfs/util/xmlutil"
+)
+
+// virtualXattr computes the value of a reserved LTFS extended attribute from
+// the index. If the attribute is not available for the entry, ok is false.
+type virtualXattr func(s *Store, e *proto.Entry) (value string, ok bool)
+
+// virtualXattrs are the reserved extended attributes (those prefixed by
+// "ltfs.") that are answered from index data instead of being stored.
+var virtualXattrs = map[string]virtualXattr{
+	"ltfs.fileUID": func(s *Store, e *proto.Entry) (string, bool) {
+		return strconv.FormatUint(e.Id, 10), true
+	},
+
+	"ltfs.createTime": timeXattr((*proto.Entry).GetCreateTime),
+	"ltfs.changeTime": timeXattr((*proto.Entry).GetChangeTime),
+	"ltfs.modifyTime": timeXattr((*proto.Entry).GetModifyTime),
+	"ltfs.accessTime": timeXattr((*proto.Entry).GetAccessTime),
+	"ltfs.backupTime": timeXattr((*proto.Entry).GetBackupTime),
+
+	"ltfs.partition": func(s *Store, e *proto.Entry) (string, bool) {
+		extents := e.GetFile().GetExtents()
+		if len(extents) == 0 {
+			return "", false
+		}
+
+		part, err := s.ltfs.pmap.ID(extents[0].Partition)
+		if err != nil {
+			return "", false
+		}
+
+		return part, true
+	},
+
+	"ltfs.startblock": func(s *Store, e *proto.Entry) (string, bool) {
+		extents := e.GetFile().GetExtents()
+		if len(extents) == 0 {
+			return "", false
+		}
+
+		return strconv.FormatUint(extents[0].Block, 10), true
+	},
+
+	"ltfs.volumeUUID": volumeXattr(func(s *Store) string {
+		return s.ltfs.label.VolumeUUID.String()
+	}),
+
+	"ltfs.volumeName": volumeXattr(func(s *Store) string {
+		return s.ltfs.curr.Root.Name
+	}),
+
+	"ltfs.volumeBlocksize": volumeXattr(func(s *Store) string {
+		return strconv.Itoa(s.ltfs.label.BlockSize)
+	}),
+
+	"ltfs.volumeFormatTime": volumeXattr(func(s *Store) string {
+		return formatTime(time.Time(s.ltfs.label.FormatTime))
+	}),
+
+	"ltfs.labelVersion": volumeXattr(func(s *Store) string {
+		return s.ltfs.label.Version
+	}),
+
+	"ltfs.indexVersion": volumeXattr(func(s *Store) string {
+		return s.ltfs.curr.Version
+	}),
+
+	"ltfs.indexCreator": volumeXattr(func(s *Store) string {
+		return s.ltfs.curr.Creator
+	}),
+
+	"ltfs.indexGeneration": volumeXattr(func(s *Store) string {
+		return strconv.Itoa(s.ltfs.curr.Generation)
+	}),
+
+	"ltfs.indexTime": volumeXattr(func(s *Store) string {
+		return formatTime(time.Time(s.ltfs.curr.UpdateTime))
+	}),
+}
+
+func timeXattr(get func(*proto.Entry) int64) virtualXattr {
+	return func(s *Store, e *proto.Entry) (string, bool) {
+		return formatTime(time.Unix(0, get(e))), true
+	}
+}
+
+// volumeXattr returns a virtual extended attribute that is only available on
+// the root directory.
+func volumeXattr(get func(*Store) string) virtualXattr {
+	return func(s *Store, e *proto.Entry) (string, bool) {
+		if e.Id != uint64(s.ltfs.curr.Root.FileUID) {
+			return "", false
+		}
+
+		return get(s), true
+	}
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(xmlutil.FormatISO8601)
+}
+
+func isReservedXattr(name string) bool {
+	return strings.HasPrefix(name, ltfs.XattrReservedPrefix)
+}
+
+// Getxattr returns the value of the extended attribute name of the entry at
+// path.
+func (s *Store) Getxattr(path, name string) ([]byte, error) {
+	e, err := s.idx.stat(cleanPath(path))
+	if err != nil {
+		return nil, &os.PathError{Op: "getxattr", Path: path, Err: err}
+	}
+
+	if isReservedXattr(name) {
+		if fn, ok := virtualXattrs[name]; ok {
+			if v, ok := fn(s, e); ok {
+				return []byte(v), nil
+			}
+		}
+
+		return nil, &os.PathError{Op: "getxattr", Path: path, Err: syscall.ENODATA}
+	}
+
+	for _, x := range e.Xattrs {
+		if x.Key == name {
+			return x.Value, nil
+		}
+	}
+
+	return nil, &os.PathError{Op: "getxattr", Path: path, Err: syscall.ENODATA}
+}
+
+// Setxattr sets the extended attribute name of the entry at path to value,
+// replacing any existing value. Reserved attributes cannot be set.
+func (s *Store) Setxattr(path, name string, value []byte) error {
+	if name == "" {
+		return &os.PathError{Op: "setxattr", Path: path, Err: syscall.EINVAL}
+	}
+
+	if isReservedXattr(name) {
+		return &os.PathError{Op: "setxattr", Path: path, Err: syscall.EPERM}
+	}
+
+	if err := s.writable(); err != nil {
+		return &os.PathError{Op: "setxattr", Path: path, Err: err}
+	}
+
+	var changed *proto.Entry
+	err := s.idx.update(cleanPath(path), func(e *proto.Entry) error {
+		if e.Readonly {
+			return syscall.EPERM
+		}
+
+		e.ChangeTime = time.Now().UnixNano()
+		changed = e
+
+		for _, x := range e.Xattrs {
+			if x.Key == name {
+				x.Value = value
+				return nil
+			}
+		}
+
+		e.Xattrs = append(e.Xattrs, &proto.Xattr{
+			Key:   name,
+			Value: value,
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return &os.PathError{Op: "setxattr", Path: path, Err: err}
+	}
+
+	s.journal.record(proto.Entry_CH, cleanPath(path), changed)
+
+	return nil
+}
+
+// Listxattr returns the names of the extended attributes of the entry at
+// path. Reserved attributes are not listed.
+func (s *Store) Listxattr(path string) ([]string, error) {
+	e, err := s.idx.stat(cleanPath(path))
+	if err != nil {
+		return nil, &os.PathError{Op: "listxattr", Path: path, Err: err}
+	}
+
+	names := make([]string, 0, len(e.Xattrs))
+	for _, x := range e.Xattrs {
+		names = append(names, x.Key)
+	}
+
+	return names, nil
+}
+
+// Removexattr removes the extended attribute name from the entry at path.
+func (s *Store) Removexattr(path, name string) error {
+	if isReservedXattr(name) {
+		return &os.PathError{Op: "removexattr", Path: path, Err: syscall.EPERM}
+	}
+
+	if err := s.writable(); err != nil {
+		return &os.PathError{Op: "removexattr", Path: path, Err: err}
+	}
+
+	var changed *proto.Entry
+	err := s.idx.update(cleanPath(path), func(e *proto.Entry) error {
+		if e.Readonly {
+			return syscall.EPERM
+		}
+
+		for i, x := range e.Xattrs {
+			if x.Key == name {
+				e.Xattrs = append(e.Xattrs[:i], e.Xattrs[i+1:]...)
+				e.ChangeTime = time.Now().UnixNano()
+				changed = e
+
+				return nil
+			}
+		}
+
+		return syscall.ENODATA
+	})
+
+	if err != nil {
+		return &os.PathError{Op: "removexattr", Path: path, Err: err}
+	}
+
+	s.journal.record(proto.Entry_CH, cleanPath(path), changed)
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package bltfs_test
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"hpt.space/bltfs"
+	"hpt.space/bltfs/engine/memory"
+	"hpt.space/bltfs/ltfs"
+	"hpt.space/bltfs/proto"
+	"hpt.space/bltfs/util/testutil"
+)
+
+func makeTestXattrIndex() *ltfs.Index {
+	idx := makeTestIndex()
+
+	idx.Root.Contents.Files[0].ExtendedAttributes = []*ltfs.ExtendedAttribute{
+		ltfs.NewExtendedAttribute("user.text", []byte("hello")),
+		ltfs.NewExtendedAttribute("user.binary", []byte{0x00, 0xff}),
+	}
+
+	return idx
+}
+
+func TestXattr(t *testing.T) {
+	store, dir := openTestStore(t, makeTestXattrIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	// attributes from the on-tape index
+	v, err := store.Getxattr("/testfile.txt", "user.binary")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(v, []byte{0x00, 0xff}) {
+		t.Errorf("unexpected value %v", v)
+	}
+
+	names, err := store.Listxattr("/testfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(names, []string{"user.text", "user.binary"}) {
+		t.Errorf("unexpected names %v", names)
+	}
+
+	// set, replace and remove on a directory
+	for _, value := range []string{"first", "second"} {
+		if err := store.Setxattr("/dir", "user.comment", []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+
+		v, err := store.Getxattr("/dir/", "user.comment")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(v) != value {
+			t.Errorf("got %q, expected %q", v, value)
+		}
+	}
+
+	if err := store.Removexattr("/dir", "user.comment"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.Getxattr("/dir", "user.comment")
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != syscall.ENODATA {
+		t.Errorf("expected ENODATA, got %v", err)
+	}
+
+	if err := store.Removexattr("/dir", "user.comment"); err == nil {
+		t.Error("expected an error")
+	}
+
+	_, err = store.Getxattr("/nonexistent", "user.comment")
+	if !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
+
+func TestXattrReserved(t *testing.T) {
+	store, dir := openTestStore(t, makeTestXattrIndex())
+	defer cleanup(dir)
+	defer store.Close()
+
+	tests := []struct {
+		path, name, value string
+	}{
+		{"/testfile.txt", "ltfs.fileUID", "2"},
+		{"/testfile.txt", "ltfs.partition", "b"},
+		{"/testfile.txt", "ltfs.startblock", "4"},
+		{"/testfile.txt", "ltfs.modifyTime", "2017-03-07T13:27:38.192689471Z"},
+		{"/dir/file", "ltfs.fileUID", "4"},
+		{"/", "ltfs.volumeUUID", testutil.TestUUID.String()},
+		{"/", "ltfs.volumeName", "testvol"},
+		{"/", "ltfs.indexGeneration", "1"},
+	}
+
+	for _, tc := range tests {
+		v, err := store.Getxattr(tc.path, tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(v) != tc.value {
+			t.Errorf("%s %s: got %q, expected %q", tc.path, tc.name, v, tc.value)
+		}
+	}
+
+	// volume attributes are only available on the root
+	if _, err := store.Getxattr("/dir", "ltfs.volumeUUID"); err == nil {
+		t.Error("expected an error")
+	}
+
+	if err := store.Setxattr("/testfile.txt", "ltfs.fileUID", []byte("7")); err == nil {
+		t.Error("expected an error")
+	}
+
+	if err := store.Removexattr("/testfile.txt", "ltfs.fileUID"); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestIndexXattrRoundTrip(t *testing.T) {
+	db := memory.New()
+	defer db.Close()
+
+	idx0 := makeTestXattrIndex()
+
+	binIdx, err := bltfs.NewIndex(idx0, ltfs.DefaultPartitionMap, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx1, err := binIdx.MakeLTFSIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e0, e1 proto.Entry
+	if err := proto.MarshalFile(idx0.Root.Contents.Files[0], &e0, ltfs.DefaultPartitionMap); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := proto.MarshalFile(idx1.Root.Contents.Files[0], &e1, ltfs.DefaultPartitionMap); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(e0.Xattrs, e1.Xattrs) {
+		t.Errorf("extended attributes differ: %v != %v", e0.Xattrs, e1.Xattrs)
+	}
+}